@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// statInterval is how often the server reports its counters back
+// to the client during a test.
+const statInterval = 500 * time.Millisecond
+
+var aborted int32
+
+func abort(reason string) {
+	if atomic.CompareAndSwapInt32(&aborted, 0, 1) {
+		fmt.Printf("test aborted: %s\n", reason)
+		emit("abort", map[string]interface{}{"reason": reason})
+	}
+}
+
+func isAborted() bool {
+	return atomic.LoadInt32(&aborted) == 1
+}
+
+// lossMonitor aborts the test if, once the evaluation window has
+// passed, the server reports loss of at least abortLoss or did not
+// report anything at all.
+type lossMonitor struct {
+	start   time.Time
+	reports int32
+}
+
+func startLossMonitor() *lossMonitor {
+	m := &lossMonitor{start: time.Now()}
+	time.AfterFunc(abortWindow, func() {
+		if atomic.LoadInt32(&m.reports) == 0 {
+			abort("no reports from server")
+		}
+	})
+	return m
+}
+
+// report handles a "stat <received> <highest packet no>" message.
+func (m *lossMonitor) report(args []string) {
+	if m == nil || len(args) != 2 {
+		return
+	}
+	atomic.AddInt32(&m.reports, 1)
+	received, err1 := strconv.Atoi(args[0])
+	highest, err2 := strconv.Atoi(args[1])
+	if err1 != nil || err2 != nil || highest == 0 || time.Since(m.start) < abortWindow {
+		return
+	}
+	loss := float64(highest-received) / float64(highest) * 100
+	if loss >= float64(abortLoss) {
+		abort(fmt.Sprintf("loss %.2f%% reported by server", loss))
+	}
+}
+
+// percent is a flag value accepting an optional trailing %.
+type percent float64
+
+func (p *percent) String() string {
+	return strconv.FormatFloat(float64(*p), 'f', -1, 64) + "%"
+}
+
+func (p *percent) Set(s string) error {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return err
+	}
+	*p = percent(v)
+	return nil
+}
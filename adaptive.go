@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// aimdIncrease is added to the rate per server report below
+	// -target-loss, as part of the rate given.
+	aimdIncrease = 0.1
+	// aimdDecrease multiplies the rate on a report above it.
+	aimdDecrease = 0.7
+	// aimdMinPackets a report must cover to count.
+	aimdMinPackets = 20
+	// adaptivePrint is how often the rate is printed.
+	adaptivePrint = 5 * time.Second
+)
+
+// aimd rides the send rate just under -target-loss with -adaptive,
+// adding to it additively while the server reports less loss and
+// cutting it multiplicatively on more, like congestion controlled
+// applications do.
+type aimd struct {
+	mu       sync.Mutex
+	t0       time.Time
+	received int
+	highest  int
+	printed  time.Time
+	samples  []adaptiveSample
+}
+
+var adapt *aimd
+
+func startAdaptive(t0 time.Time) *aimd {
+	return &aimd{t0: t0, printed: t0}
+}
+
+// report handles a "stat <received> <highest no>" message.
+func (a *aimd) report(args []string) {
+	if a == nil || len(args) != 2 {
+		return
+	}
+	received, err1 := strconv.Atoi(args[0])
+	highest, err2 := strconv.Atoi(args[1])
+	if err1 != nil || err2 != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	want, got := highest-a.highest, received-a.received
+	if want < aimdMinPackets {
+		return
+	}
+	a.received, a.highest = received, highest
+	loss := 0.0
+	if got < want {
+		loss = float64(want-got) / float64(want) * 100
+	}
+	now := time.Now()
+	rate := currentRate()
+	a.samples = append(a.samples, adaptiveSample{Offset: now.Sub(a.t0).Seconds(), Mbps: rate / 1e6, LossPct: loss})
+	if loss > float64(targetLoss) {
+		rate *= aimdDecrease
+	} else {
+		rate += aimdIncrease * float64(sendRate())
+	}
+	// the limits of changeRate
+	scale := int64(float64(sendRate()) * (1 << 16) / rate)
+	if scale < 1<<10 {
+		scale = 1 << 10
+	}
+	if scale > 1<<22 {
+		scale = 1 << 22
+	}
+	atomic.StoreInt64(&paceScale, scale)
+	if now.Sub(a.printed) >= adaptivePrint {
+		a.printed = now
+		fmt.Printf("adaptive: +%.0fs %.2f Mbit/s, loss %.2f%%\n", now.Sub(a.t0).Seconds(), currentRate()/1e6, loss)
+	}
+	emit("adaptive", map[string]interface{}{"mbps": currentRate() / 1e6, "loss_pct": loss})
+}
+
+// result returns the samples with the sustained rate, the mean rate of
+// the second half of the test when the rate has settled.
+func (a *aimd) result() *aimdResult {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r := &aimdResult{TargetLossPct: float64(targetLoss), Samples: a.samples}
+	half := a.samples[len(a.samples)/2:]
+	for _, s := range half {
+		r.SustainedMbps += s.Mbps / float64(len(half))
+	}
+	return r
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// cpuMask is a cpu_set_t of 1024 cpus.
+type cpuMask [16]uint64
+
+// allowedCPUs lists the cpus the calling thread may run on.
+func allowedCPUs() ([]int, error) {
+	var m cpuMask
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETAFFINITY, 0, unsafe.Sizeof(m), uintptr(unsafe.Pointer(&m)))
+	if errno != 0 {
+		return nil, errno
+	}
+	var cpus []int
+	for i := 0; i < len(m)*64; i++ {
+		if m[i/64]&(1<<(i%64)) != 0 {
+			cpus = append(cpus, i)
+		}
+	}
+	return cpus, nil
+}
+
+// setAffinity restricts thread tid, or the calling one if 0, to cpus.
+func setAffinity(tid int, cpus []int) error {
+	var m cpuMask
+	for _, c := range cpus {
+		if c < 0 || c >= len(m)*64 {
+			return errors.New("cpu out of range")
+		}
+		m[c/64] |= 1 << (c % 64)
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(tid), unsafe.Sizeof(m), uintptr(unsafe.Pointer(&m)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// pinThread restricts the calling thread to cpus, it should be locked
+// to its goroutine.
+func pinThread(cpus []int) error {
+	return setAffinity(0, cpus)
+}
+
+// pinProcess restricts every thread of the process to cpus. Threads
+// started later inherit it.
+func pinProcess(cpus []int) error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		tid, err := strconv.Atoi(t.Name())
+		if err != nil {
+			continue
+		}
+		// the thread may have exited meanwhile
+		if err := setAffinity(tid, cpus); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
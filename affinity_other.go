@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func init() {
+	unavailable["cpupin"] = "linux only"
+}
+
+func pinThread(cpus []int) error {
+	return errors.New("cpu pinning is linux only")
+}
+
+func pinProcess(cpus []int) error {
+	return errors.New("-cpu-pin is supported on linux only")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	resultpkg "github.com/dinalt/udptest/result"
+)
+
+func aggregate(args []string) {
+	fs := flag.NewFlagSet("aggregate", flag.ExitOnError)
+	csvPath := fs.String("csv", "", "also write per-file figures to this csv file")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: aggregate [-csv path] <result.json>...")
+		fs.PrintDefaults()
+	}
+	ep(fs.Parse(args))
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	var (
+		loss, jit []float64
+		rows      [][]string
+	)
+	rows = append(rows, []string{"file", "role", "received", "sent", "loss_pct", "jitter_ms"})
+	for _, path := range fs.Args() {
+		r := readResult(path)
+		row := []string{path, r.Role, strconv.Itoa(r.Received), strconv.Itoa(r.Sent), "", ""}
+		if r.LossPct != nil {
+			loss = append(loss, *r.LossPct)
+			row[4] = strconv.FormatFloat(*r.LossPct, 'f', 3, 64)
+		}
+		if r.JitterMs != nil {
+			jit = append(jit, *r.JitterMs)
+			row[5] = strconv.FormatFloat(*r.JitterMs, 'f', 3, 64)
+		}
+		rows = append(rows, row)
+	}
+	fmt.Printf("results: %d, with loss figures: %d\n", fs.NArg(), len(loss))
+	printSpread("loss %", loss)
+	printSpread("jitter ms", jit)
+	if *csvPath != "" {
+		f, err := os.Create(*csvPath)
+		ep(err)
+		defer f.Close()
+		w := csv.NewWriter(f)
+		ep(w.WriteAll(rows))
+	}
+}
+
+func readResult(path string) *result {
+	b, err := os.ReadFile(path)
+	ep(err)
+	r, err := resultpkg.Parse(b)
+	if err != nil {
+		panic(fmt.Sprintf("%s: %v", path, err))
+	}
+	return r
+}
+
+func printSpread(name string, v []float64) {
+	if len(v) == 0 {
+		return
+	}
+	min, med, max := spread(v)
+	fmt.Printf("%s: min %.3f, median %.3f, max %.3f\n", name, min, med, max)
+}
+
+// spread sorts v and returns its min, median and max.
+func spread(v []float64) (min, med, max float64) {
+	if len(v) == 0 {
+		return
+	}
+	sort.Float64s(v)
+	return v[0], v[len(v)/2], v[len(v)-1]
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func analyze(args []string) {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: analyze <send trace> <receive trace>")
+		os.Exit(1)
+	}
+	sent, offsets := readTrace(args[0])
+	recv, _ := readTrace(args[1])
+	var delays []time.Duration
+	for no, ts := range sent {
+		if rts, ok := recv[no]; ok {
+			delays = append(delays, time.Duration(rts-ts-offsets.at(ts)))
+		}
+	}
+	fmt.Printf("sent: %d, received: %d, matched: %d\n", len(sent), len(recv), len(delays))
+	switch {
+	case len(offsets) > 1:
+		first, last := offsets[0], offsets[len(offsets)-1]
+		fmt.Printf("clock offset applied: %v to %v, interpolated over %d samples\n",
+			time.Duration(first.offset), time.Duration(last.offset), len(offsets))
+	case len(offsets) == 1:
+		fmt.Printf("clock offset applied: %v\n", time.Duration(offsets[0].offset))
+	}
+	if len(delays) == 0 {
+		return
+	}
+	sort.Slice(delays, func(i, j int) bool { return delays[i] < delays[j] })
+	pct := func(p float64) time.Duration {
+		return delays[int(p*float64(len(delays)-1))]
+	}
+	fmt.Printf("one-way delay: min %v, median %v, p90 %v, p99 %v, max %v\n",
+		delays[0], pct(0.5), pct(0.9), pct(0.99), delays[len(delays)-1])
+}
+
+// traceOffsets are the clock offsets noted in a trace, "# offset <ns>"
+// before the test and "# offset <ns> at <unix ns>" from -resync.
+type traceOffsets []struct{ ts, offset int64 }
+
+// at interpolates the offset at ts between the samples around it.
+func (o traceOffsets) at(ts int64) int64 {
+	if len(o) == 0 {
+		return 0
+	}
+	i := sort.Search(len(o), func(i int) bool { return o[i].ts > ts })
+	if i == 0 {
+		return o[0].offset
+	}
+	if i == len(o) {
+		return o[i-1].offset
+	}
+	a, b := o[i-1], o[i]
+	return a.offset + int64(float64(b.offset-a.offset)*float64(ts-a.ts)/float64(b.ts-a.ts))
+}
+
+// readTrace returns the first timestamp recorded for every packet and
+// the clock offsets noted in the trace, if any.
+func readTrace(path string) (map[uint16]int64, traceOffsets) {
+	f, err := os.Open(path)
+	ep(err)
+	defer f.Close()
+	var offsets traceOffsets
+	res := map[uint16]int64{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "# offset ") {
+			f := strings.Fields(line[len("# offset "):])
+			o := struct{ ts, offset int64 }{}
+			o.offset, err = strconv.ParseInt(f[0], 10, 64)
+			ep(err)
+			if len(f) == 3 && f[1] == "at" {
+				o.ts, err = strconv.ParseInt(f[2], 10, 64)
+				ep(err)
+			}
+			offsets = append(offsets, o)
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			panic(fmt.Sprintf("%s: malformed line: %q", path, line))
+		}
+		no, err := strconv.ParseUint(fields[0], 10, 16)
+		ep(err)
+		ts, err := strconv.ParseInt(fields[1], 10, 64)
+		ep(err)
+		if _, ok := res[uint16(no)]; !ok {
+			res[uint16(no)] = ts
+		}
+	}
+	ep(sc.Err())
+	return res, offsets
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// announcement tells where a server with a free port, -l :0, ended up.
+type announcement struct {
+	Addr   string    `json:"addr"`
+	Role   string    `json:"role"`
+	Start  time.Time `json:"start"`
+	Labels labels    `json:"labels,omitempty"`
+}
+
+// announce prints the address of a server that asked for a free port,
+// and emits it as an event and registers it with the collector.
+func announce(local net.Addr, role string) {
+	if _, port, err := net.SplitHostPort(addr); err != nil || port != "0" && port != "" {
+		return
+	}
+	a := announcement{Addr: local.String(), Role: role, Start: time.Now(), Labels: runLabels}
+	fmt.Printf("listening on %s\n", a.Addr)
+	emit("listening", map[string]interface{}{"addr": a.Addr, "role": role})
+	if collectorURL == "" {
+		return
+	}
+	b, err := json.Marshal(a)
+	ep(err)
+	c := http.Client{Timeout: 10 * time.Second}
+	resp, err := c.Post(collectorURL+"/servers", "application/json", bytes.NewReader(b))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "collector:", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		fmt.Fprintln(os.Stderr, "collector:", resp.Status)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// burstMin consecutive missing packets make a loss burst.
+	burstMin = 10
+	// stallMin is the shortest receive gap reported as a stall, it must
+	// also be 10 times the mean gap.
+	stallMin = 50 * time.Millisecond
+	// jitterMin is the smallest arrival spacing change reported as a
+	// spike, it must also be 8 times the mean change.
+	jitterMin = time.Millisecond
+	// maxAnomalies are listed per kind, the rest only counted.
+	maxAnomalies = 20
+)
+
+// anomalyTracker looks for loss bursts, jitter spikes, stalls, reorder
+// storms and new source addresses in the receive loop.
+type anomalyTracker struct {
+	t0      time.Time
+	last    time.Time
+	gap     time.Duration
+	meanGap time.Duration
+	meanDev time.Duration
+	highest uint16
+	bursts  []lossBurst
+	bucket  time.Time
+	n       int
+	reorder int
+	sources map[string]bool
+	list    []anomaly
+	count   map[string]int
+	latest  map[string]*episode
+}
+
+// episode is the latest anomaly of a kind, i indexes list unless it
+// was left out.
+type episode struct {
+	i     int
+	until time.Time
+}
+
+// lossBurst is a gap in packet numbers, reordered packets may still
+// fill it.
+type lossBurst struct {
+	at       time.Time
+	from, to int
+}
+
+// resume moves the last arrival by a pause of d, which is no stall.
+func (t *anomalyTracker) resume(d time.Duration) {
+	if !t.last.IsZero() {
+		t.last = t.last.Add(d)
+	}
+}
+
+func (t *anomalyTracker) add(p *paket) {
+	src := ""
+	if p.from != nil {
+		src = p.from.String()
+	}
+	if t.t0.IsZero() {
+		t.t0, t.last, t.bucket = p.at, p.at, p.at
+		t.highest = p.no
+		t.sources = map[string]bool{src: true}
+		t.count = map[string]int{}
+		t.latest = map[string]*episode{}
+		return
+	}
+	gap := p.at.Sub(t.last)
+	t.last = p.at
+	if gap >= stallMin && gap > 10*t.meanGap {
+		t.note(p.at.Add(-gap), "stall", fmt.Sprintf("no packets for %v", gap.Round(time.Millisecond)))
+	} else if t.meanGap > 0 {
+		dev := gap - t.gap
+		if dev > jitterMin && dev > 8*t.meanDev {
+			t.note(p.at, "jitter", fmt.Sprintf("arrived %v late, mean spacing change %v", dev.Round(time.Microsecond), t.meanDev.Round(time.Microsecond)))
+		}
+		if dev < 0 {
+			dev = -dev
+		}
+		t.meanDev += (dev - t.meanDev) / 16
+	}
+	t.gap = gap
+	t.meanGap += (gap - t.meanGap) / 16
+
+	if p.no > t.highest {
+		if int(p.no)-int(t.highest)-1 >= burstMin {
+			t.bursts = append(t.bursts, lossBurst{p.at, int(t.highest) + 1, int(p.no) - 1})
+		}
+		t.highest = p.no
+	} else {
+		t.reorder++
+	}
+	t.n++
+	if p.at.Sub(t.bucket) >= statInterval {
+		if t.reorder >= 10 && t.reorder*20 >= t.n {
+			t.note(t.bucket, "reorder", fmt.Sprintf("%d of %d packets out of order in %v", t.reorder, t.n, p.at.Sub(t.bucket).Round(time.Millisecond)))
+		}
+		t.bucket, t.n, t.reorder = p.at, 0, 0
+	}
+
+	// several source ports at the start are -spray-ports
+	if !t.sources[src] {
+		t.sources[src] = true
+		if p.at.Sub(t.t0) > statInterval {
+			t.note(p.at, "source", "new source address "+src)
+		}
+	}
+}
+
+func (t *anomalyTracker) note(at time.Time, kind, detail string) {
+	if e := t.latest[kind]; e != nil && at.Sub(e.until) <= statInterval {
+		e.until = at
+		if e.i >= 0 {
+			t.list[e.i].Count++
+			t.list[e.i].Until = at
+		}
+		return
+	}
+	e := &episode{i: -1, until: at}
+	t.latest[kind] = e
+	t.count[kind]++
+	if t.count[kind] > maxAnomalies {
+		return
+	}
+	e.i = len(t.list)
+	t.list = append(t.list, anomaly{At: at, Offset: at.Sub(t.t0).Seconds(), Kind: kind, Detail: detail, Count: 1, Until: at})
+}
+
+// finish reports the loss bursts that were not filled by reordered
+// packets and returns all anomalies by time.
+func (t *anomalyTracker) finish(seen []bool) []anomaly {
+	for _, b := range t.bursts {
+		missing := 0
+		for n := b.from; n <= b.to; n++ {
+			if !seen[n] {
+				missing++
+			}
+		}
+		if missing >= burstMin {
+			t.note(b.at, "loss", fmt.Sprintf("%d of packets %d-%d missing", missing, b.from, b.to))
+		}
+	}
+	t.bursts = nil
+	sort.SliceStable(t.list, func(i, j int) bool { return t.list[i].At.Before(t.list[j].At) })
+	return t.list
+}
+
+// summary lists the anomalies and counts the ones left out by kind.
+func (t *anomalyTracker) summary() string {
+	if len(t.list) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "anomalies: %d\n", len(t.list))
+	for _, a := range t.list {
+		fmt.Fprintf(&b, "  %s\n", a)
+	}
+	var kinds []string
+	for k, n := range t.count {
+		if n > maxAnomalies {
+			kinds = append(kinds, fmt.Sprintf("%d %s", n-maxAnomalies, k))
+		}
+	}
+	if len(kinds) > 0 {
+		sort.Strings(kinds)
+		fmt.Fprintf(&b, "  not listed: %s\n", strings.Join(kinds, ", "))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// bbrRounds is the length of the max filter window in round trips.
+const bbrRounds = 10
+
+// deliveryRate estimates the bottleneck bandwidth and round trip time
+// from echo replies the way BBR does, without saturating the path:
+// every reply gives a rate sample, the bytes delivered between the
+// send of its packet and the reply over the longer of the send and the
+// reply interval. The bandwidth is the largest sample of a window of
+// bbrRounds round trips, reported as the median over the windows.
+type deliveryRate struct {
+	// delivered bytes, the time of the latest reply and the send
+	// time of its packet; written by ack, read by sent
+	delivered     int64
+	deliveredTime int64
+	firstSent     int64
+	// the same as of each packet's send, by packet no
+	pktDelivered []int64
+	pktTime      []int64
+	pktFirst     []int64
+	// only used by ack, under echoStats.mu
+	round     int
+	roundEnd  int64
+	windowMax []rateSample
+	minRTT    time.Duration
+}
+
+// rateSample is app limited if the sends, not the replies, took
+// longer: the path could have delivered faster.
+type rateSample struct {
+	bps        float64
+	appLimited bool
+}
+
+func newDeliveryRate(n int) *deliveryRate {
+	return &deliveryRate{
+		pktDelivered: make([]int64, n),
+		pktTime:      make([]int64, n),
+		pktFirst:     make([]int64, n),
+	}
+}
+
+// sent records the state as of sending packet no at ts.
+func (d *deliveryRate) sent(no uint16, ts int64) {
+	first := atomic.LoadInt64(&d.firstSent)
+	dt := atomic.LoadInt64(&d.deliveredTime)
+	if dt == 0 {
+		// nothing delivered yet, start counting from the first send
+		first, dt = ts, ts
+		atomic.CompareAndSwapInt64(&d.firstSent, 0, ts)
+		atomic.CompareAndSwapInt64(&d.deliveredTime, 0, ts)
+	}
+	atomic.StoreInt64(&d.pktDelivered[no], atomic.LoadInt64(&d.delivered))
+	atomic.StoreInt64(&d.pktTime[no], dt)
+	atomic.StoreInt64(&d.pktFirst[no], first)
+}
+
+// ack takes the sample of the reply to packet no sent at sent.
+func (d *deliveryRate) ack(no uint16, sent int64, now time.Time, rtt time.Duration) {
+	if d.minRTT == 0 || rtt < d.minRTT {
+		d.minRTT = rtt
+	}
+	pd := atomic.LoadInt64(&d.pktDelivered[no])
+	delivered := atomic.AddInt64(&d.delivered, int64(pktSize))
+	atomic.StoreInt64(&d.deliveredTime, now.UnixNano())
+	atomic.StoreInt64(&d.firstSent, sent)
+	if pd >= d.roundEnd {
+		d.round++
+		d.roundEnd = delivered
+	}
+	sendElapsed := sent - atomic.LoadInt64(&d.pktFirst[no])
+	ackElapsed := now.UnixNano() - atomic.LoadInt64(&d.pktTime[no])
+	interval := sendElapsed
+	if ackElapsed > interval {
+		interval = ackElapsed
+	}
+	// shorter than a round trip is more noise than rate
+	if interval <= 0 || time.Duration(interval) < d.minRTT {
+		return
+	}
+	s := rateSample{float64(delivered-pd) * 8 * float64(time.Second) / float64(interval), sendElapsed >= ackElapsed}
+	w := d.round / bbrRounds
+	for len(d.windowMax) <= w {
+		d.windowMax = append(d.windowMax, rateSample{})
+	}
+	if s.bps > d.windowMax[w].bps {
+		d.windowMax[w] = s
+	}
+}
+
+func (d *deliveryRate) estimate() *bottleneck {
+	var maxes []rateSample
+	for _, m := range d.windowMax {
+		if m.bps > 0 {
+			maxes = append(maxes, m)
+		}
+	}
+	if len(maxes) == 0 {
+		return nil
+	}
+	sort.Slice(maxes, func(i, j int) bool { return maxes[i].bps < maxes[j].bps })
+	bw := maxes[len(maxes)/2]
+	return &bottleneck{
+		Mbps:       bw.bps / 1e6,
+		MinRTTMs:   ms(d.minRTT),
+		BDPBytes:   int(bw.bps / 8 * d.minRTT.Seconds()),
+		AppLimited: bw.appLimited,
+	}
+}
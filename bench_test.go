@@ -0,0 +1,175 @@
+package main
+
+// Benchmarks of the hot path. Compare a change against master with
+//
+//	go test -run - -bench . -benchmem -count 10 > new.txt
+//
+// and benchstat, watching pps and allocs/op.
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+// replayConn returns the same datagram on every read.
+type replayConn struct {
+	net.PacketConn
+	b    []byte
+	from net.Addr
+}
+
+func (c *replayConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	return copy(b, c.b), c.from, nil
+}
+
+func (c *replayConn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+func benchPayload() []byte {
+	b := make([]byte, pktSize-pktInfSize)
+	rand.Read(b)
+	return b
+}
+
+func BenchmarkApply(b *testing.B) {
+	var p paket
+	pl := benchPayload()
+	b.ReportAllocs()
+	b.SetBytes(int64(pktSize))
+	for i := 0; i < b.N; i++ {
+		p.apply(uint16(i%pktMaxCount+1), pl)
+	}
+}
+
+func BenchmarkReadFrom(b *testing.B) {
+	var src, p paket
+	src.apply(1, benchPayload())
+	con := &replayConn{b: src.raw, from: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}}
+	b.ReportAllocs()
+	b.SetBytes(int64(pktSize))
+	for i := 0; i < b.N; i++ {
+		if err := p.readFrom(con, time.Time{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStoreSave(b *testing.B) {
+	defer func(mem bool) { useMem = mem }(useMem)
+	useMem = true
+	var (
+		s store
+		p paket
+	)
+	pl := benchPayload()
+	b.ReportAllocs()
+	b.SetBytes(int64(pktSize - pktInfSize))
+	for i := 0; i < b.N; i++ {
+		if i%pktCount == 0 {
+			// a fresh store without counting its allocation
+			b.StopTimer()
+			s = store{}
+			p.apply(1, pl)
+			p.data = p.raw[pktHdrSize : len(p.raw)-pktEndSize]
+			s.save(&p)
+			s.used[1] = false
+			s.sums = make(map[uint64]uint16, pktCount)
+			b.StartTimer()
+		}
+		// distinct payloads like random ones
+		pl[0], pl[1] = byte(i), byte(i>>8)
+		p.apply(uint16(i%pktCount+1), pl)
+		p.data = p.raw[pktHdrSize : len(p.raw)-pktEndSize]
+		s.save(&p)
+	}
+}
+
+func BenchmarkPayload(b *testing.B) {
+	src := newPayloadSource(1, 1)
+	pl := make([]byte, pktSize-pktInfSize)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(pl)))
+	for i := 0; i < b.N; i++ {
+		if err := src.Next(pl); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkPacer measures what a wait costs when the schedule is
+// already due, the pacing overhead per packet at high rates.
+func BenchmarkPacer(b *testing.B) {
+	p := newPacer(time.Now(), time.Nanosecond)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p.wait()
+	}
+}
+
+// BenchmarkLoopback sends packets to a socket on the loopback and
+// parses them there, with at most window packets in flight so the
+// receive buffer does not overflow.
+func BenchmarkLoopback(b *testing.B) {
+	const window = 32
+	srv, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer srv.Close()
+	con, err := net.DialUDP("udp", nil, srv.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer con.Close()
+	credit := make(chan struct{}, window)
+	for i := 0; i < window; i++ {
+		credit <- struct{}{}
+	}
+	done := make(chan error)
+	go func() {
+		var p paket
+		for i := 0; i < b.N; i++ {
+			if err := p.readFrom(srv, time.Now().Add(time.Second)); err != nil {
+				done <- err
+				return
+			}
+			credit <- struct{}{}
+		}
+		done <- nil
+	}()
+	var pkt paket
+	pl := benchPayload()
+	b.ReportAllocs()
+	b.SetBytes(int64(pktSize))
+	b.ResetTimer()
+	t0 := time.Now()
+	for i := 0; i < b.N; i++ {
+		<-credit
+		pkt.apply(uint16(i%pktMaxCount+1), pl)
+		if _, err := con.Write(pkt.raw); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := <-done; err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(b.N)/time.Since(t0).Seconds(), "pps")
+}
+
+// TestHotPathAllocs fails when packet encoding or parsing starts to
+// allocate, which shows at high rates as gc pauses and drops.
+func TestHotPathAllocs(t *testing.T) {
+	var src, p paket
+	pl := benchPayload()
+	src.apply(1, pl)
+	con := &replayConn{b: src.raw, from: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}}
+	if n := testing.AllocsPerRun(1000, func() { src.apply(2, pl) }); n > 0 {
+		t.Errorf("apply: %v allocs per packet", n)
+	}
+	if n := testing.AllocsPerRun(1000, func() { _ = p.readFrom(con, time.Time{}) }); n > 0 {
+		t.Errorf("readFrom: %v allocs per packet", n)
+	}
+}
@@ -0,0 +1,121 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package main
+
+import (
+	"encoding/binary"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Just enough of the bpf syscall for the hand assembled programs of
+// -xdp and -drops.
+
+const (
+	bpfMapLookupElem      = 1
+	bpfMapUpdateElem      = 2
+	bpfMapCreate          = 0
+	bpfProgLoad           = 5
+	bpfLinkCreate         = 28
+	bpfMapTypeArray       = 2
+	bpfMapTypeXskmap      = 17
+	bpfProgTypeTracepoint = 5
+	bpfProgTypeXDP        = 6
+	bpfAttachXDP          = 37
+	bpfHelperLookupElem   = 1
+	bpfHelperRedirectMap  = 51
+	bpfPseudoMapFd        = 1
+
+	// instruction codes
+	bpfLdxw   = 0x61
+	bpfLdxh   = 0x69
+	bpfLdxb   = 0x71
+	bpfStxw   = 0x63
+	bpfXaddDw = 0xdb
+	bpfMovx   = 0xbf
+	bpfMovk   = 0xb7
+	bpfAddk   = 0x07
+	bpfJa     = 0x05
+	bpfJgtx   = 0x2d
+	bpfJgek   = 0x35
+	bpfJeqk   = 0x15
+	bpfJnek   = 0x55
+	bpfCall   = 0x85
+	bpfExit   = 0x95
+	bpfLdImm  = 0x18
+)
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	nr := map[string]uintptr{"amd64": 321, "arm64": 280}[runtime.GOARCH]
+	fd, _, errno := syscall.Syscall(nr, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(fd), nil
+}
+
+// bpfInsn is struct bpf_insn, regs holds dst in the low and src in the
+// high nibble.
+type bpfInsn struct {
+	code uint8
+	regs uint8
+	off  int16
+	imm  int32
+}
+
+func insn(code, dst, src uint8, off int16, imm int32) bpfInsn {
+	return bpfInsn{code: code, regs: dst | src<<4, off: off, imm: imm}
+}
+
+// ldMap is the two instruction load of map fd into dst.
+func ldMap(dst uint8, fd int) []bpfInsn {
+	return []bpfInsn{insn(bpfLdImm, dst, bpfPseudoMapFd, 0, int32(fd)), {}}
+}
+
+// be16 returns the network order v as programs load it.
+func be16(v uint16) int32 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return int32(*(*uint16)(unsafe.Pointer(&b[0])))
+}
+
+func bpfMap(typ, keySize, valueSize, entries uint32) (int, error) {
+	attr := struct {
+		mapType, keySize, valueSize, maxEntries, flags uint32
+	}{typ, keySize, valueSize, entries, 0}
+	return bpf(bpfMapCreate, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
+
+type bpfElemAttr struct {
+	mapFd      uint32
+	_          uint32
+	key, value uint64
+	flags      uint64
+}
+
+func bpfUpdate(fd int, key, value unsafe.Pointer) error {
+	attr := bpfElemAttr{mapFd: uint32(fd), key: uint64(uintptr(key)), value: uint64(uintptr(value))}
+	_, err := bpf(bpfMapUpdateElem, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	return err
+}
+
+func bpfLookup(fd int, key, value unsafe.Pointer) error {
+	attr := bpfElemAttr{mapFd: uint32(fd), key: uint64(uintptr(key)), value: uint64(uintptr(value))}
+	_, err := bpf(bpfMapLookupElem, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	return err
+}
+
+func bpfLoad(typ uint32, prog []bpfInsn) (int, error) {
+	license := []byte("GPL\x00")
+	attr := struct {
+		progType, insnCnt uint32
+		insns, license    uint64
+	}{typ, uint32(len(prog)), uint64(uintptr(unsafe.Pointer(&prog[0]))), uint64(uintptr(unsafe.Pointer(&license[0])))}
+	fd, err := bpf(bpfProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(prog)
+	runtime.KeepAlive(license)
+	return fd, err
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// acceptWait is how long the client waits for seen or busy after
+	// sending start; responders and older servers answer neither.
+	acceptWait = 300 * time.Millisecond
+	// maxBackoff caps the doubling wait between start attempts.
+	maxBackoff = time.Minute
+)
+
+// refuseStart handles the datagrams other clients send a session's
+// socket before their test: start commands are queued or answered
+// with busy and the time the session, which received n packets since
+// t0, still needs; sync probes and queries are answered as usual. It
+// reports whether p.raw was one of them.
+func refuseStart(con net.PacketConn, p *paket, client net.Addr, t0 time.Time, n int) bool {
+	if cmd := parseStart(p.raw, p.from); cmd != nil {
+		cmd.local = p.dst
+		if p.from.String() != client.String() && admit(con, cmd, len(p.raw)) {
+			left := remaining(t0, n, pktCount)
+			answerStart(con, cmd, left, time.Since(t0)+left)
+		}
+		return true
+	}
+	return replySync(con, p.raw, p.from, p.dst, p.at) || answerQuery(con, p.raw, p.from, p.dst, true)
+}
+
+// remaining estimates how long a session that received n of count
+// packets since t0 still takes.
+func remaining(t0 time.Time, n, count int) time.Duration {
+	d := idleTimeout
+	if n > 0 {
+		d = time.Since(t0) / time.Duration(n) * time.Duration(count-n)
+	}
+	return d + grace
+}
+
+func sameHost(a, b net.Addr) bool {
+	ua, ok := a.(*net.UDPAddr)
+	ub, ok2 := b.(*net.UDPAddr)
+	return ok && ok2 && ua.IP.Equal(ub.IP)
+}
+
+// startTest sends the start command on con until the server takes it.
+// A busy server tells how long its session still needs, the client
+// waits that long or its backoff, doubling up to maxBackoff, until
+// -setup-timeout. A server with -queue instead tells the position in
+// its queue and starts the test with seen when it is the client's turn.
+func startTest(con net.Conn, start []byte) {
+	var deadline time.Time
+	if setupTimeout > 0 {
+		deadline = time.Now().Add(setupTimeout)
+	}
+	backoff := time.Second
+	wait := acceptWait
+	pos := ""
+	cookie := false
+	for send := true; ; {
+		if send {
+			_, err := con.Write(start)
+			ep(err)
+		}
+		cmd, args := awaitAccept(con, wait)
+		if !deadline.IsZero() && pos != "" && time.Now().After(deadline) {
+			fmt.Fprintln(os.Stderr, "still queued after -setup-timeout")
+			os.Exit(1)
+		}
+		if cmd == "" && pos != "" {
+			// a repeated start command keeps the place
+			send = true
+			continue
+		}
+		if cmd == "queued" {
+			if len(args) == 2 && args[0] != pos {
+				pos = args[0]
+				eta, _ := strconv.Atoi(args[1])
+				fmt.Printf("queued at position %s, about %v to wait\n", pos, time.Duration(eta)*time.Millisecond)
+				emit("queued", map[string]interface{}{"position": pos, "wait_ms": eta})
+			}
+			wait, send = queueRefresh, false
+			continue
+		}
+		if cmd == "retry" && len(args) == 1 && !cookie {
+			start = append(start[:len(start):len(start)], " cookie "+args[0]...)
+			cookie = true
+			continue
+		}
+		if cmd == "retry" {
+			// a server still asking has another key
+			fmt.Fprintln(os.Stderr, "server refused the test: cookie not accepted")
+			os.Exit(1)
+		}
+		if cmd == "error" {
+			fmt.Fprintln(os.Stderr, "server refused the test:", strings.Join(args, " "))
+			os.Exit(1)
+		}
+		if cmd != "busy" {
+			peerVersion = parseVersion(args)
+			return
+		}
+		pos, wait, send = "", acceptWait, true
+		pause := backoff
+		if len(args) > 0 {
+			n, _ := strconv.Atoi(args[0])
+			if retry := time.Duration(n) * time.Millisecond; retry > pause {
+				pause = retry
+			}
+		}
+		if !deadline.IsZero() && time.Now().Add(pause).After(deadline) {
+			fmt.Fprintln(os.Stderr, "server busy with another client until after -setup-timeout")
+			os.Exit(1)
+		}
+		fmt.Printf("server busy with another client, retrying in %v\n", pause.Round(time.Millisecond))
+		emit("busy", map[string]interface{}{"retry_ms": ms(pause)})
+		time.Sleep(pause)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// awaitAccept waits up to wait for the server to answer start with
+// seen, busy or queued and returns the answer, or nothing.
+func awaitAccept(con net.Conn, wait time.Duration) (string, []string) {
+	var pkt paket
+	pkt.buf = make([]byte, pktMaxSize)
+	deadline := time.Now().Add(wait)
+	for {
+		err := pkt.readValid(con.(net.PacketConn), deadline)
+		if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, syscall.ECONNREFUSED) {
+			// the test itself reports a missing server
+			return "", nil
+		}
+		ep(err)
+		if !pkt.isCtl() {
+			continue
+		}
+		switch cmd, args := pkt.ctl(); cmd {
+		case "seen":
+			handleCtl(&pkt, con.LocalAddr())
+			return cmd, args
+		case "busy", "queued", "error", "retry":
+			return cmd, args
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"syscall"
+)
+
+// startBusyDrain drains q by spinning on the non-blocking socket from a
+// thread pinned to the last cpu the process may use, so packets are
+// timestamped as they arrive instead of after a wakeup.
+func startBusyDrain(q *queuedConn) error {
+	uc, ok := q.PacketConn.(*net.UDPConn)
+	if !ok {
+		return errors.New("busy polling needs a plain udp socket")
+	}
+	fd, err := sysFd(uc)
+	if err != nil {
+		return err
+	}
+	cpus, err := allowedCPUs()
+	if err != nil {
+		return err
+	}
+	pinned := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+		err := pinThread(cpus[len(cpus)-1:])
+		pinned <- err
+		if err != nil {
+			return
+		}
+		for {
+			it := q.pool.Get().(*rxItem)
+			var from syscall.Sockaddr
+			for {
+				it.n, it.oobn, _, from, it.err = syscall.Recvmsg(fd, it.buf, it.oob, syscall.MSG_DONTWAIT)
+				if it.err != syscall.EAGAIN && it.err != syscall.EINTR {
+					break
+				}
+			}
+			if it.err != nil {
+				q.queue <- it
+				return
+			}
+			switch a := from.(type) {
+			case *syscall.SockaddrInet4:
+				it.addr = &net.UDPAddr{IP: net.IP(append([]byte{}, a.Addr[:]...)), Port: a.Port}
+			case *syscall.SockaddrInet6:
+				it.addr = &net.UDPAddr{IP: net.IP(append([]byte{}, a.Addr[:]...)), Port: a.Port}
+			}
+			q.push(it)
+		}
+	}()
+	return <-pinned
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func init() {
+	unavailable["busypoll"] = "linux only"
+}
+
+func startBusyDrain(q *queuedConn) error {
+	return errors.New("busy polling is linux only")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// feature is a platform dependent option. The files stubbing one out
+// for a platform register it in unavailable, and options asking for it
+// are turned off with a note at startup rather than failing later.
+type feature struct {
+	id   string
+	name string
+	flag string
+	used func() bool
+	off  func()
+}
+
+// unavailable holds why features are missing on this platform by id.
+var unavailable = map[string]string{}
+
+var features = []feature{
+	{"ttl", "ttl and hop limit", "-hops",
+		func() bool { return hopReport }, func() { hopReport = false }},
+	{"tos", "tos and traffic class", "-dscp, -path-key dscp",
+		func() bool { return len(dscps) > 0 || pathKeys.has("dscp") },
+		func() { dscps = nil; pathKeys.drop("dscp") }},
+	{"flowlabel", "ipv6 flow labels", "-flowlabel, -path-key flowlabel",
+		func() bool { return len(flowLabels) > 0 || pathKeys.has("flowlabel") },
+		func() { flowLabels = nil; pathKeys.drop("flowlabel") }},
+	{"fwmark", "socket marks", "-fwmark",
+		func() bool { return fwMark != 0 }, func() { fwMark = 0 }},
+	{"gso", "udp segmentation offload", "-gso",
+		func() bool { return gsoSegs > 1 }, func() { gsoSegs = 1 }},
+	{"pmtu", "path mtu", "", nil, nil},
+	{"busypoll", "busy polling", "-busy-poll",
+		func() bool { return busyPoll > 0 }, func() { busyPoll = 0 }},
+	{"cpupin", "cpu pinning", "-cpu-pin",
+		func() bool { return cpuPin != "" }, func() { cpuPin = "" }},
+	{"uring", "io_uring", "-io uring",
+		func() bool { return ioBackend == "uring" }, func() { ioBackend = "std" }},
+	{"xdp", "AF_XDP", "-xdp",
+		func() bool { return xdpIface != "" }, func() { xdpIface = "" }},
+	{"drops", "eBPF drop attribution", "-drops",
+		func() bool { return watchDrops }, func() { watchDrops = false }},
+	{"hwts", "hardware tx timestamps", "-hw-tx-ts",
+		func() bool { return hwTxStamps }, func() { hwTxStamps = false }},
+	{"raw", "raw socket sends", "-unsafe-raw",
+		func() bool { return unsafeRaw.on }, func() { unsafeRaw.on = false }},
+}
+
+// degrade turns off the requested options this platform lacks.
+func degrade() {
+	for _, f := range features {
+		why, missing := unavailable[f.id]
+		if missing && f.used != nil && f.used() {
+			fmt.Fprintf(os.Stderr, "%s: %s unavailable on %s (%s), continuing without\n", f.flag, f.name, runtime.GOOS, why)
+			f.off()
+		}
+	}
+}
+
+func printCaps() {
+	for _, f := range features {
+		s := "available"
+		if why, missing := unavailable[f.id]; missing {
+			s = "unavailable on " + runtime.GOOS + ", " + why
+		}
+		fmt.Printf("%s: %s\n", f.name, s)
+	}
+}
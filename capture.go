@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/dinalt/udptest/sink"
+)
+
+// captureSink watches interval loss and, once it reaches -capture-loss,
+// writes the packets of the following -capture-window to a pcapng file,
+// so intermittent events leave evidence without recording every test.
+// Packets get synthesized ip and udp headers, the gaps in numbering are
+// noted as packet comments.
+type captureSink struct {
+	path     string
+	local    *net.UDPAddr
+	f        *os.File
+	until    time.Time
+	received int
+	highest  uint16
+	prev     uint16
+	note     string
+	buf      []byte
+}
+
+func newCaptureSink(path string) *captureSink {
+	local, err := net.ResolveUDPAddr("udp", addr)
+	ep(err)
+	return &captureSink{path: path, local: local}
+}
+
+func (c *captureSink) Interval(received int, highest uint16) {
+	if received < c.received {
+		// a new session
+		c.received, c.highest = 0, 0
+	}
+	got := received - c.received
+	want := int(highest) - int(c.highest)
+	c.received, c.highest = received, highest
+	if want <= 0 {
+		return
+	}
+	loss := float64(want-got) / float64(want) * 100
+	if loss < float64(captureLoss) {
+		return
+	}
+	now := time.Now()
+	if now.After(c.until) {
+		fmt.Printf("interval loss %.1f%%, capturing %v to %s\n", loss, captureWindow, c.path)
+	}
+	c.until = now.Add(captureWindow)
+	c.note = fmt.Sprintf("triggered: %d of %d packets missing (%.1f%%) up to #%d", want-got, want, loss, highest)
+	emit("capture", map[string]interface{}{"loss_pct": loss, "highest": highest, "path": c.path})
+}
+
+func (c *captureSink) Packet(p *sink.Packet) {
+	if c.until.IsZero() || p.At.After(c.until) {
+		return
+	}
+	if c.f == nil {
+		f, err := os.OpenFile(c.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		ep(err)
+		c.f = f
+		if fi, _ := f.Stat(); fi.Size() == 0 {
+			c.header()
+		}
+	}
+	note := c.note
+	if c.note == "" && p.No > c.prev+1 {
+		note = fmt.Sprintf("gap: #%d-#%d missing", c.prev+1, p.No-1)
+	}
+	c.note = ""
+	c.prev = p.No
+	c.write(p, note)
+}
+
+// header writes a section header and one raw ip interface with
+// nanosecond timestamps.
+func (c *captureSink) header() {
+	shb := make([]byte, 28)
+	binary.LittleEndian.PutUint32(shb, 0x0a0d0d0a)
+	binary.LittleEndian.PutUint32(shb[4:], 28)
+	binary.LittleEndian.PutUint32(shb[8:], 0x1a2b3c4d)
+	binary.LittleEndian.PutUint16(shb[12:], 1)
+	binary.LittleEndian.PutUint64(shb[16:], ^uint64(0))
+	binary.LittleEndian.PutUint32(shb[24:], 28)
+	idb := make([]byte, 32)
+	binary.LittleEndian.PutUint32(idb, 1)
+	binary.LittleEndian.PutUint32(idb[4:], 32)
+	binary.LittleEndian.PutUint16(idb[8:], 101) // LINKTYPE_RAW
+	binary.LittleEndian.PutUint32(idb[12:], 0xffff)
+	binary.LittleEndian.PutUint16(idb[16:], 9) // if_tsresol
+	binary.LittleEndian.PutUint16(idb[18:], 1)
+	idb[20] = 9
+	binary.LittleEndian.PutUint32(idb[28:], 32)
+	_, err := c.f.Write(append(shb, idb...))
+	ep(err)
+}
+
+// write appends p as an enhanced packet block.
+func (c *captureSink) write(p *sink.Packet, comment string) {
+	ip := c.headers(p)
+	n := len(ip)
+	pad := (4 - n%4) % 4
+	opt := 0
+	if comment != "" {
+		opt = 4 + len(comment) + (4-len(comment)%4)%4 + 4
+	}
+	size := 28 + n + pad + opt + 4
+	b := make([]byte, 28, size)
+	ts := uint64(p.At.UnixNano())
+	binary.LittleEndian.PutUint32(b, 6)
+	binary.LittleEndian.PutUint32(b[4:], uint32(size))
+	binary.LittleEndian.PutUint32(b[12:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(b[16:], uint32(ts))
+	binary.LittleEndian.PutUint32(b[20:], uint32(n))
+	binary.LittleEndian.PutUint32(b[24:], uint32(n))
+	b = append(b, ip...)
+	b = append(b, make([]byte, pad)...)
+	if comment != "" {
+		b = append(b, 1, 0, 0, 0)
+		binary.LittleEndian.PutUint16(b[len(b)-2:], uint16(len(comment)))
+		b = append(b, comment...)
+		b = append(b, make([]byte, (4-len(comment)%4)%4)...)
+		b = append(b, 0, 0, 0, 0)
+	}
+	b = append(b, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(b[len(b)-4:], uint32(size))
+	_, err := c.f.Write(b)
+	ep(err)
+}
+
+// headers returns p.Raw behind ip and udp headers built from the
+// sender and the listen address; checksums are left zero.
+func (c *captureSink) headers(p *sink.Packet) []byte {
+	from, _ := p.From.(*net.UDPAddr)
+	if from == nil {
+		from = &net.UDPAddr{IP: net.IPv4zero}
+	}
+	ttl := p.TTL
+	if ttl == 0 {
+		ttl = 64
+	}
+	udpLen := 8 + len(p.Raw)
+	b := c.buf[:0]
+	if src := from.IP.To4(); src != nil {
+		dst := c.local.IP.To4()
+		if dst == nil {
+			dst = net.IPv4zero.To4()
+		}
+		b = append(b, 0x45, byte(p.TOS), 0, 0, 0, 0, 0x40, 0, byte(ttl), 17, 0, 0)
+		binary.BigEndian.PutUint16(b[2:], uint16(20+udpLen))
+		b = append(append(b, src...), dst...)
+		binary.BigEndian.PutUint16(b[10:], ipChecksum(b))
+	} else {
+		dst := c.local.IP.To16()
+		if dst == nil || c.local.IP.To4() != nil {
+			dst = net.IPv6zero
+		}
+		b = append(b, 0, 0, 0, 0, 0, 0, 17, byte(ttl))
+		binary.BigEndian.PutUint32(b, 6<<28|uint32(p.TOS)<<20|p.Flow&0xfffff)
+		binary.BigEndian.PutUint16(b[4:], uint16(udpLen))
+		b = append(append(b, from.IP.To16()...), dst...)
+	}
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+	u := b[len(b)-8:]
+	binary.BigEndian.PutUint16(u, uint16(from.Port))
+	binary.BigEndian.PutUint16(u[2:], uint16(c.local.Port))
+	binary.BigEndian.PutUint16(u[4:], uint16(udpLen))
+	c.buf = append(b, p.Raw...)
+	return c.buf
+}
+
+func ipChecksum(h []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(h); i += 2 {
+		sum += uint32(h[i])<<8 | uint32(h[i+1])
+	}
+	if len(h)%2 == 1 {
+		sum += uint32(h[len(h)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
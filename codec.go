@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Packets on the wire are
+//
+//	[no u16 LE][payload size u16 LE][payload][\r\n]
+//
+// with number 0 reserved for control packets whose payload is a text
+// command. Encode and Decode never panic, Decode in particular is fed
+// whatever arrives on the socket.
+
+// packetError reports a malformed packet.
+type packetError string
+
+func (e packetError) Error() string {
+	return string(e)
+}
+
+// Errors returned by Encode and Decode.
+const (
+	ErrShort   = packetError("to few bytes received")
+	ErrSize    = packetError("expected and received packet size are not match")
+	ErrEnd     = packetError("unexpected packet end")
+	ErrNoRoom  = packetError("payload to long for buffer")
+	ErrTooLong = packetError("payload to long")
+)
+
+// Encode writes packet no with payload to b and returns its length.
+func Encode(b []byte, no uint16, payload []byte) (int, error) {
+	if len(payload) > pktMaxSize-pktInfSize {
+		return 0, ErrTooLong
+	}
+	n := pktInfSize + len(payload)
+	if n > len(b) {
+		return 0, ErrNoRoom
+	}
+	binary.LittleEndian.PutUint16(b, no)
+	binary.LittleEndian.PutUint16(b[pktNoSize:], uint16(len(payload)))
+	copy(b[pktHdrSize:], payload)
+	copy(b[pktHdrSize+len(payload):], pktEnd)
+	return n, nil
+}
+
+// Decode returns the number and payload of the packet in b, the payload
+// shares b.
+func Decode(b []byte) (no uint16, payload []byte, err error) {
+	if len(b) < pktInfSize {
+		return 0, nil, ErrShort
+	}
+	size := binary.LittleEndian.Uint16(b[pktNoSize:])
+	if len(b)-pktInfSize != int(size) {
+		return 0, nil, ErrSize
+	}
+	if !bytes.Equal(b[len(b)-pktEndSize:], pktEnd) {
+		return 0, nil, ErrEnd
+	}
+	return binary.LittleEndian.Uint16(b), b[pktHdrSize : pktHdrSize+int(size)], nil
+}
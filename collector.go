@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	resultpkg "github.com/dinalt/udptest/result"
+)
+
+// collectorStore keeps uploaded results in memory and, if dir is set,
+// one file per result on disk.
+type collectorStore struct {
+	mu      sync.Mutex
+	dir     string
+	results []*result
+	servers []announcement
+}
+
+func collector(args []string) {
+	fs := flag.NewFlagSet("collector", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "http listen address")
+	dir := fs.String("dir", "", "also store every uploaded result as a file in this directory")
+	ep(fs.Parse(args))
+	st := &collectorStore{dir: *dir}
+	if st.dir != "" {
+		ep(os.MkdirAll(st.dir, 0o755))
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/results", st.handleResults)
+	mux.HandleFunc("/summary", st.handleSummary)
+	mux.HandleFunc("/servers", st.handleServers)
+	mux.HandleFunc("/grafana", st.handleGrafana)
+	mux.HandleFunc("/grafana/", st.handleGrafana)
+	fmt.Printf("collector listening on %s\n", *listen)
+	ep(http.ListenAndServe(*listen, mux))
+}
+
+// handleResults accepts a result with POST and lists all with GET.
+func (st *collectorStore) handleResults(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		b, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res, err := resultpkg.Parse(b)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := st.add(res); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		writeHTTPJSON(w, st.results)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (st *collectorStore) add(res *result) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.results = append(st.results, res)
+	if st.dir == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s-%d.json", res.Start.UTC().Format("20060102T150405.000"), res.Role, len(st.results))
+	return os.WriteFile(filepath.Join(st.dir, name), b, 0o644)
+}
+
+// handleServers registers a server announcing its free port with POST
+// and lists them with GET.
+func (st *collectorStore) handleServers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var a announcement
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&a); err != nil || a.Addr == "" {
+			http.Error(w, "bad announcement", http.StatusBadRequest)
+			return
+		}
+		st.mu.Lock()
+		st.servers = append(st.servers, a)
+		st.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		writeHTTPJSON(w, st.servers)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (st *collectorStore) handleSummary(w http.ResponseWriter, r *http.Request) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var loss, jit []float64
+	for _, res := range st.results {
+		if res.LossPct != nil {
+			loss = append(loss, *res.LossPct)
+		}
+		if res.JitterMs != nil {
+			jit = append(jit, *res.JitterMs)
+		}
+	}
+	sum := func(v []float64) map[string]float64 {
+		min, med, max := spread(v)
+		return map[string]float64{"min": min, "median": med, "max": max}
+	}
+	writeHTTPJSON(w, map[string]interface{}{
+		"results":   len(st.results),
+		"loss_pct":  sum(loss),
+		"jitter_ms": sum(jit),
+	})
+}
+
+func writeHTTPJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// postResult posts r to the collector at url.
+func postResult(r *result, url string) {
+	if url == "" {
+		return
+	}
+	b, err := json.Marshal(r)
+	ep(err)
+	c := http.Client{Timeout: 10 * time.Second}
+	resp, err := c.Post(url+"/results", "application/json", bytes.NewReader(b))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "collector:", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		fmt.Fprintln(os.Stderr, "collector:", resp.Status)
+	}
+}
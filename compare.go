@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func compare(args []string) {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	lossTol := fs.Float64("loss-tol", 0.1, "allowed loss increase, percentage points")
+	jitTol := fs.Float64("jitter-tol", 20, "allowed jitter increase, percent")
+	rttTol := fs.Float64("rtt-tol", 20, "allowed rtt increase, percent")
+	rateTol := fs.Float64("rate-tol", 5, "allowed throughput decrease, percent")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: compare [flags] <before.json> <after.json>")
+		fmt.Fprintln(os.Stderr, "exits with status 1 if any regression is found")
+		fs.PrintDefaults()
+	}
+	ep(fs.Parse(args))
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	a, b := readResult(fs.Arg(0)), readResult(fs.Arg(1))
+	var regressions int
+	row := func(name string, x, y *float64, bad bool) {
+		if x == nil || y == nil {
+			return
+		}
+		mark := ""
+		if bad {
+			mark = "  REGRESSION"
+			regressions++
+		}
+		fmt.Printf("%-16s %12.3f %12.3f %+12.3f%s\n", name, *x, *y, *y-*x, mark)
+	}
+	grew := func(x, y *float64, tol float64) bool {
+		return x != nil && y != nil && *y > *x*(1+tol/100)
+	}
+	fmt.Printf("%-16s %12s %12s %12s\n", "metric", "before", "after", "change")
+	row("loss %", a.LossPct, b.LossPct,
+		a.LossPct != nil && b.LossPct != nil && *b.LossPct-*a.LossPct > *lossTol)
+	row("jitter ms", a.JitterMs, b.JitterMs, grew(a.JitterMs, b.JitterMs, *jitTol))
+	row("throughput Mbps", &a.ThroughputMbps, &b.ThroughputMbps,
+		b.ThroughputMbps < a.ThroughputMbps*(1-*rateTol/100))
+	if a.RTT != nil && b.RTT != nil {
+		row("rtt min ms", &a.RTT.Min, &b.RTT.Min, grew(&a.RTT.Min, &b.RTT.Min, *rttTol))
+		row("rtt median ms", &a.RTT.Median, &b.RTT.Median, grew(&a.RTT.Median, &b.RTT.Median, *rttTol))
+		row("rtt avg ms", &a.RTT.Avg, &b.RTT.Avg, grew(&a.RTT.Avg, &b.RTT.Avg, *rttTol))
+		row("rtt max ms", &a.RTT.Max, &b.RTT.Max, grew(&a.RTT.Max, &b.RTT.Max, *rttTol))
+	}
+	if regressions > 0 {
+		fmt.Printf("regressions: %d\n", regressions)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Control packets share the data packet layout but use packet no 0,
+// which is never taken by data, and carry a space separated text
+// command as payload.
+
+func ctlPacket(cmd string, args ...interface{}) []byte {
+	msg := cmd
+	for _, a := range args {
+		msg += " " + fmt.Sprint(a)
+	}
+	b := make([]byte, pktInfSize+len(msg))
+	binary.LittleEndian.PutUint16(b[pktNoSize:], uint16(len(msg)))
+	copy(b[pktHdrSize:], msg)
+	copy(b[pktHdrSize+len(msg):], pktEnd)
+	return b
+}
+
+func (p *paket) isCtl() bool {
+	return p.no == 0
+}
+
+// ctl splits the control packet payload into command and arguments.
+func (p *paket) ctl() (string, []string) {
+	f := strings.Fields(string(p.data))
+	if len(f) == 0 {
+		return "", nil
+	}
+	return f[0], f[1:]
+}
+
+// handleCtl processes a control packet received by the client on a
+// socket bound to local.
+func handleCtl(p *paket, local net.Addr) {
+	switch cmd, args := p.ctl(); cmd {
+	case "stat":
+		monitor.report(args)
+		adapt.report(args)
+	case "seen":
+		noteSeen(local, args)
+	case "beat":
+		beats.beat()
+	case "error":
+		abort("server: " + strings.Join(args, " "))
+	}
+}
+
+// keepAlive sends a control packet on every con each interval until
+// stop is closed, so nat mappings and firewall state survive long gaps
+// between data packets. The server does not count them.
+func keepAlive(cons []net.Conn, interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	ka := ctlPacket("keepalive")
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			for _, con := range cons {
+				_, _ = con.Write(ka)
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"time"
+)
+
+// cookieBucket is how long a cookie stays valid, at least.
+const cookieBucket = 30 * time.Second
+
+// cookieKey signs the cookies of this server run, so none need be
+// stored: a cookie is valid for the address it was sent to during the
+// current and the previous bucket.
+var cookieKey = func() []byte {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	ep(err)
+	return b
+}()
+
+func cookieFor(from net.Addr, t time.Time) string {
+	m := hmac.New(sha256.New, cookieKey)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(t.UnixNano()/int64(cookieBucket)))
+	m.Write(b[:])
+	m.Write([]byte(from.String()))
+	return hex.EncodeToString(m.Sum(nil)[:8])
+}
+
+func validCookie(from net.Addr, cookie string) bool {
+	now := time.Now()
+	return cookie != "" && (hmac.Equal([]byte(cookie), []byte(cookieFor(from, now))) ||
+		hmac.Equal([]byte(cookie), []byte(cookieFor(from, now.Add(-cookieBucket)))))
+}
+
+// admit decides on a start command of size bytes: with -cookie one
+// without a valid cookie is answered with retry and a cookie, but only
+// if that is not larger than the start, so spoofed sources can not get
+// the server to send anyone more than was sent to it. The starts that
+// pass are checked against the limits.
+func admit(con net.PacketConn, cmd *startCmd, size int) bool {
+	if requireCookie && !validCookie(cmd.from, cmd.cookie) {
+		if retry := ctlPacket("retry", cookieFor(cmd.from, time.Now())); len(retry) <= size {
+			ep(replyTo(con, retry, cmd.from, cmd.local))
+		}
+		return false
+	}
+	return !refuse(con, cmd)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+func cpuTime() time.Duration {
+	var c, e, k, u syscall.Filetime
+	h, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return 0
+	}
+	if err := syscall.GetProcessTimes(h, &c, &e, &k, &u); err != nil {
+		return 0
+	}
+	// Filetime counts 100ns intervals.
+	ticks := func(ft syscall.Filetime) int64 {
+		return int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime)
+	}
+	return time.Duration((ticks(k) + ticks(u)) * 100)
+}
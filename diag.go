@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// overruns counts sends that missed their schedule by more than
+// a whole interval.
+var overruns int64
+
+type diagnostics struct {
+	start   time.Time
+	cpu     time.Duration
+	numGC   uint32
+	pauseNs uint64
+}
+
+func startDiagnostics() diagnostics {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return diagnostics{
+		start:   time.Now(),
+		cpu:     cpuTime(),
+		numGC:   ms.NumGC,
+		pauseNs: ms.PauseTotalNs,
+	}
+}
+
+func (d diagnostics) String() string {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	wall := time.Since(d.start)
+	cpu := cpuTime() - d.cpu
+	s := fmt.Sprintf("cpu: %v (%.1f%% of %v), gc: %d runs, %v paused, pacing overruns: %d",
+		cpu.Round(time.Millisecond), float64(cpu)/float64(wall)*100, wall.Round(time.Millisecond),
+		ms.NumGC-d.numGC, time.Duration(ms.PauseTotalNs-d.pauseNs), atomic.LoadInt64(&overruns))
+	if rxQueue > 0 {
+		s += fmt.Sprintf(", rx queue peak: %d of %d", atomic.LoadInt64(&rxQueuePeak), rxQueue)
+	}
+	if xdpIface != "" {
+		s += fmt.Sprintf(", xdp packets: %d", atomic.LoadInt64(&xdpPackets))
+	}
+	return s
+}
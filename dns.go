@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"time"
+)
+
+var (
+	dnsServer string
+	// resolved is how the target host was resolved, nil for addresses.
+	resolved *dnsInfo
+)
+
+// setupResolver points lookups at -dns, the go resolver is not used
+// on windows.
+func setupResolver() {
+	if dnsServer == "" {
+		return
+	}
+	if _, _, err := net.SplitHostPort(dnsServer); err != nil {
+		dnsServer = net.JoinHostPort(dnsServer, "53")
+	}
+	if runtime.GOOS == "windows" {
+		fmt.Fprintf(os.Stderr, "-dns: custom resolver unavailable on %s, continuing with the system one\n", runtime.GOOS)
+		dnsServer = ""
+		return
+	}
+	net.DefaultResolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, dnsServer)
+		},
+	}
+}
+
+// lookupTarget resolves host and keeps how long it took and what came
+// back for the result.
+func lookupTarget(host string) []net.IP {
+	t0 := time.Now()
+	ips, err := net.LookupIP(host)
+	ep(err)
+	resolved = &dnsInfo{Host: host, Server: dnsServer, Ms: ms(time.Since(t0))}
+	for _, ip := range ips {
+		resolved.Addresses = append(resolved.Addresses, ip.String())
+	}
+	fmt.Printf("resolved %s in %.1fms: %s\n", host, resolved.Ms, addrList(ips))
+	return ips
+}
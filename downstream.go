@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+const rampRetries = 3
+
+// rampDown sends steps of packets to the client, each faster than
+// the previous, until the loss reported by the client exceeds
+// rampLoss, and reports the fastest step that stayed within it.
+func rampDown(con net.PacketConn, to net.Addr, local net.IP) {
+	var (
+		pkt  paket
+		best float64
+	)
+	bb := make([]byte, pktSize-pktInfSize)
+	interval := sendInterval
+	for step := 1; interval >= time.Microsecond; step++ {
+		if overRate(float64(pktSize*8) * float64(time.Second) / float64(interval)) {
+			fmt.Printf("step %d: over max-rate, stopping\n", step)
+			break
+		}
+		if _, over := served.exceeded(to); over {
+			fmt.Printf("step %d: quota used up, stopping\n", step)
+			break
+		}
+		n := int(rampStep / interval)
+		if n < 1 {
+			n = 1
+		}
+		if n > pktMaxCount {
+			n = pktMaxCount
+		}
+		src := newPayloadSource(1, 1)
+		pc := newPacer(time.Now(), interval)
+		for no := 1; no <= n; no++ {
+			pc.wait()
+			ep(src.Next(bb))
+			pkt.apply(uint16(no), bb)
+			ep(replyTo(con, pkt.raw, to, local))
+		}
+		served.charge(to, int64(n*len(pkt.raw)))
+		got, ok := rampReport(con, to, local, step, n)
+		if !ok {
+			fmt.Printf("step %d: no report from client\n", step)
+			break
+		}
+		pps := float64(time.Second) / float64(interval)
+		loss := float64(n-got) / float64(n) * 100
+		fmt.Printf("step %d: %.0f pps, sent %d, received %d, loss %.2f%%\n", step, pps, n, got, loss)
+		if loss > rampLoss {
+			break
+		}
+		best = pps
+		interval = interval * 2 / 3
+	}
+	for i := 0; i < rampRetries; i++ {
+		ep(replyTo(con, ctlPacket("done", best), to, local))
+	}
+	fmt.Println(rateSummary(best))
+}
+
+func rampReport(con net.PacketConn, to net.Addr, local net.IP, step, sent int) (int, bool) {
+	var pkt paket
+	for i := 0; i < rampRetries; i++ {
+		ep(replyTo(con, ctlPacket("end", step, sent), to, local))
+		for pkt.readValid(con, time.Now().Add(idleTimeout)) == nil {
+			cmd, args := pkt.ctl()
+			if !pkt.isCtl() || cmd != "rep" || len(args) != 2 || args[0] != strconv.Itoa(step) {
+				continue
+			}
+			got, err := strconv.Atoi(args[1])
+			ep(err)
+			return got, true
+		}
+	}
+	return 0, false
+}
+
+// rampReceive counts packets of every step sent by rampDown and
+// reports them back until the server announces the result.
+func rampReceive(con net.Conn) {
+	var (
+		pkt  paket
+		got  int
+		last []byte
+		step string
+	)
+	pc := con.(net.PacketConn)
+	for {
+		if err := pkt.readValid(pc, time.Now().Add(idleTimeout)); err != nil {
+			fmt.Println("no result from server")
+			return
+		}
+		if !pkt.isCtl() {
+			got++
+			continue
+		}
+		cmd, args := pkt.ctl()
+		switch {
+		case cmd == "end" && len(args) == 2:
+			if args[0] != step {
+				step = args[0]
+				last = ctlPacket("rep", step, got)
+				got = 0
+			}
+			_, err := con.Write(last)
+			ep(err)
+		case cmd == "done" && len(args) == 1:
+			pps, err := strconv.ParseFloat(args[0], 64)
+			ep(err)
+			fmt.Println(rateSummary(pps))
+			return
+		}
+	}
+}
+
+func rateSummary(pps float64) string {
+	return fmt.Sprintf("downstream rate: %.0f pps (%.2f Mbit/s)", pps, pps*float64(pktSize)*8/1e6)
+}
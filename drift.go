@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// -resync sends resyncBurst probes resyncGap apart, the one with the
+// smallest round trip gives the offset.
+const (
+	resyncBurst = 4
+	resyncGap   = 10 * time.Millisecond
+)
+
+var resyncEvery time.Duration
+
+type offsetSample struct {
+	at     time.Time
+	offset time.Duration
+	rtt    time.Duration
+}
+
+// clockDrift collects the clock offsets estimated during a test, on
+// long runs the clocks wander apart and one-way delays with them.
+type clockDrift struct {
+	mu      sync.Mutex
+	samples []offsetSample
+	// best is the sample of the current burst, sent at burst
+	best  offsetSample
+	burst time.Time
+}
+
+var (
+	drift *clockDrift
+	// syncOffset is the offset estimated by -sync before the test
+	syncOffset time.Duration
+)
+
+// clockOffset returns the latest estimate of the server clock offset.
+func clockOffset() time.Duration {
+	if drift == nil {
+		return syncOffset
+	}
+	drift.mu.Lock()
+	defer drift.mu.Unlock()
+	return drift.samples[len(drift.samples)-1].offset
+}
+
+// startResync takes the offset from -sync as the first sample and
+// probes every -resync until stop is closed.
+func startResync(con net.Conn, offset, rtt time.Duration, stop chan struct{}) {
+	drift = &clockDrift{samples: []offsetSample{{time.Now(), offset, rtt}}}
+	go func() {
+		req := make([]byte, syncRepSize)
+		copy(req, syncMsg)
+		t := time.NewTicker(resyncEvery)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+			}
+			for i := 0; i < resyncBurst; i++ {
+				binary.LittleEndian.PutUint64(req[4:], uint64(time.Now().UnixNano()))
+				if _, err := con.Write(req); err != nil {
+					return
+				}
+				time.Sleep(resyncGap)
+			}
+		}
+	}()
+}
+
+// reply takes a sync reply b received at t4 and reports whether it
+// was one.
+func (d *clockDrift) reply(b []byte, t4 time.Time) bool {
+	if d == nil || len(b) != syncRepSize || !bytes.Equal(b[:4], syncMsg) {
+		return false
+	}
+	t1 := time.Unix(0, int64(binary.LittleEndian.Uint64(b[4:])))
+	t2 := int64(binary.LittleEndian.Uint64(b[12:]))
+	t3 := int64(binary.LittleEndian.Uint64(b[20:]))
+	s := offsetSample{
+		at:     t1,
+		offset: time.Duration((t2-t1.UnixNano())+(t3-t4.UnixNano())) / 2,
+		rtt:    t4.Sub(t1) - time.Duration(t3-t2),
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t1.Sub(d.burst) > resyncGap*resyncBurst {
+		d.flush()
+		d.burst = t1
+	}
+	if d.best.at.IsZero() || s.rtt < d.best.rtt {
+		d.best = s
+	}
+	return true
+}
+
+func (d *clockDrift) flush() {
+	if d.best.at.IsZero() {
+		return
+	}
+	d.samples = append(d.samples, d.best)
+	trc.comment(fmt.Sprintf("offset %d at %d", d.best.offset, d.best.at.UnixNano()))
+	d.best = offsetSample{}
+}
+
+// stats fits a line through the offsets, its slope is the drift.
+func (d *clockDrift) stats() *driftStats {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.flush()
+	n := float64(len(d.samples))
+	first, last := d.samples[0], d.samples[len(d.samples)-1]
+	s := &driftStats{Samples: len(d.samples), OffsetStartMs: ms(first.offset), OffsetEndMs: ms(last.offset)}
+	var sx, sy, sxx, sxy float64
+	for _, p := range d.samples {
+		x, y := p.at.Sub(first.at).Seconds(), p.offset.Seconds()
+		sx, sy, sxx, sxy = sx+x, sy+y, sxx+x*x, sxy+x*y
+	}
+	if den := n*sxx - sx*sx; den > 0 {
+		s.PPM = (n*sxy - sx*sy) / den * 1e6
+	}
+	return s
+}
@@ -0,0 +1,185 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// dropWatch counts skb drops by reason with a bpf program on the
+// skb:kfree_skb tracepoint. Only IP packets are counted, and tcp reasons
+// are left out as they can not be test traffic.
+type dropWatch struct {
+	mapFd   int
+	events  []int
+	reasons map[int]string
+}
+
+const (
+	perfTypeTracepoint = 2
+	perfFlagFdCloexec  = 8
+	perfIocEnable      = 0x2400
+	perfIocSetBPF      = 0x40042408
+	dropReasons        = 256
+)
+
+var tracefs = []string{"/sys/kernel/tracing", "/sys/kernel/debug/tracing"}
+
+// kfreeSkb describes the tracepoint as given by its format file.
+type kfreeSkb struct {
+	id         int
+	protoOff   int
+	reasonOff  int
+	reasonName map[int]string
+}
+
+var (
+	fieldRe  = regexp.MustCompile(`field:[^;]* (\w+);\s*offset:(\d+);`)
+	symbolRe = regexp.MustCompile(`\{ (\d+), "(\w+)" \}`)
+)
+
+func readKfreeSkb() (*kfreeSkb, error) {
+	var b []byte
+	var err error
+	for _, dir := range tracefs {
+		b, err = os.ReadFile(filepath.Join(dir, "events/skb/kfree_skb/format"))
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, errors.New("skb:kfree_skb tracepoint not found, is tracefs mounted?")
+	}
+	t := &kfreeSkb{protoOff: -1, reasonOff: -1, reasonName: map[int]string{}}
+	for _, l := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(l, "ID: ") {
+			t.id, _ = strconv.Atoi(l[4:])
+		}
+		if m := fieldRe.FindStringSubmatch(l); m != nil {
+			off, _ := strconv.Atoi(m[2])
+			switch m[1] {
+			case "protocol":
+				t.protoOff = off
+			case "reason":
+				t.reasonOff = off
+			}
+		}
+	}
+	for _, m := range symbolRe.FindAllStringSubmatch(string(b), -1) {
+		n, _ := strconv.Atoi(m[1])
+		t.reasonName[n] = m[2]
+	}
+	if t.id == 0 || t.protoOff < 0 || t.reasonOff < 0 {
+		return nil, errors.New("skb:kfree_skb has no drop reasons on this kernel")
+	}
+	return t, nil
+}
+
+// dropProgram counts the drops of IP packets in the array map by reason.
+// The tracepoint records the protocol in host order.
+func dropProgram(t *kfreeSkb, mapFd int) []bpfInsn {
+	p := []bpfInsn{
+		insn(bpfMovx, 6, 1, 0, 0),                  // 0: r6 = ctx
+		insn(bpfLdxh, 2, 6, int16(t.protoOff), 0),  // 1
+		insn(bpfJeqk, 2, 0, 1, 0x0800),             // 2: to 4
+		insn(bpfJnek, 2, 0, 11, 0x86dd),            // 3: out
+		insn(bpfLdxw, 2, 6, int16(t.reasonOff), 0), // 4
+		insn(bpfJgek, 2, 0, 9, dropReasons),        // 5: out
+		insn(bpfStxw, 10, 2, -4, 0),                // 6: key on the stack
+		insn(bpfMovx, 2, 10, 0, 0),                 // 7
+		insn(bpfAddk, 2, 0, 0, -4),                 // 8
+	}
+	p = append(p, ldMap(1, mapFd)...) // 9
+	return append(p,
+		insn(bpfCall, 0, 0, 0, bpfHelperLookupElem), // 11
+		insn(bpfJeqk, 0, 0, 2, 0),                   // 12: out
+		insn(bpfMovk, 1, 0, 0, 1),                   // 13
+		insn(bpfXaddDw, 0, 1, 0, 0),                 // 14
+		insn(bpfMovk, 0, 0, 0, 0),                   // 15: out
+		insn(bpfExit, 0, 0, 0, 0),
+	)
+}
+
+func startDropWatch() (*dropWatch, error) {
+	t, err := readKfreeSkb()
+	if err != nil {
+		return nil, err
+	}
+	w := &dropWatch{reasons: t.reasonName}
+	w.mapFd, err = bpfMap(bpfMapTypeArray, 4, 8, dropReasons)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := bpfLoad(bpfProgTypeTracepoint, dropProgram(t, w.mapFd))
+	if err != nil {
+		syscall.Close(w.mapFd)
+		return nil, err
+	}
+	defer syscall.Close(prog)
+	cpus, err := os.ReadFile("/sys/devices/system/cpu/online")
+	if err != nil {
+		return nil, err
+	}
+	list, err := parseCPUList(strings.TrimSpace(string(cpus)))
+	if err != nil {
+		return nil, err
+	}
+	attr := struct {
+		typ, size                                     uint32
+		config, period, sampleType, readFormat, flags uint64
+		wakeup, bpType                                uint32
+		config1                                       uint64
+	}{typ: perfTypeTracepoint, config: uint64(t.id), period: 1, wakeup: 1}
+	attr.size = uint32(unsafe.Sizeof(attr))
+	for _, cpu := range list {
+		fd, _, errno := syscall.Syscall6(syscall.SYS_PERF_EVENT_OPEN, uintptr(unsafe.Pointer(&attr)),
+			^uintptr(0), uintptr(cpu), ^uintptr(0), perfFlagFdCloexec, 0)
+		if errno != 0 {
+			w.stop()
+			return nil, errno
+		}
+		w.events = append(w.events, int(fd))
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, perfIocSetBPF, uintptr(prog))
+		if errno == 0 {
+			_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, perfIocEnable, 0)
+		}
+		if errno != 0 {
+			w.stop()
+			return nil, errno
+		}
+	}
+	return w, nil
+}
+
+// stop detaches the probe and returns the drops counted.
+func (w *dropWatch) stop() *kernelDrops {
+	for _, fd := range w.events {
+		syscall.Close(fd)
+	}
+	w.events = nil
+	d := &kernelDrops{}
+	for reason := uint32(0); reason < dropReasons; reason++ {
+		var n uint64
+		if bpfLookup(w.mapFd, unsafe.Pointer(&reason), unsafe.Pointer(&n)) != nil || n == 0 {
+			continue
+		}
+		name, ok := w.reasons[int(reason)]
+		if !ok {
+			name = "reason " + strconv.Itoa(int(reason))
+		}
+		if !strings.HasPrefix(name, "TCP_") {
+			d.Add(name, int(n))
+		}
+	}
+	syscall.Close(w.mapFd)
+	return d
+}
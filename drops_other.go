@@ -0,0 +1,26 @@
+//go:build !linux || !(amd64 || arm64)
+// +build !linux !amd64,!arm64
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+func init() {
+	unavailable["drops"] = "linux only"
+	if runtime.GOOS == "linux" {
+		unavailable["drops"] = "amd64 and arm64 only"
+	}
+}
+
+type dropWatch struct{}
+
+func startDropWatch() (*dropWatch, error) {
+	return nil, errors.New("drop attribution needs linux on amd64 or arm64")
+}
+
+func (w *dropWatch) stop() *kernelDrops {
+	return &kernelDrops{}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// dscpList is the -dscp flag: one DSCP value per sending socket, so
+// the classes of a QoS policy can be compared in one run.
+type dscpList []int
+
+var dscpNames = map[string]int{"be": 0, "df": 0, "ef": 46, "va": 44, "le": 1}
+
+func (l *dscpList) String() string {
+	s := make([]string, len(*l))
+	for i, v := range *l {
+		s[i] = dscpName(v)
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *dscpList) Set(s string) error {
+	for _, f := range strings.Split(s, ",") {
+		v, err := parseDSCP(strings.ToLower(strings.TrimSpace(f)))
+		if err != nil {
+			return err
+		}
+		*l = append(*l, v)
+	}
+	return nil
+}
+
+// parseDSCP accepts 0-63, csN, afXY and the names in dscpNames.
+func parseDSCP(s string) (int, error) {
+	if v, ok := dscpNames[s]; ok {
+		return v, nil
+	}
+	var x, y int
+	if n, _ := fmt.Sscanf(s, "cs%1d", &x); n == 1 && len(s) == 3 && x <= 7 {
+		return x << 3, nil
+	}
+	if n, _ := fmt.Sscanf(s, "af%1d%1d", &x, &y); n == 2 && len(s) == 4 && x >= 1 && x <= 4 && y >= 1 && y <= 3 {
+		return x<<3 | y<<1, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 || v > 63 {
+		return 0, fmt.Errorf("dscp %q: expected 0-63, csN, afXY, ef, va, le or be", s)
+	}
+	return v, nil
+}
+
+// dscpName returns the usual name of v, or v.
+func dscpName(v int) string {
+	switch {
+	case v == 0:
+		return "be"
+	case v == 46:
+		return "ef"
+	case v == 44:
+		return "va"
+	case v == 1:
+		return "le"
+	case v&7 == 0:
+		return fmt.Sprintf("cs%d", v>>3)
+	case v&1 == 0 && v>>3 >= 1 && v>>3 <= 4:
+		return fmt.Sprintf("af%d%d", v>>3, (v>>1)&3)
+	}
+	return strconv.Itoa(v)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+var dualStack bool
+
+// dual runs the test to the first ipv4 and then the first ipv6
+// address of the target and compares the two runs. The server needs
+// -service to take both.
+func dual() {
+	host, port, err := net.SplitHostPort(addr)
+	ep(err)
+	ips := lookupTarget(host)
+	var v4, v6 net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil && v4 == nil {
+			v4 = ip
+		} else if ip.To4() == nil && v6 == nil {
+			v6 = ip
+		}
+	}
+	if v4 == nil || v6 == nil {
+		fmt.Fprintf(os.Stderr, "dual: %s needs both an ipv4 and an ipv6 address\n", host)
+		os.Exit(1)
+	}
+	var res [2]*result
+	for i, ip := range []net.IP{v4, v6} {
+		addr = net.JoinHostPort(ip.String(), port)
+		runLabels["family"] = []string{"ipv4", "ipv6"}[i]
+		fmt.Printf("dual: testing %s\n", addr)
+		if res[i] = upload(); res[i] == nil || isAborted() {
+			fmt.Fprintln(os.Stderr, "dual: no result to compare")
+			os.Exit(1)
+		}
+	}
+	printDual(res[0], res[1])
+}
+
+func printDual(a, b *result) {
+	row := func(name string, x, y *float64) {
+		if x != nil && y != nil {
+			fmt.Printf("%-16s %12.3f %12.3f %+12.3f\n", name, *x, *y, *y-*x)
+		}
+	}
+	fmt.Printf("%-16s %12s %12s %12s\n", "metric", "ipv4", "ipv6", "v6 - v4")
+	row("loss %", a.LossPct, b.LossPct)
+	row("jitter ms", a.JitterMs, b.JitterMs)
+	row("throughput Mbps", &a.ThroughputMbps, &b.ThroughputMbps)
+	if a.RTT != nil && b.RTT != nil {
+		row("rtt min ms", &a.RTT.Min, &b.RTT.Min)
+		row("rtt median ms", &a.RTT.Median, &b.RTT.Median)
+		row("rtt max ms", &a.RTT.Max, &b.RTT.Max)
+	}
+	if a.LossPct == nil || b.LossPct == nil {
+		fmt.Println("dual: loss is known with -echo only")
+	}
+}
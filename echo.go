@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// reflect sends p back to its source, resized to replySize bytes
+// (the received size if zero).
+func (p *paket) reflect(con net.PacketConn, buf []byte) []byte {
+	size := replySize
+	if size == 0 {
+		size = int(p.size) + pktInfSize
+	}
+	if cap(buf) < size {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
+	pl := buf[pktHdrSize : size-pktEndSize]
+	n := copy(pl, p.data)
+	for i := n; i < len(pl); i++ {
+		pl[i] = 0
+	}
+	if owdStamps && len(pl) >= owdStampSize {
+		stampReply(pl, p.at)
+	}
+	binary.LittleEndian.PutUint16(buf, p.no)
+	binary.LittleEndian.PutUint16(buf[pktNoSize:], uint16(len(pl)))
+	copy(buf[size-pktEndSize:], pktEnd)
+	err := replyTo(con, buf, p.from, p.dst)
+	ep(err)
+	return buf
+}
+
+// echoStats matches reflected packets against their send times.
+type echoStats struct {
+	sent []int64 // send time in unix nanoseconds by packet no
+	mu   sync.Mutex
+	rtts []time.Duration
+	jit  jitter
+	// paths breaks the figures down by sending socket, see splitPaths
+	paths    []*pathStats
+	pathRTTs [][]time.Duration
+	rate     *deliveryRate
+	// replied marks the packets with a reply by no
+	replied []bool
+	owd     *oneWay
+}
+
+// splitPaths makes e keep figures per sending socket. Socket w of n
+// sends the packet numbers w+1, w+1+n, ...
+func (e *echoStats) splitPaths(names []string) {
+	for _, n := range names {
+		e.paths = append(e.paths, &pathStats{Name: n})
+		e.pathRTTs = append(e.pathRTTs, nil)
+	}
+}
+
+// finishPaths computes the per path figures given the packets sent by
+// each socket.
+func (e *echoStats) finishPaths(sent []int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for w, p := range e.paths {
+		rtts := e.pathRTTs[w]
+		p.SetReceived(sent[w], len(rtts))
+		if len(rtts) > 0 {
+			sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+			p.MedianMs = ms(rtts[len(rtts)/2])
+		}
+	}
+}
+
+func newEchoStats() *echoStats {
+	e := &echoStats{sent: make([]int64, pktCount+1), rate: newDeliveryRate(pktCount + 1), replied: make([]bool, pktCount+1)}
+	if owdStamps {
+		e.owd = &oneWay{}
+	}
+	return e
+}
+
+func (e *echoStats) markSent(no uint16, ts time.Time) {
+	if e == nil {
+		return
+	}
+	e.rate.sent(no, ts.UnixNano())
+	atomic.StoreInt64(&e.sent[no], ts.UnixNano())
+}
+
+// restamp replaces the send time of no with the more precise ts and
+// returns the one replaced, 0 if none.
+func (e *echoStats) restamp(no uint16, ts time.Time) int64 {
+	if e == nil || int(no) >= len(e.sent) {
+		return 0
+	}
+	return atomic.SwapInt64(&e.sent[no], ts.UnixNano())
+}
+
+// readBack reads packets the server sends to con until want echo
+// replies arrived or the read timeout expires. Without echo mode only
+// control packets are expected and want is ignored.
+func readBack(con net.PacketConn, want int) {
+	var pkt paket
+	pkt.buf = make([]byte, pktMaxSize)
+	for got := 0; echoes == nil || got < want; {
+		err := pkt.readFrom(con, time.Now().Add(idleTimeout))
+		var perr packetError
+		if errors.As(err, &perr) {
+			drift.reply(pkt.raw, pkt.at)
+			continue
+		}
+		if err != nil {
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				abort("connection refused by server")
+			}
+			return
+		}
+		if pkt.isCtl() {
+			handleCtl(&pkt, con.LocalAddr())
+			continue
+		}
+		if echoes != nil && echoes.add(&pkt, time.Now()) {
+			got++
+		}
+	}
+}
+
+// add records the round trip of a reply received at now, a duplicate
+// reply is ignored.
+func (e *echoStats) add(p *paket, now time.Time) bool {
+	if int(p.no) >= len(e.sent) {
+		return false
+	}
+	ts := atomic.LoadInt64(&e.sent[p.no])
+	if ts == 0 {
+		return false
+	}
+	rtt := now.Sub(time.Unix(0, ts))
+	e.mu.Lock()
+	if e.replied[p.no] {
+		e.mu.Unlock()
+		return false
+	}
+	e.rtts = append(e.rtts, rtt)
+	e.jit.add(rtt)
+	e.rate.ack(p.no, ts, now, rtt)
+	e.replied[p.no] = true
+	if e.owd != nil {
+		e.owd.add(ts, p.data, now)
+	}
+	if len(e.paths) > 0 {
+		w := (int(p.no) - 1) % len(e.paths)
+		e.pathRTTs[w] = append(e.pathRTTs[w], rtt)
+	}
+	e.mu.Unlock()
+	return true
+}
+
+// rtt returns min, average, median and max round trip times.
+func (e *echoStats) rtt() (min, avg, med, max time.Duration) {
+	if len(e.rtts) == 0 {
+		return
+	}
+	sort.Slice(e.rtts, func(i, j int) bool { return e.rtts[i] < e.rtts[j] })
+	var sum time.Duration
+	for _, d := range e.rtts {
+		sum += d
+	}
+	return e.rtts[0], sum / time.Duration(len(e.rtts)), e.rtts[len(e.rtts)/2], e.rtts[len(e.rtts)-1]
+}
+
+func (e *echoStats) fill(r *result) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	r.Received = len(e.rtts)
+	setLoss(r, r.Sent, r.Received)
+	setJitter(r, e.jit.mean())
+	if len(e.rtts) > 0 {
+		min, avg, med, max := e.rtt()
+		r.RTT = &rttStats{Min: ms(min), Avg: ms(avg), Median: ms(med), Max: ms(max)}
+	}
+	r.Bottleneck = e.rate.estimate()
+	if e.owd != nil {
+		r.OneWay = e.owd.stats()
+	}
+	r.Paths = e.paths
+}
+
+func (e *echoStats) String() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := fmt.Sprintf("echo replies received: %d", len(e.rtts))
+	if len(e.rtts) == 0 {
+		return s
+	}
+	min, avg, med, max := e.rtt()
+	s += fmt.Sprintf("\nrtt: min %v, avg %v, median %v, max %v, jitter %v",
+		min, avg, med, max, e.jit.mean())
+	if b := e.rate.estimate(); b != nil {
+		s += "\n" + b.String()
+	}
+	if e.owd != nil {
+		if o := e.owd.stats(); o != nil {
+			s += "\n" + o.String()
+		}
+	}
+	for _, p := range e.paths {
+		s += "\n" + p.String()
+	}
+	return s
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// -encap sends the even packets inside a vxlan or geneve header to the
+// tunnel port, the odd ones as usual, so the server can tell overlay
+// from underlay loss without a tunnel endpoint. The inner frame is
+// ethernet, ipv4 and udp between fixed benchmark addresses.
+const (
+	encapHdrSize   = 8
+	encapInnerSize = 14 + 20 + 8
+	encapSize      = encapHdrSize + encapInnerSize
+	// transparent ethernet bridging, geneve's protocol type for
+	// ethernet frames
+	encapEtherType = 0x6558
+)
+
+var (
+	encapInnerSrc = net.IPv4(198, 18, 0, 1).To4()
+	encapInnerDst = net.IPv4(198, 18, 0, 2).To4()
+)
+
+type encapSpec struct {
+	kind string // vxlan or geneve
+	vni  uint32
+	port int
+}
+
+var encap encapSpec
+
+func (e *encapSpec) String() string {
+	if e.kind == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s vni %d port %d", e.kind, e.vni, e.port)
+}
+
+// Set parses vxlan|geneve[:vni=<n>][,port=<n>], the port defaults to
+// 4789 for vxlan and 6081 for geneve.
+func (e *encapSpec) Set(v string) error {
+	kind, opts := v, ""
+	if i := strings.IndexByte(v, ':'); i >= 0 {
+		kind, opts = v[:i], v[i+1:]
+	}
+	switch kind {
+	case "vxlan":
+		e.port = 4789
+	case "geneve":
+		e.port = 6081
+	default:
+		return fmt.Errorf("unknown encapsulation %q, expected vxlan or geneve", kind)
+	}
+	e.kind = kind
+	if opts == "" {
+		return nil
+	}
+	for _, kv := range strings.Split(opts, ",") {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			return fmt.Errorf("expected key=value in %q", kv)
+		}
+		n, err := strconv.ParseUint(kv[i+1:], 10, 32)
+		if err != nil {
+			return fmt.Errorf("bad number in %q", kv)
+		}
+		switch kv[:i] {
+		case "vni":
+			if n >= 1<<24 {
+				return errors.New("vni should be below 2^24")
+			}
+			e.vni = uint32(n)
+		case "port":
+			if n == 0 || n > 65535 {
+				return errors.New("bad encap port")
+			}
+			e.port = int(n)
+		default:
+			return fmt.Errorf("unknown encap key %q", kv[:i])
+		}
+	}
+	return nil
+}
+
+// header returns the tunnel header and inner frame headers for a
+// datagram of n bytes between the inner ports.
+func (e *encapSpec) header(n, sport, dport int) []byte {
+	b := make([]byte, encapSize)
+	if e.kind == "vxlan" {
+		b[0] = 0x08 // vni present
+	} else {
+		binary.BigEndian.PutUint16(b[2:], encapEtherType)
+	}
+	binary.BigEndian.PutUint32(b[4:], e.vni<<8)
+	eth := b[encapHdrSize:]
+	copy(eth, []byte{2, 0, 0, 0, 0, 2, 2, 0, 0, 0, 0, 1})
+	binary.BigEndian.PutUint16(eth[12:], 0x0800)
+	ip := eth[14:]
+	ip[0], ip[8], ip[9] = 0x45, 64, 17
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+8+n))
+	copy(ip[12:], encapInnerSrc)
+	copy(ip[16:], encapInnerDst)
+	binary.BigEndian.PutUint16(ip[10:], ipChecksum(ip[:20]))
+	udp := ip[20:]
+	binary.BigEndian.PutUint16(udp, uint16(sport))
+	binary.BigEndian.PutUint16(udp[2:], uint16(dport))
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+n))
+	// checksum 0, none, is fine for ipv4
+	return b
+}
+
+// inner returns the datagram b carries, or nil if it is not a frame of
+// the configured tunnel.
+func (e *encapSpec) inner(b []byte) []byte {
+	if len(b) < encapSize || binary.BigEndian.Uint32(b[4:])>>8 != e.vni {
+		return nil
+	}
+	hdr := encapHdrSize
+	if e.kind == "vxlan" {
+		if b[0]&0x08 == 0 {
+			return nil
+		}
+	} else {
+		if b[0]>>6 != 0 || binary.BigEndian.Uint16(b[2:]) != encapEtherType {
+			return nil
+		}
+		hdr += int(b[0]&0x3f) * 4
+	}
+	if hdr+14 > len(b) {
+		return nil
+	}
+	b = b[hdr:]
+	if binary.BigEndian.Uint16(b[12:]) != 0x0800 {
+		return nil
+	}
+	b = b[14:]
+	if len(b) < 20 || b[0]>>4 != 4 || b[9] != 17 {
+		return nil
+	}
+	ihl := int(b[0]&0x0f) * 4
+	if len(b) < ihl+8 {
+		return nil
+	}
+	return b[ihl+8:]
+}
+
+// encapConn sends the even data packets through the tunnel port.
+type encapConn struct {
+	*net.UDPConn
+	tunnel net.Conn
+	buf    []byte
+}
+
+func withEncap(con net.Conn) (net.Conn, error) {
+	uc, ok := con.(*net.UDPConn)
+	if !ok {
+		return nil, errors.New("needs a plain udp socket")
+	}
+	ra := con.RemoteAddr().(*net.UDPAddr)
+	d := net.Dialer{Control: dialControl}
+	t, err := d.Dial("udp", net.JoinHostPort(ra.IP.String(), strconv.Itoa(encap.port)))
+	if err != nil {
+		return nil, err
+	}
+	return &encapConn{UDPConn: uc, tunnel: t, buf: make([]byte, encapSize+datagramSize())}, nil
+}
+
+func (c *encapConn) Write(b []byte) (int, error) {
+	if no := binary.LittleEndian.Uint16(b); no == 0 || no%2 != 0 {
+		return c.UDPConn.Write(b)
+	}
+	la, ra := c.LocalAddr().(*net.UDPAddr), c.RemoteAddr().(*net.UDPAddr)
+	p := append(append(c.buf[:0], encap.header(len(b), la.Port, ra.Port)...), b...)
+	if _, err := c.tunnel.Write(p); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *encapConn) Close() error {
+	c.tunnel.Close()
+	return c.UDPConn.Close()
+}
+
+// startDecap listens on the tunnel port next to q and queues the
+// datagrams inside the frames arriving there.
+func startDecap(q *queuedConn) error {
+	host, _, err := net.SplitHostPort(q.LocalAddr().String())
+	if err != nil {
+		return err
+	}
+	con, err := net.ListenPacket("udp", net.JoinHostPort(host, strconv.Itoa(encap.port)))
+	if err != nil {
+		return err
+	}
+	go func() {
+		buf := make([]byte, encapSize+64+datagramSize())
+		for {
+			n, from, err := con.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			b := encap.inner(buf[:n])
+			if b == nil {
+				continue
+			}
+			it := q.pool.Get().(*rxItem)
+			it.n, it.oobn, it.addr, it.err = copy(it.buf, b), 0, from.(*net.UDPAddr), nil
+			q.push(it)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// runEnv is set with -env once the interface in use is known.
+var runEnv *envInfo
+
+var envSysctls = []string{
+	"net/core/rmem_default", "net/core/rmem_max",
+	"net/core/wmem_default", "net/core/wmem_max",
+	"net/core/netdev_max_backlog",
+	"net/ipv4/udp_mem", "net/ipv4/udp_rmem_min", "net/ipv4/udp_wmem_min",
+}
+
+// collectEnv describes this host and the interface it reaches remote
+// through.
+func collectEnv(remote net.Addr) *envInfo {
+	e := &envInfo{
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+		Go:      runtime.Version(),
+		CPUs:    runtime.NumCPU(),
+		Sysctls: map[string]string{},
+	}
+	if b, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		e.Kernel = strings.TrimSpace(string(b))
+	}
+	for _, name := range envSysctls {
+		if b, err := os.ReadFile(filepath.Join("/proc/sys", name)); err == nil {
+			e.Sysctls[strings.ReplaceAll(name, "/", ".")] = strings.Join(strings.Fields(string(b)), " ")
+		}
+	}
+	if ifi := ifaceTo(remote); ifi != nil {
+		e.Interface = ifi.Name
+		e.MTU = ifi.MTU
+		e.Offloads = offloads(ifi.Name)
+	}
+	return e
+}
+
+// ifaceTo returns the interface the route to remote leaves through.
+func ifaceTo(remote net.Addr) *net.Interface {
+	if remote == nil {
+		return nil
+	}
+	// no packet is sent, connecting only picks the source address
+	con, err := net.Dial("udp", remote.String())
+	if err != nil {
+		return nil
+	}
+	local := con.LocalAddr().(*net.UDPAddr).IP
+	con.Close()
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for i := range ifs {
+		addrs, _ := ifs[i].Addrs()
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.Equal(local) {
+				return &ifs[i]
+			}
+		}
+	}
+	return nil
+}
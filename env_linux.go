@@ -0,0 +1,45 @@
+package main
+
+import (
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const siocEthtool = 0x8946
+
+// ethtoolOffloads are the legacy ethtool get commands by feature name.
+var ethtoolOffloads = map[string]uint32{
+	"rx-checksum": 0x14, // ETHTOOL_GRXCSUM
+	"tx-checksum": 0x16, // ETHTOOL_GTXCSUM
+	"sg":          0x18, // ETHTOOL_GSG
+	"tso":         0x1e, // ETHTOOL_GTSO
+	"gso":         0x23, // ETHTOOL_GGSO
+	"gro":         0x2b, // ETHTOOL_GGRO
+}
+
+// offloads asks the driver of iface which offloads are on.
+func offloads(iface string) map[string]bool {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return nil
+	}
+	defer syscall.Close(fd)
+	res := map[string]bool{}
+	for name, cmd := range ethtoolOffloads {
+		value := struct{ cmd, data uint32 }{cmd: cmd}
+		var ifr struct {
+			name [syscall.IFNAMSIZ]byte
+			data uintptr
+			_    [16]byte
+		}
+		copy(ifr.name[:], iface)
+		ifr.data = uintptr(unsafe.Pointer(&value))
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), siocEthtool, uintptr(unsafe.Pointer(&ifr)))
+		runtime.KeepAlive(&value)
+		if errno == 0 {
+			res[name] = value.data != 0
+		}
+	}
+	return res
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+func offloads(iface string) map[string]bool {
+	return nil
+}
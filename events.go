@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Significant events are written as json lines to events and
+// published to the mqtt broker, if any. Human readable output goes to
+// stderr while events are enabled.
+var (
+	events   io.Writer
+	mqtt     *mqttClient
+	eventsMu sync.Mutex
+)
+
+func setupEvents(format string) {
+	switch format {
+	case "":
+		return
+	case "jsonl":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown events format: %s\n", format)
+		os.Exit(1)
+	}
+	events = takeStdout()
+}
+
+var stdout = os.Stdout
+
+// takeStdout reserves stdout for machine readable output, sending
+// human readable output to stderr.
+func takeStdout() io.Writer {
+	os.Stdout = os.Stderr
+	return stdout
+}
+
+func emit(event string, fields map[string]interface{}) {
+	publish := mqtt != nil && (event != "interval" || mqttIntervals)
+	if events == nil && !publish {
+		return
+	}
+	if fields == nil {
+		fields = map[string]interface{}{}
+	}
+	fields["event"] = event
+	if testID != "" {
+		fields["test_id"] = testID
+	}
+	fields["time"] = time.Now()
+	b, err := json.Marshal(fields)
+	ep(err)
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	if events != nil {
+		_, err := events.Write(append(b, '\n'))
+		ep(err)
+	}
+	if publish {
+		if err := mqtt.publish(event, b); err != nil {
+			fmt.Fprintln(os.Stderr, "mqtt:", err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// -failover defaults, continuous light traffic long enough to switch
+// a router over and back
+const (
+	failoverInterval = 10 * time.Millisecond
+	failoverSize     = 64
+	failoverIdle     = time.Minute
+	// failoverMin packets lost in a row make an outage
+	failoverMin = 3
+)
+
+var failover bool
+
+// setupFailover applies the -failover defaults to flags not given.
+func setupFailover() {
+	if !failover {
+		return
+	}
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	if !given["i"] && !given["pps"] {
+		sendInterval = failoverInterval
+	}
+	if !given["p"] {
+		pktSize = failoverSize
+	}
+	if !given["cnt"] {
+		pktCount = pktMaxCount
+	}
+	if !given["t"] && !given["idle-timeout"] {
+		idleTimeout = failoverIdle
+	}
+	fmt.Printf("failover: %d packets of %d bytes, outages of %d or more packets are timed\n", pktCount, pktSize, failoverMin)
+}
+
+// failoverLog times the outages in packets received in order, late
+// packets are ignored.
+type failoverLog struct {
+	lastNo  uint16
+	lastAt  time.Time
+	runNo   uint16
+	runAt   time.Time
+	spacing time.Duration
+	outages []convergence
+}
+
+func (f *failoverLog) add(no uint16, at time.Time) {
+	if !f.lastAt.IsZero() && no <= f.lastNo {
+		return
+	}
+	if f.lastAt.IsZero() {
+		f.runNo, f.runAt = no, at
+	} else if lost := int(no-f.lastNo) - 1; lost >= failoverMin {
+		c := f.lost()
+		c.FirstAfter, c.FirstAfterAt, c.Lost = no, &at, lost
+		c.ConvergenceMs = ms(at.Sub(c.FirstLostAt))
+		fmt.Println(c)
+		emit("outage", map[string]interface{}{"last_before": c.LastBefore, "first_after": no, "lost": lost, "convergence_ms": c.ConvergenceMs})
+		f.outages = append(f.outages, c)
+		f.runNo, f.runAt = no, at
+	}
+	f.lastNo, f.lastAt = no, at
+}
+
+// lost starts an outage after the last packet.
+func (f *failoverLog) lost() convergence {
+	if n := f.lastNo - f.runNo; n > 0 {
+		f.spacing = f.lastAt.Sub(f.runAt) / time.Duration(n)
+	}
+	return convergence{LastBefore: f.lastNo, LastBeforeAt: f.lastAt, FirstLostAt: f.lastAt.Add(f.spacing)}
+}
+
+// result ends the test, counting packets missing after the last one
+// as an outage that did not recover.
+func (f *failoverLog) result(count int) *failovers {
+	if f.lastAt.IsZero() {
+		return nil
+	}
+	r := &failovers{Recovered: true}
+	if count-int(f.lastNo) >= failoverMin {
+		c := f.lost()
+		c.Lost = count - int(f.lastNo)
+		fmt.Println(c)
+		f.outages = append(f.outages, c)
+		r.Recovered = false
+	}
+	r.Outages = f.outages
+	for _, c := range f.outages {
+		if c.ConvergenceMs > r.ConvergenceMs {
+			r.ConvergenceMs = c.ConvergenceMs
+		}
+	}
+	return r
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// With -file the client sends a file instead of generated payload, one
+// chunk per packet, and announces its size and hash with the start
+// command. The server reassembles it in memory, verifies the hash and
+// writes it to its own -file path if given.
+
+var (
+	filePath string
+	fileData []byte
+	// cntGiven is -cnt before the file replaced it
+	cntGiven int
+)
+
+type fileInfo struct {
+	size int
+	sum  []byte // sha256
+}
+
+func (f *fileInfo) String() string {
+	return fmt.Sprintf("%d %x", f.size, f.sum)
+}
+
+func parseFileInfo(size, sum string) *fileInfo {
+	n, err := strconv.Atoi(size)
+	if err != nil || n <= 0 {
+		return nil
+	}
+	b, err := hex.DecodeString(sum)
+	if err != nil || len(b) != sha256.Size {
+		return nil
+	}
+	return &fileInfo{size: n, sum: b}
+}
+
+// chunks returns the packet count needed to send f.
+func (f *fileInfo) chunks() int {
+	pl := pktSize - pktInfSize
+	return (f.size + pl - 1) / pl
+}
+
+// loadFile reads the file to send and sets the packet count for it.
+func loadFile(path string) *fileInfo {
+	var err error
+	fileData, err = os.ReadFile(path)
+	ep(err)
+	sum := sha256.Sum256(fileData)
+	f := &fileInfo{size: len(fileData), sum: sum[:]}
+	if f.size == 0 || f.chunks() > pktMaxCount {
+		fmt.Fprintf(os.Stderr, "file should have between 1 and %d bytes with -p %d\n",
+			pktMaxCount*(pktSize-pktInfSize), pktSize)
+		os.Exit(1)
+	}
+	cntGiven, pktCount = pktCount, f.chunks()
+	return f
+}
+
+// verifyFile checks the file reassembled in s and writes it to path.
+func verifyFile(f *fileInfo, s *store, path string) {
+	if len(s.data) < f.size {
+		fmt.Println("file: nothing received")
+		return
+	}
+	data := s.data[:f.size]
+	sum := sha256.Sum256(data)
+	if bytes.Equal(sum[:], f.sum) {
+		fmt.Printf("file: %d bytes, sha256 %x ok\n", f.size, f.sum)
+	} else {
+		fmt.Printf("file: %d bytes, sha256 mismatch (want %x, got %x)\n", f.size, f.sum, sum)
+	}
+	if path != "" {
+		ep(os.WriteFile(path, data, 0o644))
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// flowLabelList is the -flowlabel flag: one IPv6 flow label per
+// sending socket.
+type flowLabelList []uint32
+
+func (l *flowLabelList) String() string {
+	s := make([]string, len(*l))
+	for i, v := range *l {
+		s[i] = fmt.Sprintf("%#x", v)
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *flowLabelList) Set(s string) error {
+	for _, f := range strings.Split(s, ",") {
+		v, err := strconv.ParseUint(strings.TrimSpace(f), 0, 20)
+		if err != nil {
+			return err
+		}
+		if v == 0 {
+			return errors.New("flow label 0 means none")
+		}
+		*l = append(*l, uint32(v))
+	}
+	return nil
+}
+
+// flowConn attaches a flow label to every datagram written.
+type flowConn struct {
+	*net.UDPConn
+	oob []byte
+}
+
+func (c *flowConn) Write(b []byte) (int, error) {
+	n, _, err := c.WriteMsgUDP(b, c.oob, nil)
+	return n, err
+}
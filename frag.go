@@ -0,0 +1,59 @@
+package main
+
+import "fmt"
+
+// With -frag the even numbered packets are sent with fragSize bytes
+// and DF clear, so they are fragmented on any usual path while the odd
+// ones are not.
+
+// datagramSize returns the largest datagram of the test.
+func datagramSize() int {
+	if fragSize > pktSize {
+		return fragSize
+	}
+	return pktSize
+}
+
+// payloadSize returns the payload size of packet no.
+func payloadSize(no uint16) int {
+	if ramp.replay {
+		return ramp.sizeOf(no) - pktInfSize
+	}
+	if gameHz > 0 {
+		return gameSize(no) - pktInfSize
+	}
+	if fragSize > 0 && no%2 == 0 {
+		return fragSize - pktInfSize
+	}
+	return pktSize - pktInfSize
+}
+
+// splitParity reports whether even packets go another way than odd
+// ones.
+func splitParity() bool {
+	return fragSize > 0 || zeroCsum || encap.kind != ""
+}
+
+// fragCounts counts received packets by fragmented (index 0) or not,
+// or with -zero-csum by udp checksum zero and with -encap by sent
+// through the tunnel or not.
+type fragCounts [2]int
+
+func (f *fragCounts) add(no uint16) {
+	f[no%2]++
+}
+
+func (f *fragCounts) stats(count int) []*pathStats {
+	odd, even := fmt.Sprintf("unfragmented (%d bytes)", pktSize), fmt.Sprintf("fragmented (%d bytes)", fragSize)
+	switch {
+	case zeroCsum:
+		odd, even = "udp checksum", "udp checksum zero"
+	case encap.kind != "":
+		odd, even = "underlay", encap.String()
+	}
+	whole := &pathStats{Name: odd}
+	whole.SetReceived((count+1)/2, f[1])
+	frag := &pathStats{Name: even}
+	frag.SetReceived(count/2, f[0])
+	return []*pathStats{whole, frag}
+}
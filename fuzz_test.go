@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// Run with go test -fuzz FuzzDecode and likewise for the others.
+
+func FuzzDecode(f *testing.F) {
+	b := make([]byte, 64)
+	n, _ := Encode(b, 7, []byte("payload"))
+	f.Add(b[:n])
+	f.Add(ctlPacket("stat", 10, 12))
+	f.Add([]byte{0, 0, 0, 0, '\r', '\n'})
+	f.Add([]byte{1, 0, 255, 255, '\r', '\n'})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		no, payload, err := Decode(b)
+		if err != nil {
+			if _, ok := err.(packetError); !ok {
+				t.Fatalf("error of type %T", err)
+			}
+			return
+		}
+		out := make([]byte, len(b))
+		n, err := Encode(out, no, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out[:n], b) {
+			t.Fatalf("decoded %d %q encodes to %q, not %q", no, payload, out[:n], b)
+		}
+	})
+}
+
+func FuzzEncode(f *testing.F) {
+	f.Add(uint16(1), []byte("payload"), 16)
+	f.Add(uint16(0), []byte(""), 0)
+	f.Fuzz(func(t *testing.T, no uint16, payload []byte, size int) {
+		if size < 0 || size > 1<<17 {
+			return
+		}
+		b := make([]byte, size)
+		n, err := Encode(b, no, payload)
+		if err != nil {
+			return
+		}
+		gotNo, got, err := Decode(b[:n])
+		if err != nil || gotNo != no || !bytes.Equal(got, payload) {
+			t.Fatalf("packet %d %q decoded to %d %q, %v", no, payload, gotNo, got, err)
+		}
+	})
+}
+
+func FuzzParseStart(f *testing.F) {
+	f.Add([]byte("start"))
+	f.Add([]byte("start 0123abcd file 1000 " + string(bytes.Repeat([]byte("ab"), 32))))
+	f.Add([]byte("start x file -1 zz"))
+	from := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		parseStart(b, from)
+	})
+}
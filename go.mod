@@ -1,3 +1,3 @@
 module github.com/dinalt/udptest
 
-go 1.16
+go 1.18
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// grafanaMetrics are the result figures the collector serves to the
+// grafana json datasource, nil if a result lacks one.
+var grafanaMetrics = map[string]func(r *result) *float64{
+	"loss_pct":        func(r *result) *float64 { return r.LossPct },
+	"jitter_ms":       func(r *result) *float64 { return r.JitterMs },
+	"throughput_mbps": func(r *result) *float64 { return &r.ThroughputMbps },
+	"rtt_median_ms": func(r *result) *float64 {
+		if r.RTT == nil {
+			return nil
+		}
+		return &r.RTT.Median
+	},
+}
+
+// grafanaQuery is the part of a grafana json datasource query the
+// collector uses.
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	Targets []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+}
+
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// handleGrafana serves the grafana json datasource api under /grafana:
+// / for the connection test, /search and /metrics listing the
+// metrics, and /query returning a series per metric and role and
+// label set, a point per result started in the range.
+func (st *collectorStore) handleGrafana(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/grafana", "/grafana/":
+		w.WriteHeader(http.StatusOK)
+	case "/grafana/search", "/grafana/metrics":
+		var names []string
+		for k := range grafanaMetrics {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		if r.URL.Path == "/grafana/search" {
+			writeHTTPJSON(w, names)
+			return
+		}
+		opts := make([]map[string]string, len(names))
+		for i, k := range names {
+			opts[i] = map[string]string{"label": k, "value": k}
+		}
+		writeHTTPJSON(w, opts)
+	case "/grafana/query":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var q grafanaQuery
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&q); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeHTTPJSON(w, st.query(&q))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (st *collectorStore) query(q *grafanaQuery) []*grafanaSeries {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	res := []*grafanaSeries{}
+	for _, t := range q.Targets {
+		get := grafanaMetrics[t.Target]
+		if get == nil {
+			continue
+		}
+		bySeries := map[string]*grafanaSeries{}
+		var names []string
+		for _, r := range st.results {
+			v := get(r)
+			if v == nil || r.Start.Before(q.Range.From) || !q.Range.To.IsZero() && r.Start.After(q.Range.To) {
+				continue
+			}
+			name := t.Target + "{role=" + r.Role
+			if len(r.Labels) > 0 {
+				name += "," + r.Labels.String()
+			}
+			name += "}"
+			s := bySeries[name]
+			if s == nil {
+				s = &grafanaSeries{Target: name, Datapoints: [][2]float64{}}
+				bySeries[name] = s
+				names = append(names, name)
+			}
+			s.Datapoints = append(s.Datapoints, [2]float64{*v, float64(r.Start.UnixNano() / 1e6)})
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			s := bySeries[name]
+			sort.Slice(s.Datapoints, func(i, j int) bool { return s.Datapoints[i][1] < s.Datapoints[j][1] })
+			res = append(res, s)
+		}
+	}
+	return res
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// happyDelay staggers the probes to the addresses of the target,
+	// as in happy eyeballs (rfc 8305).
+	happyDelay = 250 * time.Millisecond
+	// happyTimeout is how long the client waits for any address to
+	// answer before it falls back to the first one.
+	happyTimeout = 3 * time.Second
+)
+
+var pinAddr string
+
+// pickAddr resolves the host of addr and, if it has more than one
+// address, probes them with queries, ipv6 and ipv4 interleaved and
+// started happyDelay apart, and sets addr to the first that answers.
+// -pin-addr skips the probes.
+func pickAddr() {
+	host, port, err := net.SplitHostPort(addr)
+	ep(err)
+	if pinAddr != "" {
+		ip := net.ParseIP(pinAddr)
+		if ip == nil {
+			fmt.Fprintln(os.Stderr, "-pin-addr needs an ip address")
+			os.Exit(1)
+		}
+		addr = net.JoinHostPort(ip.String(), port)
+		return
+	}
+	if net.ParseIP(host) != nil {
+		return
+	}
+	ips := lookupTarget(host)
+	if len(ips) < 2 {
+		return
+	}
+	ips = interleave(ips)
+	won := make(chan net.IP, len(ips))
+	stop := make(chan struct{})
+	defer close(stop)
+	for i, ip := range ips {
+		go func(ip net.IP, wait time.Duration) {
+			select {
+			case <-time.After(wait):
+			case <-stop:
+				return
+			}
+			if answers(net.JoinHostPort(ip.String(), port), stop) {
+				won <- ip
+			}
+		}(ip, time.Duration(i)*happyDelay)
+	}
+	select {
+	case ip := <-won:
+		addr = net.JoinHostPort(ip.String(), port)
+		fmt.Printf("using %s, the first of %d addresses to answer\n", ip, len(ips))
+	case <-time.After(happyTimeout + time.Duration(len(ips)-1)*happyDelay):
+		addr = net.JoinHostPort(ips[0].String(), port)
+		fmt.Fprintf(os.Stderr, "none of %s answered, trying %s\n", addrList(ips), ips[0])
+	}
+}
+
+// interleave orders ips ipv6 first, alternating the families.
+func interleave(ips []net.IP) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	out := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(v4) || i < len(v6); i++ {
+		if i < len(v6) {
+			out = append(out, v6[i])
+		}
+		if i < len(v4) {
+			out = append(out, v4[i])
+		}
+	}
+	return out
+}
+
+// answers sends queries to a every acceptWait until an info reply
+// comes back, happyTimeout passes or stop is closed.
+func answers(a string, stop chan struct{}) bool {
+	d := net.Dialer{Control: dialControl}
+	con, err := d.Dial("udp", a)
+	if err != nil {
+		return false
+	}
+	defer con.Close()
+	req := make([]byte, querySize)
+	copy(req, queryMsg)
+	buf := make([]byte, querySize)
+	for end := time.Now().Add(happyTimeout); time.Now().Before(end); {
+		select {
+		case <-stop:
+			return false
+		default:
+		}
+		if _, err := con.Write(req); err != nil {
+			// unreachable, wait for the next try
+			time.Sleep(acceptWait)
+			continue
+		}
+		con.SetReadDeadline(time.Now().Add(acceptWait))
+		for {
+			n, err := con.Read(buf)
+			if err != nil {
+				break
+			}
+			no, payload, err := Decode(buf[:n])
+			if err == nil && no == 0 && strings.HasPrefix(string(payload), "info") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func addrList(ips []net.IP) string {
+	s := make([]string, len(ips))
+	for i, ip := range ips {
+		s[i] = ip.String()
+	}
+	return strings.Join(s, ", ")
+}
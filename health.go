@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// sessions counts tests started by the server.
+var sessions int64
+
+// startHealth serves liveness of the server listening on udp at
+// healthAddr, given as host:port[/path].
+func startHealth(udp net.Addr) {
+	if healthAddr == "" {
+		return
+	}
+	hostPort, path := healthAddr, "/healthz"
+	if i := strings.IndexByte(healthAddr, '/'); i >= 0 {
+		hostPort, path = healthAddr[:i], healthAddr[i:]
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":   "ok",
+			"udp":      udp.String(),
+			"sessions": atomic.LoadInt64(&sessions),
+		})
+	})
+	l, err := net.Listen("tcp", hostPort)
+	ep(err)
+	go func() {
+		ep(http.Serve(l, mux))
+	}()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// heartbeatInterval is how often the server tells a one-way test's
+// client it is still there, from protocol version 3 on. Missing
+// heartbeats tell a dead server, heartbeats that never come a blocked
+// reverse path, from each other when no results arrive.
+const heartbeatInterval = time.Second
+
+// startHeartbeat sends numbered beats to client until the returned
+// function is called.
+func startHeartbeat(con net.PacketConn, client net.Addr, local net.IP) func() {
+	stop := make(chan struct{})
+	go func() {
+		t := time.NewTicker(heartbeatInterval)
+		defer t.Stop()
+		for n := 1; ; n++ {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				_ = replyTo(con, ctlPacket("beat", n), client, local)
+			}
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// heartbeats tracks the beats the client receives.
+type heartbeats struct {
+	t0   time.Time
+	n    int64
+	last int64
+}
+
+var beats *heartbeats
+
+func (h *heartbeats) beat() {
+	if h == nil {
+		return
+	}
+	atomic.AddInt64(&h.n, 1)
+	atomic.StoreInt64(&h.last, time.Now().UnixNano())
+}
+
+// silence returns how long no beat came, and if any did.
+func (h *heartbeats) silence(now time.Time) (time.Duration, bool) {
+	last := atomic.LoadInt64(&h.last)
+	if last == 0 {
+		return now.Sub(h.t0), false
+	}
+	return now.Sub(time.Unix(0, last)), true
+}
+
+// watch warns once while sending if beats stop or never come.
+func (h *heartbeats) watch(stop <-chan struct{}) {
+	t := time.NewTicker(heartbeatInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-t.C:
+			d, any := h.silence(now)
+			if d < 3*heartbeatInterval {
+				continue
+			}
+			if any {
+				fmt.Printf("no heartbeat from the server for %v, it likely stopped\n", d.Round(time.Second))
+			} else {
+				fmt.Printf("no heartbeats from the server in %v, the path back from it is blocked\n", d.Round(time.Second))
+			}
+			emit("heartbeat_lost", map[string]interface{}{"silence_ms": ms(d), "received": atomic.LoadInt64(&h.n)})
+			return
+		}
+	}
+}
+
+// String tells how the beats went, if one was due.
+func (h *heartbeats) String() string {
+	if h == nil {
+		return ""
+	}
+	d, any := h.silence(time.Now())
+	n := atomic.LoadInt64(&h.n)
+	switch {
+	case !any && d < 2*heartbeatInterval:
+		return ""
+	case !any:
+		return "heartbeats: none received, the path back from the server is blocked"
+	case d >= 3*heartbeatInterval:
+		return fmt.Sprintf("heartbeats: %d, the last %v before the end, the server likely stopped", n, d.Round(time.Millisecond))
+	}
+	return fmt.Sprintf("heartbeats: %d", n)
+}
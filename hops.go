@@ -0,0 +1,57 @@
+package main
+
+import "fmt"
+
+// hopTracker infers the path length of received packets from their TTL
+// and counts changes of it, which usually mean a reroute.
+type hopTracker struct {
+	n       int
+	last    int
+	min     int
+	max     int
+	changes int
+}
+
+// inferHops assumes the sender started from the nearest common initial
+// TTL not below the received one.
+func inferHops(ttl int) int {
+	for _, initial := range []int{32, 64, 128, 255} {
+		if ttl <= initial {
+			return initial - ttl
+		}
+	}
+	return 0
+}
+
+func (h *hopTracker) add(ttl int, no uint16) {
+	if ttl < 0 {
+		return
+	}
+	hops := inferHops(ttl)
+	if h.n > 0 && hops != h.last {
+		h.changes++
+		emit("hops", map[string]interface{}{"packet": no, "from": h.last, "to": hops})
+	}
+	if h.n == 0 || hops < h.min {
+		h.min = hops
+	}
+	if hops > h.max {
+		h.max = hops
+	}
+	h.last = hops
+	h.n++
+}
+
+func (h *hopTracker) fill(r *result) {
+	if h.n == 0 {
+		return
+	}
+	r.Hops = &hopStats{Min: h.min, Max: h.max, Last: h.last, Changes: h.changes}
+}
+
+func (h *hopTracker) String() string {
+	if h.min == h.max {
+		return fmt.Sprintf("hops: %d", h.last)
+	}
+	return fmt.Sprintf("hops: %d-%d, last %d, changes: %d", h.min, h.max, h.last, h.changes)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var influxEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+// influxLine formats r as an influx line protocol point, as understood by
+// the telegraf exec and execd inputs. Labels become tags.
+func influxLine(r *result) string {
+	var b strings.Builder
+	b.WriteString("udptest,role=")
+	b.WriteString(influxEscaper.Replace(r.Role))
+	keys := make([]string, 0, len(r.Labels))
+	for k := range r.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if r.Labels[k] == "" {
+			continue
+		}
+		fmt.Fprintf(&b, ",%s=%s", influxEscaper.Replace(k), influxEscaper.Replace(r.Labels[k]))
+	}
+	fields := []string{
+		"count=" + strconv.Itoa(r.Count) + "i",
+		"sent=" + strconv.Itoa(r.Sent) + "i",
+		"received=" + strconv.Itoa(r.Received) + "i",
+		"duplicates=" + strconv.Itoa(r.Duplicates) + "i",
+		"late=" + strconv.Itoa(r.Late) + "i",
+		"duration_ms=" + influxFloat(r.DurationMs),
+		"throughput_mbps=" + influxFloat(r.ThroughputMbps),
+	}
+	if r.TestID != "" {
+		fields = append(fields, `test_id="`+r.TestID+`"`)
+	}
+	if r.LossPct != nil {
+		fields = append(fields, "loss_pct="+influxFloat(*r.LossPct))
+	}
+	if r.JitterMs != nil {
+		fields = append(fields, "jitter_ms="+influxFloat(*r.JitterMs))
+	}
+	if r.RTT != nil {
+		fields = append(fields,
+			"rtt_min_ms="+influxFloat(r.RTT.Min),
+			"rtt_avg_ms="+influxFloat(r.RTT.Avg),
+			"rtt_median_ms="+influxFloat(r.RTT.Median),
+			"rtt_max_ms="+influxFloat(r.RTT.Max))
+	}
+	b.WriteByte(' ')
+	b.WriteString(strings.Join(fields, ","))
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(r.Start.UnixNano(), 10))
+	b.WriteByte('\n')
+	return b.String()
+}
+
+func influxFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+var influxOut io.Writer
+
+// setupInflux makes stdout exclusive to the influx output if it is
+// written there.
+func setupInflux() {
+	if influxPath == "-" {
+		influxOut = takeStdout()
+	}
+}
+
+// writeInflux writes r to path, or to stdout if path is "-".
+func writeInflux(path string, r *result) {
+	switch path {
+	case "":
+	case "-":
+		_, err := io.WriteString(influxOut, influxLine(r))
+		ep(err)
+	default:
+		ep(os.WriteFile(path, []byte(influxLine(r)), 0o644))
+	}
+}
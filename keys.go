@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"time"
+)
+
+// readKeys passes the keys pressed on the terminal to handle until
+// the returned function is called. Where the terminal can not be put
+// in raw mode keys take effect with enter.
+func readKeys(handle func(byte)) func() {
+	restore, err := rawTerminal(int(os.Stdin.Fd()))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "keys take effect with enter:", err)
+		restore = func() {}
+	}
+	// leave the terminal usable on ctrl-c
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-sig:
+			restore()
+			os.Exit(130)
+		case <-stop:
+		}
+	}()
+	go func() {
+		b := make([]byte, 1)
+		for {
+			if n, err := os.Stdin.Read(b); err != nil {
+				return
+			} else if n == 1 {
+				handle(b[0])
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sig)
+		close(stop)
+		restore()
+	}
+}
+
+// testKey handles the keys of -interactive mode: s prints how the test
+// is going, p pauses and resumes sending, m adds a marker with the label
+// typed after it, q ends the test and the rest go to rateKey.
+func testKey(k byte) {
+	if typed.key(k) {
+		return
+	}
+	switch k {
+	case 's':
+		fmt.Println(snapshot())
+	case 'p':
+		if c := pause(); c != nil {
+			fmt.Printf("paused at +%.1fs, p resumes\n", time.Since(rateT0).Seconds())
+			trc.comment(fmt.Sprintf("pause at %d", time.Now().UnixNano()))
+			emit("pause", nil)
+			go holdPause(ctlCon, c)
+		} else {
+			d := resume()
+			_, _ = ctlCon.Write(ctlPacket("resume"))
+			fmt.Printf("resumed after %v\n", d.Round(time.Millisecond))
+			trc.comment(fmt.Sprintf("resume at %d", time.Now().UnixNano()))
+			emit("resume", map[string]interface{}{"paused_ms": ms(d)})
+		}
+	case 'q':
+		abort("ended with q")
+	default:
+		rateKey(k)
+	}
+}
+
+// pauseRepeat is how often a paused client tells the server, it must
+// be shorter than the server's -idle-timeout.
+const pauseRepeat = time.Second
+
+var (
+	// ctlCon carries pauses and markers to the server.
+	ctlCon net.Conn
+	typed  markerLine
+)
+
+// holdPause tells the server the client paused, again every
+// pauseRepeat until resumed is closed, so it neither counts an outage
+// nor times out.
+func holdPause(con net.Conn, resumed chan struct{}) {
+	t := time.NewTicker(pauseRepeat)
+	defer t.Stop()
+	for {
+		_, _ = con.Write(ctlPacket("pause"))
+		select {
+		case <-resumed:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// snapshot is what s prints: time, packets sent and the rate, with
+// the echo replies so far.
+func snapshot() string {
+	s := fmt.Sprintf("+%.1fs: sent %d of %d, rate %.2f Mbit/s",
+		time.Since(rateT0).Seconds(), atomic.LoadInt64(&sentNo), pktCount, currentRate()/1e6)
+	if echoes != nil {
+		s += "\n" + echoes.String()
+	}
+	return s
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// refuse answers a start command asking for more than -max-rate, or
+// from a client beyond its -quota, with an error, so a public server
+// can not be made to saturate its link, and reports whether it did.
+// Files needing more packets than a test can have are refused too.
+func refuse(con net.PacketConn, cmd *startCmd) bool {
+	if cmd.file != nil && cmd.file.size > pktMaxCount*(pktSize-pktInfSize) {
+		refuseWith(con, cmd, fmt.Sprintf("file of %d bytes over %d packets with -p %d", cmd.file.size, pktMaxCount, pktSize))
+		return true
+	}
+	if why := quotaUsed(cmd.from); why != "" {
+		refuseWith(con, cmd, why)
+		return true
+	}
+	switch {
+	case maxRate <= 0:
+		return false
+	case cmd.rate == 0:
+		refuseWith(con, cmd, "send rate not declared")
+	case overRate(float64(cmd.rate)):
+		refuseWith(con, cmd, fmt.Sprintf("send rate %.2f Mbit/s over max-rate %g", float64(cmd.rate)/1e6, maxRate))
+	default:
+		return false
+	}
+	return true
+}
+
+func refuseWith(con net.PacketConn, cmd *startCmd, reason string) {
+	fmt.Printf("refused %s: %s\n", cmd.from, reason)
+	emit("refused", map[string]interface{}{"client": cmd.from.String(), "reason": reason})
+	ep(replyTo(con, ctlPacket("error", reason), cmd.from, cmd.local))
+}
+
+// quotaUsed returns why client may not be served more, if it used up
+// its -quota.
+func quotaUsed(client net.Addr) string {
+	if left, over := served.exceeded(client); over {
+		return fmt.Sprintf("quota %s used up, retry in %v", quota.text, left.Round(time.Minute))
+	}
+	return ""
+}
+
+// endSession tells client why its running test is stopped.
+func endSession(con net.PacketConn, client net.Addr, local net.IP, why string) {
+	fmt.Println("ending the session:", why)
+	emit("refused", map[string]interface{}{"client": client.String(), "reason": why})
+	_ = replyTo(con, ctlPacket("error", why), client, local)
+}
+
+const (
+	// rateWindow is how long a session's rate is measured over.
+	rateWindow = time.Second
+	// rateSlack allows for bursts within a window.
+	rateSlack = 1.1
+)
+
+// rateMeter measures what a session receives and reflects, a client
+// declaring less than it sends is stopped at -max-rate too.
+type rateMeter struct {
+	start   time.Time
+	in, out int64
+}
+
+// add counts a datagram of in bytes received at at and out reflected,
+// and returns why the session goes over -max-rate, if it does.
+func (m *rateMeter) add(at time.Time, in, out int) string {
+	if m.start.IsZero() {
+		m.start = at
+	}
+	m.in += int64(in)
+	m.out += int64(out)
+	d := at.Sub(m.start)
+	if d < rateWindow {
+		return ""
+	}
+	rx, tx := float64(m.in*8)/d.Seconds(), float64(m.out*8)/d.Seconds()
+	*m = rateMeter{start: at}
+	switch {
+	case overRate(rx / rateSlack):
+		return fmt.Sprintf("receiving %.2f Mbit/s over max-rate %g", rx/1e6, maxRate)
+	case overRate(tx / rateSlack):
+		return fmt.Sprintf("reflecting %.2f Mbit/s over max-rate %g", tx/1e6, maxRate)
+	}
+	return ""
+}
+
+// overRate reports whether bits per second exceed -max-rate.
+func overRate(bps float64) bool {
+	return maxRate > 0 && bps > maxRate*1e6
+}
+
+// sendRate is the rate the client declares, in bit/s, or 0 if it
+// neither paces nor shapes. With -ramp it is the profile's peak.
+func sendRate() int64 {
+	if ramp.segs != nil {
+		return int64(ramp.peak())
+	}
+	if sendInterval <= 0 {
+		return int64(shape.rate)
+	}
+	r := int64(float64(pktSize*8) * float64(time.Second) / float64(sendInterval))
+	if shape.rate > 0 && int64(shape.rate) < r {
+		return int64(shape.rate)
+	}
+	return r
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// lostRanges lists packet numbers from 1 to count not marked in seen,
+// collapsing runs into "first-last".
+func lostRanges(seen []bool, count int) []string {
+	var res []string
+	for no := 1; no <= count; no++ {
+		if seen[no] {
+			continue
+		}
+		first := no
+		for no < count && !seen[no+1] {
+			no++
+		}
+		r := strconv.Itoa(first)
+		if no > first {
+			r += "-" + strconv.Itoa(no)
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+func writeLost(path string, ranges []string) {
+	if path == "" {
+		return
+	}
+	var b strings.Builder
+	for _, r := range ranges {
+		b.WriteString(r)
+		b.WriteByte('\n')
+	}
+	ep(os.WriteFile(path, []byte(b.String()), 0o644))
+}
@@ -2,15 +2,23 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
-	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"flag"
 	"fmt"
+	"hash"
+	"hash/fnv"
 	"io"
 	"net"
+	"net/http"
+	_ "net/http/pprof"
 	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -25,33 +33,207 @@ const (
 	pktInfSize  = pktHdrSize + pktEndSize
 	pktMaxSize  = 0b1111_1111_1111_1111
 	pktMaxCount = 0b1111_1111_1111_1111
+	gsoMaxSegs  = 64    // UDP_MAX_SEGMENTS
+	gsoMaxSize  = 65507 // max udp payload
 )
 
 var (
-	isServer     bool
-	pktSize      int
-	pktCount     int
-	addr         string
-	rwTimeout    time.Duration
-	sendInterval time.Duration
-	useMem       bool
-	help         bool
+	isServer      bool
+	responder     bool
+	showCaps      bool
+	envReport     bool
+	autoTune      time.Duration
+	pktSize       int
+	pktCount      int
+	addr          string
+	setupTimeout  time.Duration
+	idleTimeout   time.Duration
+	grace         time.Duration
+	sendInterval  time.Duration
+	pps           float64
+	useMem        bool
+	fwMark        int
+	gsoSegs       int
+	sendWorkers   int
+	pprofAddr     string
+	catchUp       bool
+	tracePath     string
+	capturePath   string
+	captureLoss   = percent(1)
+	targetLoss    = percent(0.5)
+	captureWindow time.Duration
+	syncProbes    int
+	maxOffset     time.Duration
+	requireSync   bool
+	hopReport     bool
+	ttlSweepMax   int
+	ttlBurst      int
+	flowLabels    flowLabelList
+	dscps         dscpList
+	sprayPorts    int
+	fragSize      int
+	tunnel        overhead
+	keepalive     time.Duration
+	progress      bool
+	statsJSONPath string
+	metricsAddr   string
+	rxQueue       int
+	queueLen      int
+	maxClients    int
+	maxRate       float64
+	requireCookie bool
+	sessionDir    string
+	sessionKeep   int
+	sessionAge    time.Duration
+	interactive   bool
+	replayPath    string
+	adaptive      bool
+	controlAddr   string
+	ioBackend     string
+	xdpIface      string
+	watchDrops    bool
+	busyPoll      time.Duration
+	cpuPin        string
+	gomaxprocs    int
+	echo          bool
+	replySize     int
+	downstream    bool
+	rampLoss      float64
+	rampStep      time.Duration
+	jsonPath      string
+	lostPath      string
+	service       bool
+	healthAddr    string
+	eventsFormat  string
+	mqttURL       string
+	mqttIntervals bool
+	influxPath    string
+	probeEvery    time.Duration
+	collectorURL  string
+	runLabels     = labels{}
+	abortLoss     percent
+	abortWindow   time.Duration
+	monitor       *lossMonitor
+	trc           *tracer
+	echoes        *echoStats
+	help          bool
 )
 
 func init() {
-	flag.BoolVar(&isServer, "l", false, "listen")
+	flag.BoolVar(&isServer, "l", false, "listen, on a port range like :7000-7010 with a server per port")
+	flag.DurationVar(&autoTune, "auto-tune", 0, "size the receive buffer to absorb a reader stall this long at the -i or -pps rate")
+	flag.BoolVar(&envReport, "env", false, "print the os, interface, offload and socket buffer settings in use, also added to -json results")
+	flag.BoolVar(&showCaps, "caps", false, "print which platform dependent features are available and exit")
+	flag.BoolVar(&responder, "responder", false, "listen as a minimal responder reflecting data packets, see proto")
 	flag.BoolVar(&useMem, "m", false, "store received data in memory")
 	flag.IntVar(&pktSize, "p", 1500, "paket size")
 	flag.IntVar(&pktCount, "cnt", 60000, "send / receive count")
-	flag.DurationVar(&rwTimeout, "t", 5*time.Second, "read and write operation timeout")
+	flag.DurationVar(&setupTimeout, "setup-timeout", 0, "max wait for the start command or clock probe replies (0: server waits forever)")
+	flag.DurationVar(&idleTimeout, "idle-timeout", 5*time.Second, "max gap between received packets")
+	flag.DurationVar(&grace, "grace", 200*time.Millisecond, "how long the server keeps counting late and duplicate packets after the test")
+	flag.DurationVar(&idleTimeout, "t", 5*time.Second, "same as -idle-timeout")
 	flag.DurationVar(&sendInterval, "i", 2*time.Millisecond, "send interval")
+	flag.Float64Var(&pps, "pps", 0, "packets per second to send, overrides -i")
+	flag.IntVar(&fwMark, "fwmark", 0, "SO_MARK for outgoing packets (linux only)")
+	flag.IntVar(&gsoSegs, "gso", 1, "packets per UDP_SEGMENT super-buffer (linux only)")
+	flag.IntVar(&sendWorkers, "send-workers", 1, "number of sending goroutines, each with own socket (disables checksum)")
+	flag.Var(&ramp, "ramp", "client: follow a rate profile, steps <from>-><to>:<duration>, <rate>:<duration> or hold:<duration>, setting -cnt")
+	flag.StringVar(&profileSpec, "profile", "", "client: send like an application, game[:<hz>] for small packets at 20-60 Hz with echo, video[:<rate>,<fps>] for frame bursts")
+	flag.BoolVar(&advertise, "advertise", false, "server: publish _udptest._udp over multicast dns")
+	flag.BoolVar(&discover, "discover", false, "client: list servers advertising on the local network and test the first if no address is given")
+	flag.StringVar(&dnsServer, "dns", "", "client: resolve the target with this server, e.g. 1.1.1.1:53, resolution time and addresses go into the results")
+	flag.DurationVar(&reresolveEvery, "reresolve", 0, "client, with -probe: resolve the target host again this often and note address changes")
+	flag.BoolVar(&followDNS, "follow-dns", false, "client, with -reresolve: reconnect when the address in use is no longer returned")
+	flag.StringVar(&pinAddr, "pin-addr", "", "client: use this address of the target instead of probing all it resolves to for the first to answer")
+	flag.BoolVar(&dualStack, "dual", false, "client: run the test to the ipv4 and then the ipv6 address of the target and compare them (server: -service)")
+	flag.BoolVar(&failover, "failover", false, "time outages for ha tests: light continuous traffic, -i 10ms -p 64 -cnt 65535 -t 1m unless given, with the convergence time of each (both sides)")
+	flag.StringVar(&replayPath, "replay", "", "client: follow a schedule of time,rate,size rows from a csv or json file, setting -cnt and -p")
+	flag.Var(&shape, "shape", "client: pass sends through a token bucket, rate=<bit/s, k/M/G>[,burst=<bytes>|<n>pkt]")
+	flag.BoolVar(&catchUp, "catch-up", false, "send missed packets in a burst when falling behind the interval")
+	flag.StringVar(&tracePath, "trace", "", "write per-packet send or receive timestamps to this file")
+	flag.StringVar(&capturePath, "capture", "", "server: when an interval's loss reaches -capture-loss, write the packets of the following -capture-window to this pcapng file")
+	flag.Var(&captureLoss, "capture-loss", "interval loss that triggers -capture")
+	flag.DurationVar(&captureWindow, "capture-window", 10*time.Second, "how long -capture records after each trigger")
+	flag.IntVar(&syncProbes, "sync", 0, "estimate server clock offset with this many probes before the test")
+	flag.DurationVar(&resyncEvery, "resync", 0, "client: re-estimate the clock offset this often during the test for drift, noted in -trace for analyze (implies -sync 8)")
+	flag.DurationVar(&maxOffset, "max-offset", 10*time.Millisecond, "warn if the estimated clock offset is larger")
+	flag.Var(&flowLabels, "flowlabel", "ipv6 flow label, or comma separated labels sent from one socket each (linux, per label figures with -echo)")
+	flag.Var(&dscps, "dscp", "send from one socket per comma separated dscp value, e.g. be,af41,ef, and report figures per class (both sides, rtt with -echo, linux)")
+	flag.Var(&pathKeys, "path-key", "server: comma separated packet attributes to report figures per path by: addr, port, dscp, flowlabel")
+	flag.StringVar(&filePath, "file", "", "client: send this file as payload, setting -cnt; server: write received files here")
+	flag.StringVar(&payloadMode, "payload", payloadMode, "payload content: random, zero, text or dict, to expose compressing links (both sides with -m)")
+	flag.StringVar(&ioBackend, "io", "std", "socket io: std, or uring for io_uring on linux (falls back to std)")
+	flag.StringVar(&xdpIface, "xdp", "", "server: experimental AF_XDP receive of the test port on this interface's first queue (linux, root)")
+	flag.BoolVar(&watchDrops, "drops", false, "server: attribute kernel packet drops during the test by reason with an eBPF probe (linux, root)")
+	flag.DurationVar(&busyPoll, "busy-poll", 0, "server: set SO_BUSY_POLL to this and spin reading the socket on a pinned cpu for precise receive times (linux, above net.core.busy_read needs CAP_NET_ADMIN)")
+	flag.StringVar(&cpuPin, "cpu-pin", "", "pin to these cpus, e.g. 2,3 or 2-5; send workers and the receive loop get one each, round robin (linux)")
+	flag.IntVar(&gomaxprocs, "gomaxprocs", 0, "set GOMAXPROCS, 0 keeps the default")
+	flag.IntVar(&rxQueue, "rx-queue", 0, "server: read the socket on its own goroutine into a queue of this many packets")
+	flag.BoolVar(&progress, "progress", false, "server: print received packets every interval")
+	flag.StringVar(&statsJSONPath, "stats-json", "", "server: append received packets every interval as json lines to this file (- for stdout)")
+	flag.StringVar(&metricsAddr, "metrics", "", "server: serve prometheus metrics on host:port/metrics")
+	flag.DurationVar(&keepalive, "keepalive", 0, "send a control packet this often to hold nat and firewall state in sparse tests")
+	flag.Var(&tunnel, "overhead", "size packets to fit -mtu inside a vxlan, gre, ipsec or custom:N bytes tunnel, overriding -p (both sides)")
+	flag.IntVar(&tunnel.MTU, "mtu", 1500, "underlay mtu for -overhead")
+	flag.IntVar(&fragSize, "frag", 0, "send every even packet with this size and DF clear, to be fragmented, and report its loss separately (both sides)")
+	flag.IntVar(&sprayPorts, "spray-ports", 0, "send from this many source ports and report figures per port (per port rtt with -echo)")
+	flag.BoolVar(&hopReport, "hops", false, "server: report the hop count inferred from received TTLs (linux)")
+	flag.IntVar(&ttlSweepMax, "ttl-sweep", 0, "instead of a test send -ttl-burst packets with each ttl up to this and report who answers and the loss per hop (server: -responder, linux)")
+	flag.IntVar(&ttlBurst, "ttl-burst", 10, "packets per ttl for -ttl-sweep")
+	flag.BoolVar(&requireSync, "require-sync", false, "refuse to run if the clock offset exceeds -max-offset (implies -sync 8)")
+	flag.BoolVar(&echo, "echo", false, "reflect received packets back to the sender (both sides)")
+	flag.Var(&unsafeRaw, "unsafe-raw", "client: send data packets through a raw socket with src=<ipv4>, ttl=<n>, df=0|1 and id=<n>|inc in the ip header, a spoofed src gets no replies (linux, root or cap_net_raw, ipv4)")
+	flag.Var(&encap, "encap", "send every even packet in a vxlan|geneve[:vni=<n>][,port=<n>] frame to the tunnel port and report overlay and underlay loss apart (both sides)")
+	flag.BoolVar(&zeroCsum, "zero-csum", false, "send every even packet with udp checksum 0 through -unsafe-raw and report its loss separately (both sides)")
+	flag.BoolVar(&hwTxStamps, "hw-tx-ts", false, "client: have the nic stamp sent packets and take the stamps as echo send times, its clock synced to the system clock e.g. by phc2sys (linux, root, -echo)")
+	flag.BoolVar(&owdStamps, "owd", false, "echo: stamp replies with the server receive and send times and report the one-way delay of each direction, corrected by -sync (both sides, implies -sync 8)")
+	flag.IntVar(&replySize, "reply-size", 0, "size of reflected packets in echo mode (default: received size)")
+	flag.BoolVar(&downstream, "downstream", false, "discover downstream rate limit: server sends with increasing rate (both sides)")
+	flag.Float64Var(&rampLoss, "ramp-loss", 1, "loss percent that ends -downstream discovery")
+	flag.DurationVar(&rampStep, "ramp-step", time.Second, "duration of each -downstream rate step")
+	flag.StringVar(&jsonPath, "json", "", "write the run summary as json to this file")
+	flag.StringVar(&lostPath, "lost-out", "", "write numbers of lost packets to this file")
+	flag.Var(runLabels, "label", "key=value attached to the results, can be repeated")
+	flag.Var(&abortLoss, "abort-loss", "abort the test if the server reports this loss after -abort-window")
+	flag.DurationVar(&abortWindow, "abort-window", 2*time.Second, "evaluation window for -abort-loss")
+	flag.IntVar(&queueLen, "queue", 0, "with -service queue up to this many clients arriving during a session and start them in turn, instead of answering busy")
+	flag.IntVar(&maxClients, "max-clients", 0, "server: refuse clients beyond this many in session and -queue")
+	flag.Var(&quota, "quota", "server: refuse clients served more than this per ip, e.g. 10GB/day, and end their tests going over it, needs -service")
+	flag.Float64Var(&maxRate, "max-rate", 0, "server: refuse tests declaring a send rate above this many Mbit/s and cap -downstream")
+	flag.BoolVar(&requireCookie, "cookie", false, "server: require a cookie round trip before answering a start, client: pad sync probes to their reply size (both sides)")
+	flag.BoolVar(&service, "service", false, "keep the server running for one test after another (systemd Type=notify aware, a windows service if started as one, see install-service)")
+	flag.StringVar(&sessionDir, "session-dir", "", "with -service write each session's output and json result to files in this directory")
+	flag.IntVar(&sessionKeep, "session-keep", 1000, "keep the files of this many latest sessions in -session-dir")
+	flag.DurationVar(&sessionAge, "session-age", 0, "remove files of sessions older than this from -session-dir (0 keeps them)")
+	flag.BoolVar(&adaptive, "adaptive", false, "client: adapt the send rate to the loss the server reports, aimd style, to find the sustainable rate")
+	flag.Var(&targetLoss, "target-loss", "loss -adaptive keeps the rate under")
+	flag.BoolVar(&interactive, "interactive", false, "client: read keys from the terminal during the test, + and - change the send rate by 25%, s prints stats, p pauses and resumes, m adds a marker with the label typed after it, q ends the test")
+	flag.StringVar(&controlAddr, "control", "", "client: serve an http api on host:port during the test, POST /rate?mbps=n or ?factor=f changes the send rate, POST /marker?label=text adds a marker")
+	flag.StringVar(&healthAddr, "health", "", "serve server liveness over http on host:port[/path] (default path /healthz)")
+	flag.StringVar(&eventsFormat, "events", "", "emit events to stdout in this format (jsonl), human output goes to stderr")
+	flag.StringVar(&mqttURL, "mqtt", "", "publish results to mqtt://[user:pass@]host[:port][/topic]")
+	flag.BoolVar(&mqttIntervals, "mqtt-intervals", false, "also publish interval stats to -mqtt")
+	flag.StringVar(&influxPath, "influx", "", "write the result in influx line protocol to this file (- for stdout)")
+	flag.StringVar(&openMetricsPath, "write-openmetrics", "", "replace this file with the result as metrics for the node_exporter textfile collector")
+	flag.DurationVar(&probeEvery, "probe", 0, "send a -cnt echo burst this often forever and print rrdtool update lines (server: -service -echo)")
+	flag.StringVar(&collectorURL, "collector", "", "upload results to the collector at this http url")
+	flag.StringVar(&pprofAddr, "pprof", "", "expose net/http/pprof on this address while running")
 	flag.BoolVar(&help, "h", false, "print help")
 }
 
 func usage() {
 	fmt.Print("Simple command line utility for test udp package losses.\n")
-	fmt.Printf("Usage: %s [flags] <listen or dest address>.\n\n", os.Args[0])
-	fmt.Print("WARN: -p and -cnd should match on both sending and receiving sides.\n\n")
+	fmt.Printf("Usage: %s [flags] <listen or dest address>.\n", os.Args[0])
+	fmt.Printf("       %s analyze <send trace> <receive trace>.\n", os.Args[0])
+	fmt.Printf("       %s aggregate [-csv path] <result.json>...\n", os.Args[0])
+	fmt.Printf("       %s compare [flags] <before.json> <after.json>\n", os.Args[0])
+	fmt.Printf("       %s mesh -self name <peer list>\n", os.Args[0])
+	fmt.Printf("       %s collector [-listen addr] [-dir path]\n", os.Args[0])
+	fmt.Printf("       %s query [-json] <host:port>\n", os.Args[0])
+	fmt.Printf("       %s proto [-vectors path]\n", os.Args[0])
+	fmt.Printf("       %s schema\n", os.Args[0])
+	fmt.Printf("       %s install-service|uninstall-service <name> [server flags] <address> (windows)\n\n", os.Args[0])
+	fmt.Print("WARN: -p, -cnt, -echo and -downstream should match on both sending and receiving sides.\n\n")
 
 	flag.PrintDefaults()
 }
@@ -63,187 +245,1160 @@ func main() {
 		return
 	}
 	flag.CommandLine.Usage = usage
+	if showCaps {
+		printCaps()
+		return
+	}
 	if flag.NArg() == 0 && flag.NFlag() == 0 {
 		usage()
 		return
 	}
+	setupEvents(eventsFormat)
+	setupInflux()
+	switch flag.Arg(0) {
+	case "analyze":
+		analyze(flag.Args()[1:])
+		return
+	case "aggregate":
+		aggregate(flag.Args()[1:])
+		return
+	case "compare":
+		compare(flag.Args()[1:])
+		return
+	case "mesh":
+		mesh(flag.Args()[1:])
+		return
+	case "collector":
+		collector(flag.Args()[1:])
+		return
+	case "query":
+		query(flag.Args()[1:])
+		return
+	case "install-service":
+		installService(flag.Args()[1:])
+		return
+	case "uninstall-service":
+		uninstallService(flag.Args()[1:])
+		return
+	case "schema":
+		schema(flag.Args()[1:])
+		return
+	case "proto":
+		proto(flag.Args()[1:])
+		return
+	}
 	addr = flag.Arg(0)
+	if discover && !isServer && addr == "" {
+		addr = discoverTarget()
+	}
+	if host, lo, hi, ok := portRange(addr); ok && isServer {
+		listenRange(host, lo, hi)
+		return
+	}
+	setupResolver()
+	if tunnel.Name != "" {
+		pktSize = tunnel.autoSize(addr)
+		fmt.Printf("overhead: %s %d bytes, underlay mtu %d, packet size %d\n",
+			tunnel.Name, tunnel.Bytes, tunnel.MTU, pktSize)
+		if pktSize < pktInfSize {
+			fmt.Fprintln(os.Stderr, "no room for packets within -mtu")
+			os.Exit(1)
+		}
+	}
 	if pktCount > pktMaxCount {
 		fmt.Fprintf(os.Stderr, "max packet count: %d", pktMaxCount)
 	}
 	if pktSize > pktMaxSize {
 		fmt.Fprintf(os.Stderr, "max packet size: %d", pktMaxSize)
 	}
+	if gsoSegs < 1 || gsoSegs > gsoMaxSegs || gsoSegs*pktSize > gsoMaxSize {
+		fmt.Fprintf(os.Stderr, "gso: at most %d packets and %d bytes per write\n", gsoMaxSegs, gsoMaxSize)
+		os.Exit(1)
+	}
+	if ioBackend != "std" && ioBackend != "uring" {
+		fmt.Fprintln(os.Stderr, "io should be std or uring")
+		os.Exit(1)
+	}
+	if isServer && len(dscps) > 0 && !pathKeys.has("dscp") {
+		pathKeys = append(pathKeys, "dscp")
+	}
+	degrade()
+	if cpuPin != "" {
+		cpus, err := parseCPUList(cpuPin)
+		if err == nil {
+			err = pinProcess(cpus)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "cpu-pin:", err)
+			os.Exit(1)
+		}
+		pinCPUs = cpus
+	}
+	if gomaxprocs < 0 {
+		fmt.Fprintln(os.Stderr, "gomaxprocs should be positive")
+		os.Exit(1)
+	}
+	if gomaxprocs > 0 {
+		runtime.GOMAXPROCS(gomaxprocs)
+	}
+	if err := checkPayloadMode(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if fragSize != 0 && (fragSize <= pktSize || fragSize > gsoMaxSize || gsoSegs > 1) {
+		fmt.Fprintf(os.Stderr, "frag should be between -p and %d and needs -gso 1\n", gsoMaxSize)
+		os.Exit(1)
+	}
+	if replySize != 0 && (replySize < pktInfSize || replySize > pktMaxSize) {
+		fmt.Fprintf(os.Stderr, "reply-size should be between %d and %d\n", pktInfSize, pktMaxSize)
+		os.Exit(1)
+	}
+	if pps < 0 {
+		fmt.Fprintln(os.Stderr, "pps should be positive")
+		os.Exit(1)
+	}
+	if ttlSweepMax > 255 || ttlBurst < 1 || ttlSweepMax*ttlBurst > pktMaxCount {
+		fmt.Fprintf(os.Stderr, "ttl-sweep should be at most 255, ttl-burst positive and their product at most %d\n", pktMaxCount)
+		os.Exit(1)
+	}
+	if pps > 0 {
+		sendInterval = time.Duration(float64(time.Second) / pps)
+	}
+	if len(flowLabels) > 1 {
+		sendWorkers = len(flowLabels)
+	}
+	if sprayPorts > 0 {
+		sendWorkers = sprayPorts
+	}
+	if len(dscps) > 1 {
+		if len(flowLabels) > 1 || sprayPorts > 0 {
+			fmt.Fprintln(os.Stderr, "dscp lists can not be combined with flowlabel lists or spray-ports")
+			os.Exit(1)
+		}
+		sendWorkers = len(dscps)
+	}
+	if queueLen > 0 {
+		if !service {
+			fmt.Fprintln(os.Stderr, "queue needs -service")
+			os.Exit(1)
+		}
+		waiting = &startQueue{max: queueLen}
+	}
+	if quota.bytes > 0 && !service {
+		fmt.Fprintln(os.Stderr, "quota needs -service")
+		os.Exit(1)
+	}
+	if sessionDir != "" {
+		if !service || sessionKeep < 1 {
+			fmt.Fprintln(os.Stderr, "session-dir needs -service and a positive -session-keep")
+			os.Exit(1)
+		}
+		ep(os.MkdirAll(sessionDir, 0o755))
+		slog = &sessionLog{dir: sessionDir}
+	}
+	if sendWorkers < 1 {
+		fmt.Fprintln(os.Stderr, "send-workers should be positive")
+		os.Exit(1)
+	}
 	if addr == "" {
 		fmt.Fprintln(os.Stderr, "address not specified (use -h for info)")
 		os.Exit(1)
 	}
+	setupFailover()
+	if err := setupProfile(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if replayPath != "" {
+		if ramp.segs != nil {
+			fmt.Fprintln(os.Stderr, "replay can not be combined with ramp")
+			os.Exit(1)
+		}
+		if err := loadReplay(replayPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if n := ramp.largest(); n > 0 {
+			pktSize = n
+		}
+	}
+	if ramp.segs != nil {
+		pktCount = ramp.packets()
+		if pktCount < 1 || pktCount > pktMaxCount {
+			fmt.Fprintf(os.Stderr, "%s sends %d packets, it should be between 1 and %d\n", ramp.kind(), pktCount, pktMaxCount)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: %d packets, run the server with -cnt %d -p %d\n", ramp.kind(), pktCount, pktCount, pktSize)
+	}
+	if adaptive && (sendInterval <= 0 || targetLoss <= 0) {
+		fmt.Fprintln(os.Stderr, "adaptive needs a send interval and a positive target-loss")
+		os.Exit(1)
+	}
+	if autoTune > 0 && sendRate() == 0 {
+		fmt.Fprintln(os.Stderr, "auto-tune needs a rate to size the buffer for, -i above 0 or -shape")
+		os.Exit(1)
+	}
+	if encap.kind != "" && (fragSize > 0 || zeroCsum || gsoSegs > 1 || unsafeRaw.on || hwTxStamps || echo) {
+		// replies to tunneled packets would go to the tunnel port
+		fmt.Fprintln(os.Stderr, "encap needs -gso 1 and can not be combined with -frag, -zero-csum, -unsafe-raw, -hw-tx-ts or -echo")
+		os.Exit(1)
+	}
+	if zeroCsum && (fragSize > 0 || !isServer && !unsafeRaw.on) {
+		fmt.Fprintln(os.Stderr, "zero-csum needs -unsafe-raw on the client and can not be combined with -frag")
+		os.Exit(1)
+	}
+	if unsafeRaw.on && (gsoSegs > 1 || ioBackend != "std" || hwTxStamps || len(flowLabels) > 0) {
+		fmt.Fprintln(os.Stderr, "unsafe-raw can not be combined with -gso, -io uring, -hw-tx-ts or -flowlabel")
+		os.Exit(1)
+	}
+	if hwTxStamps && (!echo || sendWorkers > 1 || gsoSegs > 1 || ioBackend != "std") {
+		fmt.Fprintln(os.Stderr, "hw-tx-ts needs -echo, one send worker, no -gso and -io std")
+		os.Exit(1)
+	}
+	if owdStamps {
+		if !echo {
+			fmt.Fprintln(os.Stderr, "owd needs -echo")
+			os.Exit(1)
+		}
+		if size := replySize; size == 0 && pktSize < pktInfSize+owdStampSize || size != 0 && size < pktInfSize+owdStampSize {
+			fmt.Fprintf(os.Stderr, "owd needs replies of at least %d bytes\n", pktInfSize+owdStampSize)
+			os.Exit(1)
+		}
+	}
+	if (requireSync || resyncEvery > 0 || owdStamps) && syncProbes == 0 {
+		syncProbes = 8
+	}
+	if mqttURL != "" {
+		mqtt = dialMQTT(mqttURL)
+		defer mqtt.close()
+	}
+	if pprofAddr != "" {
+		go func() {
+			ep(http.ListenAndServe(pprofAddr, nil))
+		}()
+	}
+	if responder {
+		respond()
+		return
+	}
+	if isServer {
+		trc = openTrace(tracePath, "receive")
+	} else {
+		trc = openTrace(tracePath, "send")
+	}
+	defer trc.close()
+	if isServer {
+		setupSinks()
+	}
+	if isServer && service && !runService(serveForever) {
+		serveForever()
+	}
 	if isServer {
 		serve()
 		return
 	}
+	target = addr
+	if !dualStack {
+		pickAddr()
+	}
+	if ttlSweepMax > 0 {
+		ttlSweep()
+		return
+	}
+	if probeEvery > 0 {
+		probe()
+	}
+	if dualStack {
+		dual()
+		return
+	}
 	upload()
 }
 
 func serve() {
-	con, err := net.ListenPacket("udp", addr)
-	ep(err)
+	pinLoop(0)
+	con := listen()
 	defer con.Close()
+	session(con, nil)
+}
+
+func listen() net.PacketConn {
+	lc := net.ListenConfig{Control: listenControl}
+	con, err := lc.ListenPacket(context.Background(), "udp", addr)
+	ep(err)
+	if uc, ok := con.(*net.UDPConn); ok && autoTune > 0 {
+		tuneBuffer(uc)
+	}
+	startHealth(con.LocalAddr())
+	announce(con.LocalAddr(), "server")
+	startAdvertise(con.LocalAddr())
+	if (ioBackend == "uring" || xdpIface != "" || busyPoll > 0 || encap.kind != "") && rxQueue == 0 {
+		rxQueue = 1024
+	}
+	if rxQueue > 0 {
+		return newQueuedConn(con, rxQueue)
+	}
+	return con
+}
+
+// session runs a single test on con. The start command is awaited
+// unless it is given. A start command from the next client arriving
+// during the grace period is returned.
+func session(con net.PacketConn, cmd *startCmd) (next *startCmd) {
 	var (
-		no  uint16
-		pkt paket
-		s   store
-		i   int
+		dg    diagnostics
+		no    uint16
+		maxNo uint16
+		pkt   paket
+		s     store
+		i     int
+		paths = pathTable{}
+		reply []byte
+		t0    time.Time
+		last  time.Time
+		jit   jitter
+		seen  = make([]bool, pktMaxCount+1)
+		dups  int
+		late  int
+		other int
+		hops  hopTracker
+		an    anomalyTracker
+		order orderLog
+		outs  outages
+		fo    failoverLog
+		marks []mark
+		frags fragCounts
+		drops *dropWatch
+	)
+	// pausedAt is set while the client paused sending
+	var (
+		pausedAt  time.Time
+		pausedFor time.Duration
+		markers   []marker
+		// volume is what the session received and sent back
+		volume int64
+		// charged is the part of volume already charged to the client
+		charged int64
+		meter   rateMeter
 	)
+	if hopReport || pathKeys.needCmsg() || anyAddress() {
+		pkt.oob = make([]byte, 128)
+	}
+	sdNotify("READY=1")
+	if cmd == nil {
+		cmd = awaitStart(con)
+		if cmd == nil {
+			return nil
+		}
+	} else if refuse(con, cmd) {
+		// admitted before the last session used up its quota
+		return nil
+	}
+	client := cmd.from
+	testID = cmd.id
+	fmt.Println("received start command")
+	if testID != "" {
+		fmt.Printf("test id: %s\n", testID)
+	}
+	if envReport {
+		runEnv = collectEnv(client)
+		fmt.Println(runEnv)
+	}
+	if cmd.file != nil {
+		// the file decides the count of this session only
+		defer func(count int, mem bool) {
+			pktCount, useMem = count, mem
+		}(pktCount, useMem)
+		pktCount, useMem = cmd.file.chunks(), true
+		s.file = true
+		fmt.Printf("receiving file: %d bytes in %d packets\n", cmd.file.size, pktCount)
+	}
+	emit("start", map[string]interface{}{"client": client.String()})
+	// tells the client how it is seen, behind a nat it differs
+	peerVersion = agreeVersion(cmd.version)
+	if cmd.version > 0 {
+		ep(replyTo(con, ctlPacket("seen", client, "version", peerVersion), client, cmd.local))
+	} else {
+		ep(replyTo(con, ctlPacket("seen", client), client, cmd.local))
+	}
+	atomic.AddInt64(&sessions, 1)
+	if downstream {
+		rampDown(con, client, cmd.local)
+		return nil
+	}
 	defer func() {
+		var kd *kernelDrops
+		if drops != nil {
+			kd = drops.stop()
+		}
+		order.flush()
 		fmt.Printf("total packets received: %d\n", i)
+		if order.total > 0 {
+			fmt.Printf("wrong packet order: %d\n", order.total)
+		}
+		if len(paths) > 1 {
+			fmt.Printf("paths: %d\n", len(paths))
+			for _, p := range paths.stats(pktCount, pathKeys.bySocket()) {
+				fmt.Println(p)
+			}
+		}
 		if i != pktCount {
 			fmt.Printf("packet loss: %d (%.2f%%)\n",
 				pktCount-i, float64(pktCount-i)/float64(pktCount)*100)
 		}
+		if len(s.dupes) > 0 {
+			fmt.Printf("duplicate payloads: %d (first: packets %d and %d)\n",
+				len(s.dupes), s.dupes[0][0], s.dupes[0][1])
+		}
+		if splitParity() {
+			for _, p := range frags.stats(pktCount) {
+				fmt.Println(p)
+			}
+		}
+		if dups > 0 || late > 0 {
+			fmt.Printf("duplicates: %d, late: %d\n", dups, late)
+		}
+		if echo {
+			fmt.Printf("echo replies sent: %d\n", i)
+		}
+		if other > 0 {
+			fmt.Printf("packets of other hosts not counted: %d\n", other)
+		}
+		fmt.Printf("jitter: %v\n", jit.mean())
+		if hops.n > 0 {
+			fmt.Println(&hops)
+		}
+		if kd != nil {
+			fmt.Println(kd)
+		}
+		r := newResult("server", t0)
+		r.Anomalies = an.finish(seen)
+		if s := an.summary(); s != "" {
+			fmt.Println(s)
+		}
+		fmt.Println(dg)
+		r.Received = i
+		setLoss(r, pktCount, i)
+		setThroughput(r, i)
+		setJitter(r, jit.mean())
+		r.Duplicates = dups
+		r.Late = late
+		r.DuplicatePayloads = len(s.dupes)
+		r.Lost = lostRanges(seen, pktCount)
+		r.Segments = segments(marks, seen, pktCount)
+		r.PausedMs = ms(pausedFor)
+		r.Markers = markers
+		if service || quota.bytes > 0 {
+			served.report(client, volume, volume-charged)
+		}
+		for _, s := range r.Segments {
+			fmt.Println(s)
+		}
+		if video.fps > 0 {
+			r.Video = video.stats(seen)
+			fmt.Println(r.Video)
+		}
+		if r.Outage = outs.stats(); r.Outage != nil {
+			fmt.Println(r.Outage)
+		}
+		if failover {
+			if r.Failover = fo.result(pktCount); r.Failover != nil {
+				fmt.Println(r.Failover)
+			}
+		}
+		hops.fill(r)
+		r.KernelDrops = kd
+		if len(paths) > 1 {
+			r.Paths = paths.stats(pktCount, pathKeys.bySocket())
+		}
+		if splitParity() {
+			r.Paths = append(r.Paths, frags.stats(pktCount)...)
+		}
+		writeLost(lostPath, r.Lost)
+		publish(r)
 	}()
-	fmt.Println("waiting for incoming connection")
-	buf := make([]byte, len(start))
-	con.SetReadDeadline(time.Time{})
-	_, _, err = con.ReadFrom(buf)
-	ep(err)
-	if !bytes.Equal(buf, start) {
-		panic(fmt.Sprintf("unexpected first bytes: %s\n", string(buf)))
+	if watchDrops {
+		var err error
+		if drops, err = startDropWatch(); err != nil {
+			fmt.Fprintln(os.Stderr, "kernel drop attribution unavailable:", err)
+		}
 	}
-	fmt.Println("received start command")
-	for i = 0; i < pktCount; i++ {
-		err := pkt.readFrom(con)
-		if err != nil {
-			return
+	if !echo && peerVersion >= 3 {
+		defer startHeartbeat(con, client, cmd.local)()
+	}
+	dg = startDiagnostics()
+	t0 = time.Now()
+	outs.t0 = t0
+	nextStat := t0
+	for i < pktCount {
+		err := pkt.readFrom(con, time.Now().Add(idleTimeout))
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		}
+		var perr packetError
+		if errors.As(err, &perr) && refuseStart(con, &pkt, client, t0, i) {
+			continue
+		}
+		ep(err)
+		if !sameHost(pkt.from, client) {
+			other++
+			continue
+		}
+		if pkt.isCtl() {
+			switch cmd, args := pkt.ctl(); cmd {
+			case "stop":
+				fmt.Println("client stopped the test")
+				return nil
+			case "mark":
+				if m, ok := newMarker(marks, args, t0); ok {
+					fmt.Println(m)
+					trc.comment(fmt.Sprintf("marker %d %s", m.First, m.Label))
+					emit("marker", map[string]interface{}{"label": m.Label, "first": m.First, "offset_s": m.Offset})
+					markers = append(markers, m)
+				}
+				marks = addMark(marks, args)
+			case "pause":
+				if pausedAt.IsZero() {
+					pausedAt = pkt.at
+					fmt.Printf("client paused at +%.3fs\n", pkt.at.Sub(t0).Seconds())
+					emit("pause", nil)
+				}
+			case "resume":
+				if !pausedAt.IsZero() {
+					pausedFor += resumeAt(pkt.at, pausedAt, &last, &an, &outs)
+					pausedAt = time.Time{}
+				}
+			}
+			continue
+		}
+		if !pausedAt.IsZero() {
+			// the resume was lost
+			pausedFor += resumeAt(pkt.at, pausedAt, &last, &an, &outs)
+			pausedAt = time.Time{}
+		}
+		if seen[pkt.no] {
+			dups++
+			continue
+		}
+		seen[pkt.no] = true
+		i++
+		volume += int64(len(pkt.raw))
+		now := pkt.at
+		sinks.packet(&pkt)
+		an.add(&pkt)
+		hops.add(pkt.ttl, pkt.no)
+		if !last.IsZero() {
+			jit.add(now.Sub(last))
+		}
+		last = now
+		outs.add(now)
+		if failover {
+			fo.add(pkt.no, now)
+		}
+		replied := 0
+		if echo {
+			reply = pkt.reflect(con, reply)
+			replied = len(reply)
+			volume += int64(replied)
+		}
+		if maxRate > 0 {
+			if why := meter.add(now, len(pkt.raw), replied); why != "" {
+				endSession(con, client, pkt.dst, why)
+				break
+			}
+		}
+		if quota.bytes > 0 && volume-charged >= quotaStep {
+			served.charge(client, volume-charged)
+			charged = volume
+			if why := quotaUsed(client); why != "" {
+				endSession(con, client, pkt.dst, why)
+				break
+			}
 		}
 		if no >= pkt.no {
-			fmt.Printf("wrong packet order: prev no: %d, cur no: %d\n", no, pkt.no)
+			order.add(no, pkt.no, now)
 		}
 		no = pkt.no
+		if no > maxNo {
+			maxNo = no
+		}
+		if now.After(nextStat) {
+			ep(replyTo(con, ctlPacket("stat", i, maxNo), pkt.from, pkt.dst))
+			nextStat = now.Add(statInterval)
+			sinks.interval(i, maxNo)
+		}
+		paths.add(pathKeys.key(&pkt), pkt.no)
+		frags.add(pkt.no)
 		s.save(&pkt)
 	}
-	fmt.Println(s.checkSum())
+	// Count what still arrives shortly after the test instead of
+	// leaving it to the next one.
+	for end := time.Now().Add(grace); ; {
+		err := pkt.readFrom(con, end)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		}
+		var perr packetError
+		if errors.As(err, &perr) {
+			if next = parseStart(pkt.raw, pkt.from); next != nil {
+				next.local = pkt.dst
+				if !admit(con, next, len(pkt.raw)) {
+					next = nil
+					continue
+				}
+				if waiting.len() == 0 {
+					break
+				}
+				// others were first
+				answerStart(con, next, time.Until(end), time.Since(t0))
+				next = nil
+				continue
+			}
+		}
+		ep(err)
+		if pkt.isCtl() {
+			continue
+		}
+		if seen[pkt.no] {
+			dups++
+		} else {
+			seen[pkt.no] = true
+			late++
+		}
+	}
+	if i == pktCount {
+		fmt.Println(s.checkSum())
+	}
+	if cmd.file != nil {
+		verifyFile(cmd.file, &s, filePath)
+	}
+	return next
 }
 
-func upload() {
-	con, err := net.Dial("udp", addr)
-	ep(err)
-	defer con.Close()
-	h := md5.New()
+// resumeAt ends a pause of the client from from at to: the gap goes
+// into neither jitter, anomalies nor outages. It returns the pause.
+func resumeAt(to, from time.Time, last *time.Time, an *anomalyTracker, outs *outages) time.Duration {
+	d := to.Sub(from)
+	if !last.IsZero() {
+		*last = last.Add(d)
+	}
+	an.resume(d)
+	outs.resume(from, to)
+	fmt.Printf("client resumed after %v\n", d.Round(time.Millisecond))
+	emit("resume", map[string]interface{}{"paused_ms": ms(d)})
+	return d
+}
+
+// awaitStart answers clock probes until a start command arrives and
+// returns it, or nil if -setup-timeout expires.
+func awaitStart(con net.PacketConn) *startCmd {
+	fmt.Println("waiting for incoming connection")
 	var pkt paket
-	bb := make([]byte, pktSize-pktInfSize)
-	_, err = con.Write(start)
-	ep(err)
-	var i int
-	defer func() {
-		fmt.Printf("total packets sent: %d\n", i)
-	}()
-	ticker := time.NewTicker(sendInterval)
-	defer ticker.Stop()
-	for i = 0; i < pktCount; i++ {
-		<-ticker.C
-		_, err := rand.Read(bb)
+	pkt.buf = make([]byte, 256)
+	pkt.oob = make([]byte, 128)
+	if setupTimeout > 0 {
+		con.SetReadDeadline(time.Now().Add(setupTimeout))
+	} else {
+		con.SetReadDeadline(time.Time{})
+	}
+	for {
+		pkt.reset()
+		n, from, err := pkt.readMsg(con)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			fmt.Println("no start command received")
+			return nil
+		}
+		ep(err)
+		buf := pkt.buf
+		if cmd := parseStart(buf[:n], from); cmd != nil {
+			cmd.local = pkt.dst
+			if !admit(con, cmd, n) {
+				continue
+			}
+			return cmd
+		}
+		if replySync(con, buf[:n], from, pkt.dst, time.Now()) ||
+			answerQuery(con, buf[:n], from, pkt.dst, false) || service {
+			// leftovers of a previous session are expected
+			// by a long running server
+			continue
+		}
+		panic(fmt.Sprintf("unexpected first bytes: %s\n", string(buf[:n])))
+	}
+}
+
+// upload runs the test and returns its result, nil for -downstream.
+func upload() *result {
+	var file *fileInfo
+	if filePath != "" {
+		file = loadFile(filePath)
+	}
+	d := net.Dialer{Control: dialControl}
+	cons := make([]net.Conn, sendWorkers)
+	for w := range cons {
+		con, err := d.Dial("udp", addr)
 		ep(err)
-		_, err = h.Write(bb)
+		defer con.Close()
+		cons[w] = con
+		if uc, ok := con.(*net.UDPConn); ok && autoTune > 0 && echo {
+			// for the replies
+			tuneBuffer(uc)
+		}
+		if len(dscps) > 0 {
+			ep(setTOS(con, dscps[w%len(dscps)]<<2))
+		}
+		if len(flowLabels) > 0 {
+			cons[w], err = withFlowLabel(con, flowLabels[w%len(flowLabels)])
+			ep(err)
+		} else if ioBackend == "uring" {
+			if uc, err := withUring(con); err != nil {
+				fmt.Fprintln(os.Stderr, "io_uring unavailable, using standard writes:", err)
+				ioBackend = "std"
+			} else {
+				defer uc.Close()
+				cons[w] = uc
+			}
+		}
+	}
+	var offset, offsetRTT time.Duration
+	if syncProbes > 0 {
+		var err error
+		offset, offsetRTT, err = estimateOffset(cons[0], syncProbes)
 		ep(err)
-		pkt.apply(bb)
-		pkt.writeTo(con)
+		syncOffset = offset
+		fmt.Printf("clock offset: %v (rtt %v)\n", offset, offsetRTT)
+		checkOffset(offset)
+		trc.comment(fmt.Sprintf("offset %d at %d", offset, time.Now().UnixNano()))
+	}
+	if fragSize == 0 {
+		checkMTU(cons[0])
+	}
+	if envReport {
+		runEnv = collectEnv(cons[0].RemoteAddr())
+		fmt.Println(runEnv)
+	}
+	testID = newTestID()
+	fmt.Printf("test id: %s\n", testID)
+	startTest(cons[0], startPacket(testID, file, sendRate()))
+	downgrade()
+	if unsafeRaw.on {
+		for w, con := range cons {
+			rc, err := withRaw(con)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "unsafe-raw:", err)
+				os.Exit(1)
+			}
+			cons[w] = rc
+		}
+		if unsafeRaw.src != nil {
+			fmt.Fprintf(os.Stderr, "unsafe-raw: sending from %v, replies and server reports go there\n", unsafeRaw.src)
+		}
+	}
+	if encap.kind != "" {
+		for w, con := range cons {
+			ec, err := withEncap(con)
+			ep(err)
+			defer ec.Close()
+			cons[w] = ec
+		}
+	}
+	emit("start", map[string]interface{}{"server": addr})
+	if downstream {
+		rampReceive(cons[0])
+		return nil
+	}
+	dg := startDiagnostics()
+	var (
+		wg     sync.WaitGroup
+		sent   = make([]int, len(cons))
+		sums   = make([]hash.Hash, len(cons))
+		pacers = make([]*pacer, len(cons))
+		batch  = sendInterval * time.Duration(gsoSegs)
+		t0     = time.Now()
+		rwg    sync.WaitGroup
+	)
+	if echo {
+		echoes = newEchoStats()
+		if len(flowLabels) > 1 || sprayPorts > 1 || len(dscps) > 1 {
+			names := make([]string, len(cons))
+			for w, con := range cons {
+				names[w] = con.LocalAddr().String() + " > " + addr
+				if len(flowLabels) > 1 {
+					names[w] += fmt.Sprintf(" flowlabel %#x", flowLabels[w%len(flowLabels)])
+				}
+				if len(dscps) > 1 {
+					names[w] += " dscp " + dscpName(dscps[w])
+				}
+			}
+			echoes.splitPaths(names)
+		}
+		for w := range cons {
+			rwg.Add(1)
+			go func(w int) {
+				defer rwg.Done()
+				readBack(cons[w].(net.PacketConn), (pktCount-w-1)/len(cons)+1)
+			}(w)
+		}
+	}
+	if hwTxStamps {
+		var err error
+		if txts, err = startTxStamps(cons[0]); err != nil {
+			fmt.Fprintln(os.Stderr, "hardware tx timestamps unavailable, using send times:", err)
+			txts = nil
+		}
+	}
+	if adaptive {
+		adapt = startAdaptive(t0)
+	}
+	if abortLoss > 0 || adaptive {
+		if abortLoss > 0 {
+			monitor = startLossMonitor()
+		}
+		if !echo {
+			for w := range cons {
+				go readBack(cons[w].(net.PacketConn), 0)
+			}
+		}
+	}
+	stopKeepalive := make(chan struct{})
+	if keepalive > 0 {
+		go keepAlive(cons, keepalive, stopKeepalive)
+	}
+	if resyncEvery > 0 {
+		startResync(cons[0], offset, offsetRTT, stopKeepalive)
+		if !echo && abortLoss == 0 && !adaptive && peerVersion < 3 {
+			go readBack(cons[0].(net.PacketConn), 0)
+		}
+	}
+	rateT0 = t0
+	if shape.rate > 0 {
+		shape.start()
+	}
+	if ramp.segs != nil {
+		go ramp.run(t0, cons[0])
+	}
+	ctlCon = cons[0]
+	startControl()
+	stopKeys := func() {}
+	if interactive {
+		stopKeys = readKeys(testKey)
+	}
+	if !echo && peerVersion >= 3 {
+		beats = &heartbeats{t0: time.Now()}
+		go beats.watch(stopKeepalive)
+		if abortLoss == 0 && !adaptive {
+			go readBack(cons[0].(net.PacketConn), 0)
+		}
 	}
+	for w := range cons {
+		sums[w] = md5.New()
+		pacers[w] = newPacer(t0.Add(batch*time.Duration(w+1)), batch*time.Duration(len(cons)))
+		if ramp.segs != nil {
+			first, step := w, len(cons)
+			pacers[w].start = t0
+			pacers[w].sched = func(n int64) time.Duration { return ramp.timeOf((first + int(n)*step) * gsoSegs) }
+		}
+		if video.fps > 0 {
+			first, step := w, len(cons)
+			pacers[w].start = t0
+			pacers[w].sched = func(n int64) time.Duration { return video.timeOf((first + int(n)*step) * gsoSegs) }
+		}
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			pinLoop(w)
+			sent[w] = send(cons[w], sums[w], pacers[w], w+1, len(cons))
+		}(w)
+	}
+	wg.Wait()
+	if txts != nil {
+		txts.close()
+	}
+	stopKeys()
+	close(stopKeepalive)
+	if isAborted() {
+		_, _ = cons[0].Write(ctlPacket("stop"))
+	}
+	rwg.Wait()
+	if echoes != nil {
+		echoes.finishPaths(sent)
+	}
+	var total int
+	for w, n := range sent {
+		total += n
+		if w > 0 {
+			pacers[0].merge(pacers[w])
+		}
+	}
+	fmt.Printf("total packets sent: %d\n", total)
+	fmt.Println(pacers[0])
+	fmt.Println(rateString(sendInterval, pacers[0].achieved()/time.Duration(gsoSegs)))
+	if shape.rate > 0 {
+		fmt.Println(shape.summary())
+	}
+	if txts != nil {
+		fmt.Println(txts)
+	}
+	if echoes != nil {
+		fmt.Println(echoes)
+		if total > 0 && len(echoes.rtts) == 0 {
+			diagnoseNoReplies()
+		}
+		if gameHz > 0 {
+			fmt.Println(echoes.game(total))
+		}
+	}
+	if adapt != nil {
+		fmt.Println(adapt.result())
+	}
+	if s := beats.String(); s != "" {
+		fmt.Println(s)
+	}
+	res := newResult("client", t0)
+	if res.ClockDrift = drift.stats(); res.ClockDrift != nil {
+		fmt.Println(res.ClockDrift)
+	}
+	fmt.Println(dg)
+	if len(cons) == 1 {
+		fmt.Printf("%x\n", sums[0].Sum(nil))
+	}
+	res.Sent = total
+	res.RateChanges = rateChanges
+	res.PausedMs = ms(pausedFor)
+	res.Markers = markers
+	res.Adaptive = adapt.result()
+	setThroughput(res, total)
+	if echoes != nil {
+		echoes.fill(res)
+	}
+	if gameHz > 0 {
+		res.Game = echoes.game(total)
+	}
+	publish(res)
+	return res
+}
 
-	fmt.Printf("%x\n", h.Sum(nil))
+// send writes every step-th packet starting from sequence number first.
+func send(con net.Conn, h hash.Hash, pc *pacer, first, step int) int {
+	var (
+		pkt paket
+		i   int
+	)
+	src := newPayloadSource(first, step)
+	bb := make([]byte, datagramSize()-pktInfSize)
+	batch := make([]byte, 0, pktSize*gsoSegs)
+	for no := first; no <= pktCount && !isAborted(); {
+		pc.wait()
+		batch = batch[:0]
+		for n := 0; n < gsoSegs && no <= pktCount; n++ {
+			pl := bb[:payloadSize(uint16(no))]
+			ep(src.Next(pl))
+			if len(pl) < pktSize-pktInfSize {
+				// the server stores smaller -replay payloads zero padded
+				clear := pl[len(pl) : pktSize-pktInfSize]
+				for i := range clear {
+					clear[i] = 0
+				}
+			}
+			// the checksum covers what the server stores
+			_, err := h.Write(pl[:pktSize-pktInfSize])
+			ep(err)
+			pkt.apply(uint16(no), pl)
+			batch = append(batch, pkt.raw...)
+			no += step
+			i++
+		}
+		shape.take(len(batch))
+		now := time.Now()
+		if echoes != nil {
+			// before the write, a reply may be read before it returns
+			eachNo(batch, func(no uint16) { echoes.markSent(no, now) })
+		}
+		var err error
+		if txts != nil {
+			_, err = txts.write(batch)
+		} else {
+			_, err = con.Write(batch)
+		}
+		atomic.StoreInt64(&sentNo, int64(no-step))
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			abort("connection refused by server")
+			break
+		}
+		if isMsgSize(err) {
+			abort(msgSizeReason(con))
+			break
+		}
+		ep(err)
+		if trc != nil {
+			eachNo(batch, func(no uint16) { trc.record(no, now) })
+		}
+	}
+	return i
 }
 
-type store []byte
+// eachNo calls f with the number of every packet in batch.
+func eachNo(batch []byte, f func(no uint16)) {
+	for k := 0; k < len(batch); k += pktInfSize + int(binary.LittleEndian.Uint16(batch[k+pktNoSize:])) {
+		f(binary.LittleEndian.Uint16(batch[k:]))
+	}
+}
+
+// store keeps received payloads in packet order. Every slot is hashed
+// on first write so that the same payload showing up in another slot,
+// which means corrupted packet numbers, can be reported.
+type store struct {
+	data  []byte
+	used  []bool
+	sums  map[uint64]uint16
+	dupes [][2]uint16
+	// file chunks may repeat, they are not checked for duplicates
+	file bool
+}
 
 func (s *store) save(p *paket) {
 	if !useMem {
 		return
 	}
-	if *s == nil {
-		(*s) = make([]byte, (pktSize-pktInfSize)*pktCount)
+	plSize := pktSize - pktInfSize
+	if s.data == nil {
+		s.data = make([]byte, plSize*pktCount)
+		s.used = make([]bool, pktCount+1)
+		s.sums = make(map[uint64]uint16)
 	}
-
-	copy((*s)[int(p.no-1)*(pktSize-pktInfSize):], p.data)
+	if int(p.no) > pktCount || s.used[p.no] {
+		return
+	}
+	data := p.data
+	if len(data) > plSize {
+		// -frag packets are stored like the others
+		data = data[:plSize]
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	sum := h.Sum64()
+	// other payloads than random ones repeat by design
+	if other, ok := s.sums[sum]; ok && payloadMode == "random" && !s.file {
+		off := int(other-1) * plSize
+		if bytes.Equal(s.data[off:off+plSize], data) {
+			s.dupes = append(s.dupes, [2]uint16{other, p.no})
+		}
+	} else {
+		s.sums[sum] = p.no
+	}
+	s.used[p.no] = true
+	copy(s.data[int(p.no-1)*plSize:], data)
 }
 
-func (s store) checkSum() string {
+func (s *store) checkSum() string {
 	h := md5.New()
-	_, _ = h.Write(s)
+	_, _ = h.Write(s.data)
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
+// msgReader is a connection receiving control messages.
+type msgReader interface {
+	ReadMsgUDP(b, oob []byte) (n, oobn, flags int, addr *net.UDPAddr, err error)
+}
+
 type paket struct {
 	no   uint16
 	size uint16
 	data []byte
 	buf  []byte
+	raw  []byte
 	from net.Addr
+	// oob receives control messages if set, ttl, tos and flow
+	// label are taken from them
+	oob  []byte
+	ttl  int
+	tos  int
+	flow uint32
+	// at is when the packet was taken off the socket
+	at time.Time
+	// dst is the local address the packet was sent to, if known
+	dst net.IP
 }
 
 func (p *paket) reset() {
 	if p.buf == nil {
-		p.buf = make([]byte, pktSize)
+		p.buf = make([]byte, datagramSize())
 	}
 	p.no = 0
 	p.size = 0
 	p.from = nil
+	p.ttl = -1
+	p.tos = 0
+	p.flow = 0
+	p.dst = nil
 }
 
-func (p *paket) readFrom(con net.PacketConn) error {
+// readMsg reads a datagram into p.buf, with the control messages if
+// p.oob is set.
+func (p *paket) readMsg(con net.PacketConn) (n int, addr net.Addr, err error) {
+	atomic.StoreInt64(&busySince, 0)
+	defer func() { atomic.StoreInt64(&busySince, time.Now().UnixNano()) }()
+	uc, ok := con.(msgReader)
+	if !ok || p.oob == nil {
+		return con.ReadFrom(p.buf)
+	}
+	var oobn int
+	n, oobn, _, addr, err = uc.ReadMsgUDP(p.buf, p.oob)
+	if err == nil {
+		p.parseCmsg(p.oob[:oobn])
+	}
+	return n, addr, err
+}
+
+func (p *paket) readFrom(con net.PacketConn, deadline time.Time) error {
 	p.reset()
-	con.SetReadDeadline(time.Now().Add(rwTimeout))
-	n, addr, err := con.ReadFrom(p.buf)
-	if errors.Is(err, os.ErrDeadlineExceeded) {
+	con.SetReadDeadline(deadline)
+	n, addr, err := p.readMsg(con)
+	if err != nil {
 		return err
 	}
-	ep(err)
+	p.at = time.Now()
+	if q, ok := con.(*queuedConn); ok {
+		p.at = q.at
+	}
 
 	if p.from != nil && p.from.String() != addr.String() {
 		panic("remote address changed")
 	}
 
-	buf := p.buf[:n]
-	if len(buf) < pktInfSize {
-		panic("to few bytes received")
-	}
-
-	no := binary.LittleEndian.Uint16(buf[0:pktNoSize])
-	plSize := binary.LittleEndian.Uint16(buf[pktNoSize:pktHdrSize])
-	if len(buf)-pktInfSize != int(plSize) {
-		panic("expected and received packet size are not match")
-	}
-	if !bytes.Equal(buf[len(buf)-pktEndSize:], pktEnd) {
-		panic("unexpected packet end")
+	p.raw = p.buf[:n]
+	p.from = addr
+	no, data, err := Decode(p.raw)
+	if err != nil {
+		return err
 	}
-
-	p.data = buf[pktHdrSize : pktHdrSize+plSize]
+	p.data = data
 	p.no = no
-	p.size = plSize
-	p.from = addr
+	p.size = uint16(len(data))
 
 	return nil
 }
 
-func (p *paket) apply(b []byte) {
-	if p.no == 0 {
-		p.reset()
-	}
-	if len(b) > pktSize-pktInfSize {
-		panic("payload to long")
+// readValid is readFrom skipping malformed packets.
+func (p *paket) readValid(con net.PacketConn, deadline time.Time) error {
+	for {
+		err := p.readFrom(con, deadline)
+		var perr packetError
+		if !errors.As(err, &perr) {
+			return err
+		}
 	}
-	p.size = uint16(len(b))
-	p.no++
-	binary.LittleEndian.PutUint16(p.buf, p.no)
-	binary.LittleEndian.PutUint16(p.buf[pktNoSize:], p.size)
-	copy(p.buf[pktHdrSize:], b)
-	copy(p.buf[pktHdrSize+len(b):], pktEnd)
 }
 
-func (p *paket) writeTo(w io.Writer) {
-	_, err := w.Write(p.buf)
-	if err != nil {
-		panic(err)
+func (p *paket) apply(no uint16, b []byte) {
+	if p.buf == nil {
+		p.reset()
 	}
+	n, err := Encode(p.buf, no, b)
+	ep(err)
+	p.size = uint16(len(b))
+	p.no = no
+	p.raw = p.buf[:n]
 }
 
 func info() {
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	markerMu sync.Mutex
+	markers  []marker
+)
+
+// addMarker marks the next packet with label on the server, which
+// breaks its counts down there, and notes it in the client's output,
+// trace and events.
+func addMarker(label, via string) error {
+	label = strings.Join(strings.Fields(label), " ")
+	if label == "" {
+		return fmt.Errorf("a marker needs a label")
+	}
+	if ctlCon == nil {
+		return fmt.Errorf("no test running")
+	}
+	no := atomic.LoadInt64(&sentNo) + 1
+	sendMark(ctlCon, no, label)
+	m := marker{Label: label, First: int(no), Offset: time.Since(rateT0).Seconds()}
+	markerMu.Lock()
+	markers = append(markers, m)
+	markerMu.Unlock()
+	fmt.Printf("%s (%s)\n", m, via)
+	trc.comment(fmt.Sprintf("marker %d %s", no, label))
+	emit("marker", map[string]interface{}{"label": label, "first": no, "offset_s": m.Offset, "via": via})
+	return nil
+}
+
+// newMarker returns the marker a mark message from the client starts,
+// ok is false for the repeated message.
+func newMarker(marks []mark, args []string, t0 time.Time) (m marker, ok bool) {
+	if len(args) < 2 {
+		return m, false
+	}
+	no, err := strconv.Atoi(args[0])
+	if err != nil {
+		return m, false
+	}
+	for _, k := range marks {
+		if k.no == no {
+			return m, false
+		}
+	}
+	return marker{Label: strings.Join(args[1:], " "), First: no, Offset: time.Since(t0).Seconds()}, true
+}
+
+// markerLine collects the label typed after m in -interactive mode.
+type markerLine struct {
+	typing bool
+	b      []byte
+}
+
+// key handles k if a label is being typed, enter adds the marker and
+// escape drops it.
+func (l *markerLine) key(k byte) bool {
+	if !l.typing {
+		if k != 'm' {
+			return false
+		}
+		l.typing, l.b = true, l.b[:0]
+		fmt.Print("marker label: ")
+		return true
+	}
+	switch k {
+	case '\r', '\n':
+		l.typing = false
+		fmt.Println()
+		if err := addMarker(string(l.b), "key"); err != nil {
+			fmt.Println("marker:", err)
+		}
+	case 27:
+		l.typing = false
+		fmt.Println()
+	case 127, 8:
+		if len(l.b) > 0 {
+			l.b = l.b[:len(l.b)-1]
+			fmt.Print("\b \b")
+		}
+	default:
+		if k >= ' ' {
+			l.b = append(l.b, k)
+			fmt.Printf("%c", k)
+		}
+	}
+	return true
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mdnsService = "_udptest._udp.local."
+	mdnsTTL     = 120
+	// discoverWait is how long -discover collects answers.
+	discoverWait = time.Second
+)
+
+var (
+	mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	advertise bool
+	discover  bool
+)
+
+const (
+	dnsA   = 1
+	dnsPTR = 12
+	dnsTXT = 16
+	dnsSRV = 33
+	dnsANY = 255
+)
+
+// dnsRecord is a resource record with its rdata still encoded, target
+// is the expanded name ptr and srv records point to.
+type dnsRecord struct {
+	name   string
+	typ    uint16
+	data   []byte
+	target string
+}
+
+// startAdvertise publishes the server bound to local as
+// <hostname>._udptest._udp.local over multicast dns, answering ptr
+// queries for the service until the process ends.
+func startAdvertise(local net.Addr) {
+	if !advertise {
+		return
+	}
+	ua := local.(*net.UDPAddr)
+	host, err := os.Hostname()
+	ep(err)
+	host = strings.SplitN(host, ".", 2)[0]
+	ips := []net.IP{ua.IP.To4()}
+	if ua.IP.IsUnspecified() || ua.IP.To4() == nil {
+		ips = localIPv4s()
+	}
+	reply := mdnsAnswer(host, ua.Port, ips)
+	con, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "advertise:", err)
+		return
+	}
+	fmt.Printf("advertising %s.%s\n", host, mdnsService)
+	// unsolicited, so browsers already running see the server
+	con.WriteTo(reply, mdnsGroup)
+	go func() {
+		buf := make([]byte, 9000)
+		for {
+			n, from, err := con.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if !asksService(buf[:n]) {
+				continue
+			}
+			to := mdnsGroup
+			if from.Port != mdnsGroup.Port {
+				// a one-shot querier, answer it directly
+				to = from
+			}
+			con.WriteTo(reply, to)
+		}
+	}()
+}
+
+func localIPv4s() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	ep(err)
+	var ips, loop []net.IP
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+			if n.IP.IsLoopback() {
+				loop = append(loop, n.IP.To4())
+			} else {
+				ips = append(ips, n.IP.To4())
+			}
+		}
+	}
+	if len(ips) == 0 {
+		return loop
+	}
+	return ips
+}
+
+// mdnsAnswer is the response announcing the service instance host.
+func mdnsAnswer(host string, port int, ips []net.IP) []byte {
+	inst := host + "." + mdnsService
+	target := host + ".local."
+	b := []byte{0, 0, 0x84, 0, 0, 0, 0, 1, 0, 0, 0, byte(2 + len(ips))}
+	b = appendRecord(b, mdnsService, dnsPTR, 1, dnsName(nil, inst))
+	srv := []byte{0, 0, 0, 0, byte(port >> 8), byte(port)}
+	b = appendRecord(b, inst, dnsSRV, 0x8001, dnsName(srv, target))
+	txt := "version=" + strconv.Itoa(protoVersion)
+	b = appendRecord(b, inst, dnsTXT, 0x8001, append([]byte{byte(len(txt))}, txt...))
+	for _, ip := range ips {
+		b = appendRecord(b, target, dnsA, 0x8001, ip)
+	}
+	return b
+}
+
+func appendRecord(b []byte, name string, typ, class uint16, data []byte) []byte {
+	b = dnsName(b, name)
+	b = append(b, byte(typ>>8), byte(typ), byte(class>>8), byte(class), 0, 0, 0, mdnsTTL)
+	b = append(b, byte(len(data)>>8), byte(len(data)))
+	return append(b, data...)
+}
+
+// dnsName appends name, ending with a dot, uncompressed.
+func dnsName(b []byte, name string) []byte {
+	for _, l := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(l)))
+		b = append(b, l...)
+	}
+	return append(b, 0)
+}
+
+// readName expands the possibly compressed name at off in msg and
+// returns it and the offset after it.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errors.New("short name")
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errors.New("bad pointer")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errors.New("short label")
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
+
+// asksService reports whether msg is a query for the service.
+func asksService(msg []byte) bool {
+	if len(msg) < 12 || msg[2]&0x80 != 0 {
+		return false
+	}
+	off := 12
+	for q := int(binary.BigEndian.Uint16(msg[4:])); q > 0; q-- {
+		name, next, err := readName(msg, off)
+		if err != nil || next+4 > len(msg) {
+			return false
+		}
+		typ := binary.BigEndian.Uint16(msg[next:])
+		if strings.EqualFold(name, mdnsService) && (typ == dnsPTR || typ == dnsANY) {
+			return true
+		}
+		off = next + 4
+	}
+	return false
+}
+
+// parseRecords returns the answer, authority and additional records
+// of a response.
+func parseRecords(msg []byte) ([]dnsRecord, error) {
+	if len(msg) < 12 || msg[2]&0x80 == 0 {
+		return nil, errors.New("not a response")
+	}
+	off := 12
+	for q := int(binary.BigEndian.Uint16(msg[4:])); q > 0; q-- {
+		_, next, err := readName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next + 4
+	}
+	n := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+	var rs []dnsRecord
+	for ; n > 0; n-- {
+		name, next, err := readName(msg, off)
+		if err != nil || next+10 > len(msg) {
+			return rs, errors.New("short record")
+		}
+		r := dnsRecord{name: strings.ToLower(name), typ: binary.BigEndian.Uint16(msg[next:])}
+		l := int(binary.BigEndian.Uint16(msg[next+8:]))
+		off = next + 10
+		if off+l > len(msg) {
+			return rs, errors.New("short rdata")
+		}
+		r.data = msg[off : off+l]
+		switch r.typ {
+		case dnsPTR:
+			r.target, _, err = readName(msg, off)
+		case dnsSRV:
+			if l > 6 {
+				r.target, _, err = readName(msg, off+6)
+			}
+		}
+		if err != nil {
+			return rs, err
+		}
+		rs = append(rs, r)
+		off += l
+	}
+	return rs, nil
+}
+
+// reflector is a server found with -discover.
+type reflector struct {
+	name string
+	addr string
+}
+
+// discoverServers asks for the service with a one-shot multicast query
+// and collects the instances answering within discoverWait.
+func discoverServers() []reflector {
+	con, err := net.ListenUDP("udp4", nil)
+	ep(err)
+	defer con.Close()
+	q := []byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	q = append(dnsName(q, mdnsService), 0, dnsPTR, 0, 1)
+	_, err = con.WriteTo(q, mdnsGroup)
+	ep(err)
+	var (
+		found []reflector
+		seen  = map[string]bool{}
+		buf   = make([]byte, 9000)
+	)
+	con.SetReadDeadline(time.Now().Add(discoverWait))
+	for {
+		n, err := con.Read(buf)
+		if err != nil {
+			return found
+		}
+		rs, err := parseRecords(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, r := range rs {
+			if r.typ != dnsPTR || r.name != mdnsService || seen[r.target] {
+				continue
+			}
+			if a := instanceAddr(rs, strings.ToLower(r.target)); a != "" {
+				seen[r.target] = true
+				found = append(found, reflector{strings.TrimSuffix(r.target, "."+mdnsService), a})
+			}
+		}
+	}
+}
+
+// instanceAddr looks up the srv and a records of inst in rs.
+func instanceAddr(rs []dnsRecord, inst string) string {
+	for _, srv := range rs {
+		if srv.typ != dnsSRV || srv.name != inst || len(srv.data) < 6 {
+			continue
+		}
+		port := binary.BigEndian.Uint16(srv.data[4:])
+		for _, a := range rs {
+			if a.typ == dnsA && a.name == strings.ToLower(srv.target) && len(a.data) == 4 {
+				return net.JoinHostPort(net.IP(a.data).String(), strconv.Itoa(int(port)))
+			}
+		}
+	}
+	return ""
+}
+
+// discoverTarget lists the servers on the local network and returns
+// the address of the first.
+func discoverTarget() string {
+	found := discoverServers()
+	if len(found) == 0 {
+		fmt.Fprintln(os.Stderr, "discover: no servers advertising", mdnsService)
+		os.Exit(1)
+	}
+	for _, r := range found {
+		fmt.Printf("found %s at %s\n", r.name, r.addr)
+	}
+	return found[0].addr
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type meshPeer struct {
+	name string
+	addr string
+}
+
+// meshRows holds "loss rtt" results by "from to" peer names.
+type meshRows struct {
+	mu   sync.Mutex
+	rows map[[2]string][2]float64
+}
+
+func (m *meshRows) set(from, to string, loss, rtt float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rows[[2]string{from, to}] = [2]float64{loss, rtt}
+}
+
+func (m *meshRows) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.rows)
+}
+
+// mesh runs one agent of a full mesh test. Every agent reflects
+// packets of the others on its own address and measures an echo burst
+// to every other peer. Results are sent to the first peer of the list,
+// which prints the loss and rtt matrices.
+func mesh(args []string) {
+	fs := flag.NewFlagSet("mesh", flag.ExitOnError)
+	self := fs.String("self", "", "name of this agent in the peer list")
+	delay := fs.Duration("delay", 5*time.Second, "wait before measuring, for the other agents to start")
+	wait := fs.Duration("wait", 30*time.Second, "keep reflecting and collecting this long after measuring")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mesh -self name <peer list>")
+		fmt.Fprintln(os.Stderr, "peer list lines: <name> <host:port>, the first peer aggregates")
+		fs.PrintDefaults()
+	}
+	ep(fs.Parse(args))
+	if fs.NArg() != 1 || *self == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	peers := readPeers(fs.Arg(0))
+	var me *meshPeer
+	for i := range peers {
+		if peers[i].name == *self {
+			me = &peers[i]
+		}
+	}
+	if me == nil {
+		fmt.Fprintf(os.Stderr, "%s is not in the peer list\n", *self)
+		os.Exit(1)
+	}
+	collector := peers[0]
+	rows := &meshRows{rows: map[[2]string][2]float64{}}
+	con, err := net.ListenPacket("udp", me.addr)
+	ep(err)
+	defer con.Close()
+	go meshReflect(con, rows)
+
+	time.Sleep(*delay)
+	agg, err := net.ResolveUDPAddr("udp", collector.addr)
+	ep(err)
+	for _, p := range peers {
+		if p.name == me.name {
+			continue
+		}
+		loss, rtt := meshMeasure(p)
+		fmt.Printf("%s -> %s: loss %.2f%%, median rtt %v\n", me.name, p.name, loss, rtt)
+		row := ctlPacket("row", me.name, p.name, loss, ms(rtt))
+		for i := 0; i < rampRetries; i++ {
+			_, err := con.WriteTo(row, agg)
+			ep(err)
+		}
+	}
+
+	want := len(peers) * (len(peers) - 1)
+	for end := time.Now().Add(*wait); time.Now().Before(end); {
+		if me.name == collector.name && rows.len() >= want {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if me.name == collector.name {
+		printMatrix(peers, rows)
+	}
+}
+
+func meshMeasure(p meshPeer) (float64, time.Duration) {
+	d := net.Dialer{Control: dialControl}
+	con, err := d.Dial("udp", p.addr)
+	ep(err)
+	defer con.Close()
+	return burst(con)
+}
+
+// meshReflect echoes data packets and collects result rows.
+func meshReflect(con net.PacketConn, rows *meshRows) {
+	var (
+		pkt   paket
+		reply []byte
+	)
+	pkt.buf = make([]byte, pktMaxSize)
+	for {
+		err := pkt.readValid(con, time.Time{})
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		ep(err)
+		if !pkt.isCtl() {
+			reply = pkt.reflect(con, reply)
+			continue
+		}
+		cmd, args := pkt.ctl()
+		if cmd != "row" || len(args) != 4 {
+			continue
+		}
+		loss, err1 := strconv.ParseFloat(args[2], 64)
+		rtt, err2 := strconv.ParseFloat(args[3], 64)
+		if err1 == nil && err2 == nil {
+			rows.set(args[0], args[1], loss, rtt)
+		}
+	}
+}
+
+func readPeers(path string) []meshPeer {
+	f, err := os.Open(path)
+	ep(err)
+	defer f.Close()
+	var peers []meshPeer
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) != 2 {
+			panic(fmt.Sprintf("%s: malformed line: %q", path, line))
+		}
+		peers = append(peers, meshPeer{name: f[0], addr: f[1]})
+	}
+	ep(sc.Err())
+	if len(peers) < 2 {
+		panic(fmt.Sprintf("%s: at least two peers needed", path))
+	}
+	return peers
+}
+
+func printMatrix(peers []meshPeer, rows *meshRows) {
+	rows.mu.Lock()
+	defer rows.mu.Unlock()
+	for i, title := range []string{"loss %", "median rtt ms"} {
+		fmt.Printf("\n%-14s", title)
+		for _, to := range peers {
+			fmt.Printf(" %10s", to.name)
+		}
+		fmt.Println()
+		for _, from := range peers {
+			fmt.Printf("%-14s", from.name)
+			for _, to := range peers {
+				v, ok := rows.rows[[2]string{from.name, to.name}]
+				switch {
+				case from.name == to.name:
+					fmt.Printf(" %10s", "-")
+				case !ok:
+					fmt.Printf(" %10s", "?")
+				default:
+					fmt.Printf(" %10.2f", v[i])
+				}
+			}
+			fmt.Println()
+		}
+	}
+}
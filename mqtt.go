@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"time"
+)
+
+// mqttClient is a minimal MQTT 3.1.1 publisher: QoS 0 only, no
+// keepalive, reconnecting on the next publish after a failure.
+type mqttClient struct {
+	host  string
+	topic string
+	user  *url.Userinfo
+	con   net.Conn
+}
+
+// dialMQTT connects to a broker given as mqtt://[user:pass@]host[:port][/topic].
+func dialMQTT(raw string) *mqttClient {
+	u, err := url.Parse(raw)
+	ep(err)
+	if u.Scheme != "mqtt" && u.Scheme != "tcp" {
+		fmt.Fprintf(os.Stderr, "unsupported mqtt url scheme: %s\n", u.Scheme)
+		os.Exit(1)
+	}
+	c := &mqttClient{host: u.Host, topic: "udptest", user: u.User}
+	if u.Port() == "" {
+		c.host = net.JoinHostPort(u.Hostname(), "1883")
+	}
+	if len(u.Path) > 1 {
+		c.topic = u.Path[1:]
+	}
+	ep(c.connect())
+	return c
+}
+
+func (c *mqttClient) connect() error {
+	con, err := net.DialTimeout("tcp", c.host, idleTimeout)
+	if err != nil {
+		return err
+	}
+	flags := byte(0x02) // clean session
+	var payload []byte
+	payload = mqttString(payload, fmt.Sprintf("udptest-%d", os.Getpid()))
+	if c.user != nil {
+		flags |= 0x80
+		payload = mqttString(payload, c.user.Username())
+		if pass, ok := c.user.Password(); ok {
+			flags |= 0x40
+			payload = mqttString(payload, pass)
+		}
+	}
+	vh := mqttString(nil, "MQTT")
+	vh = append(vh, 4, flags, 0, 0) // level 3.1.1, flags, no keepalive
+	if _, err := con.Write(mqttPacket(0x10, append(vh, payload...))); err != nil {
+		con.Close()
+		return err
+	}
+	ack := make([]byte, 4)
+	con.SetReadDeadline(time.Now().Add(idleTimeout))
+	if _, err := io.ReadFull(bufio.NewReader(con), ack); err != nil {
+		con.Close()
+		return err
+	}
+	con.SetReadDeadline(time.Time{})
+	if ack[0] != 0x20 || ack[3] != 0 {
+		con.Close()
+		return fmt.Errorf("mqtt connection refused, code %d", ack[3])
+	}
+	c.con = con
+	return nil
+}
+
+func (c *mqttClient) publish(subtopic string, payload []byte) error {
+	if c.con == nil {
+		if err := c.connect(); err != nil {
+			return err
+		}
+	}
+	b := mqttString(nil, c.topic+"/"+subtopic)
+	if _, err := c.con.Write(mqttPacket(0x30, append(b, payload...))); err != nil {
+		c.con.Close()
+		c.con = nil
+		return err
+	}
+	return nil
+}
+
+func (c *mqttClient) close() {
+	if c == nil || c.con == nil {
+		return
+	}
+	_, _ = c.con.Write([]byte{0xe0, 0})
+	c.con.Close()
+}
+
+func mqttString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+// mqttPacket prepends the fixed header with the variable length
+// encoded size of body.
+func mqttPacket(typ byte, body []byte) []byte {
+	b := []byte{typ}
+	n := len(body)
+	for {
+		d := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if n == 0 {
+			break
+		}
+	}
+	return append(b, body...)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// maxPacketSize returns the largest -p that fits the path mtu of con
+// unfragmented.
+func maxPacketSize(con net.Conn) (int, bool) {
+	mtu, err := pathMTU(con)
+	if err != nil {
+		return 0, false
+	}
+	if isIPv6(con.RemoteAddr().String()) {
+		return mtu - 40 - 8, true
+	}
+	return mtu - 20 - 8, true
+}
+
+// checkMTU warns before the test if -p will not fit the path mtu.
+func checkMTU(con net.Conn) {
+	max, ok := maxPacketSize(con)
+	if ok && pktSize > max {
+		fmt.Fprintf(os.Stderr, "packet size %d exceeds the path mtu, packets will be fragmented: use -p %d or less\n", pktSize, max)
+	}
+}
+
+// isMsgSize reports whether err is a send refused for the packet size.
+func isMsgSize(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE) ||
+		errors.Is(err, syscall.Errno(10040)) // WSAEMSGSIZE
+}
+
+// msgSizeReason explains a send refused for the packet size.
+func msgSizeReason(con net.Conn) string {
+	if max, ok := maxPacketSize(con); ok {
+		return fmt.Sprintf("packet size %d exceeds the path mtu: use -p %d or less", pktSize, max)
+	}
+	return fmt.Sprintf("packet size %d is too large for the path: lower -p", pktSize)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	openMetricsPath    string
+	openMetricsEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// openMetrics formats r as gauges in the text format the node_exporter
+// textfile collector reads, labelled with the role and -label pairs.
+func openMetrics(r *result) string {
+	keys := make([]string, 0, len(r.Labels))
+	for k := range r.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	ls := `role="` + openMetricsEscaper.Replace(r.Role) + `"`
+	for _, k := range keys {
+		if r.Labels[k] == "" || k == "role" {
+			continue
+		}
+		ls += fmt.Sprintf(`,%s="%s"`, metricName(k), openMetricsEscaper.Replace(r.Labels[k]))
+	}
+	var (
+		b    strings.Builder
+		last string
+	)
+	gauge := func(name, help string, v float64, extra string) {
+		if name != last {
+			fmt.Fprintf(&b, "# HELP udptest_%s %s\n# TYPE udptest_%s gauge\n", name, help, name)
+			last = name
+		}
+		fmt.Fprintf(&b, "udptest_%s{%s%s} %g\n", name, ls, extra, v)
+	}
+	gauge("last_run_timestamp_seconds", "When the last run started.", float64(r.Start.UnixNano())/1e9, "")
+	gauge("duration_seconds", "Duration of the last run.", r.DurationMs/1000, "")
+	gauge("packets_expected", "Packets the last run was to send.", float64(r.Count), "")
+	gauge("packets_sent", "Packets sent in the last run.", float64(r.Sent), "")
+	gauge("packets_received", "Packets received in the last run.", float64(r.Received), "")
+	gauge("packets_duplicate", "Duplicate packets in the last run.", float64(r.Duplicates), "")
+	gauge("packets_late", "Packets arriving after the last run.", float64(r.Late), "")
+	gauge("throughput_bits_per_second", "Payload throughput of the last run.", r.ThroughputMbps*1e6, "")
+	if r.LossPct != nil {
+		gauge("loss_ratio", "Packet loss of the last run.", *r.LossPct/100, "")
+	}
+	if r.JitterMs != nil {
+		gauge("jitter_seconds", "Mean packet delay variation of the last run.", *r.JitterMs/1000, "")
+	}
+	if r.RTT != nil {
+		for _, s := range []struct {
+			stat string
+			ms   float64
+		}{{"min", r.RTT.Min}, {"avg", r.RTT.Avg}, {"median", r.RTT.Median}, {"max", r.RTT.Max}} {
+			gauge("rtt_seconds", "Echo round trip times of the last run.", s.ms/1000, `,stat="`+s.stat+`"`)
+		}
+	}
+	b.WriteString("# EOF\n")
+	return b.String()
+}
+
+// metricName turns a label key into a valid metric label name.
+func metricName(k string) string {
+	b := []byte(k)
+	for i, c := range b {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// writeOpenMetrics replaces the file at path with r, through a rename
+// so the textfile collector never reads it half written.
+func writeOpenMetrics(path string, r *result) {
+	if path == "" {
+		return
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	ep(err)
+	_, err = f.WriteString(openMetrics(r))
+	ep(err)
+	ep(f.Chmod(0o644))
+	ep(f.Close())
+	ep(os.Rename(f.Name(), path))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// orderLogInterval is how often wrong packet order is reported while
+// it goes on.
+const orderLogInterval = time.Second
+
+// orderLog aggregates wrong packet order, a heavily reordering path
+// would flood the console and slow the receive loop with a line per
+// packet. The first case is printed at once, later ones as a count
+// with the first and last example at most every orderLogInterval.
+type orderLog struct {
+	total       int
+	n           int
+	first, last [2]uint16
+	next        time.Time
+}
+
+func (o *orderLog) add(prev, cur uint16, at time.Time) {
+	o.total++
+	if o.next.IsZero() {
+		fmt.Printf("wrong packet order: prev no: %d, cur no: %d\n", prev, cur)
+		o.next = at.Add(orderLogInterval)
+		return
+	}
+	if o.n == 0 {
+		o.first = [2]uint16{prev, cur}
+	}
+	o.last = [2]uint16{prev, cur}
+	o.n++
+	if at.After(o.next) {
+		o.flush()
+		o.next = at.Add(orderLogInterval)
+	}
+}
+
+// flush prints what was aggregated since the last report.
+func (o *orderLog) flush() {
+	switch {
+	case o.n == 1:
+		fmt.Printf("wrong packet order: prev no: %d, cur no: %d\n", o.first[0], o.first[1])
+	case o.n > 1:
+		fmt.Printf("wrong packet order: %d more, first: prev no: %d, cur no: %d, last: prev no: %d, cur no: %d\n",
+			o.n, o.first[0], o.first[1], o.last[0], o.last[1])
+	}
+	o.n = 0
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"time"
+)
+
+// outages tracks the periods nothing arrived, for failover tests the
+// longest of them tells more than the average loss.
+type outages struct {
+	t0, last time.Time
+	longest  time.Duration
+	at       time.Duration
+	// got marks the seconds since t0 anything arrived in
+	got []bool
+	// paused marks the seconds the client paused in
+	paused map[int]bool
+}
+
+func (o *outages) add(at time.Time) {
+	prev := o.last
+	if prev.IsZero() {
+		prev = o.t0
+	}
+	if d := at.Sub(prev); d > o.longest {
+		o.longest, o.at = d, prev.Sub(o.t0)
+	}
+	o.last = at
+	s := int(at.Sub(o.t0) / time.Second)
+	for len(o.got) <= s {
+		o.got = append(o.got, false)
+	}
+	o.got[s] = true
+}
+
+// resume leaves a pause from from to to out of the outages, and the
+// seconds it touched out of those counted unless something arrived.
+func (o *outages) resume(from, to time.Time) {
+	if !o.last.IsZero() {
+		o.last = o.last.Add(to.Sub(from))
+	}
+	if o.paused == nil {
+		o.paused = map[int]bool{}
+	}
+	for s := int(from.Sub(o.t0) / time.Second); s <= int(to.Sub(o.t0)/time.Second); s++ {
+		o.paused[s] = true
+	}
+}
+
+func (o *outages) stats() *outageStats {
+	if o.last.IsZero() {
+		return nil
+	}
+	s := &outageStats{LongestMs: ms(o.longest), AtMs: ms(o.at)}
+	for i, g := range o.got {
+		if o.paused[i] && !g {
+			continue
+		}
+		s.Seconds++
+		if !g {
+			s.UnavailableSeconds++
+		}
+	}
+	return s
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	resultpkg "github.com/dinalt/udptest/result"
+)
+
+// tunnelOverheads are the bytes a tunnel adds to every inner IP packet.
+var tunnelOverheads = map[string]int{
+	"gre":   24, // outer IPv4, GRE
+	"vxlan": 50, // outer IPv4, UDP, VXLAN, inner Ethernet
+	"ipsec": 73, // outer IPv4, ESP with AES-CBC IV, padding and ICV
+}
+
+// overhead is the -overhead flag.
+type overhead resultpkg.Overhead
+
+func (o *overhead) String() string {
+	return o.Name
+}
+
+func (o *overhead) Set(s string) error {
+	if n, ok := tunnelOverheads[s]; ok {
+		o.Name, o.Bytes = s, n
+		return nil
+	}
+	if v := strings.TrimPrefix(s, "custom:"); v != s {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("bad custom overhead: %s", v)
+		}
+		o.Name, o.Bytes = s, n
+		return nil
+	}
+	return fmt.Errorf("unknown overhead %q, expected vxlan, gre, ipsec or custom:N", s)
+}
+
+// autoSize returns the packet size whose inner IP packet plus the
+// tunnel overhead fits the underlay MTU.
+func (o *overhead) autoSize(addr string) int {
+	hdr := 20 + 8
+	if isIPv6(addr) {
+		hdr = 40 + 8
+	}
+	return o.MTU - o.Bytes - hdr
+}
+
+func isIPv6(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.To4() == nil
+	}
+	ua, err := net.ResolveUDPAddr("udp", addr)
+	return err == nil && ua.IP.To4() == nil
+}
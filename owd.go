@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/binary"
+	"sort"
+	"time"
+)
+
+// owdStampSize is what -owd stamps into the payload of replies: the
+// server receive and send times in unix nanoseconds.
+const owdStampSize = 16
+
+var owdStamps bool
+
+// stampReply stamps the reply payload pl of a packet received at recv.
+func stampReply(pl []byte, recv time.Time) {
+	binary.LittleEndian.PutUint64(pl, uint64(recv.UnixNano()))
+	binary.LittleEndian.PutUint64(pl[8:], uint64(time.Now().UnixNano()))
+}
+
+// oneWay splits round trips into the delay of each direction, the
+// server times taken back to the client clock with the -sync offset.
+type oneWay struct {
+	fwd, rev []time.Duration
+}
+
+// add takes the stamps of a reply to a packet sent at sent, unix
+// nanoseconds, and received at now.
+func (o *oneWay) add(sent int64, data []byte, now time.Time) {
+	if len(data) < owdStampSize {
+		return
+	}
+	off := int64(clockOffset())
+	t2 := int64(binary.LittleEndian.Uint64(data)) - off
+	t3 := int64(binary.LittleEndian.Uint64(data[8:])) - off
+	o.fwd = append(o.fwd, time.Duration(t2-sent))
+	o.rev = append(o.rev, time.Duration(now.UnixNano()-t3))
+}
+
+func (o *oneWay) stats() *owdStats {
+	if len(o.fwd) == 0 {
+		return nil
+	}
+	return &owdStats{Forward: delayStats(o.fwd), Reverse: delayStats(o.rev)}
+}
+
+// delayStats sorts d and returns its min, average, median and max.
+func delayStats(d []time.Duration) *rttStats {
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	var sum time.Duration
+	for _, v := range d {
+		sum += v
+	}
+	return &rttStats{Min: ms(d[0]), Avg: ms(sum / time.Duration(len(d))), Median: ms(d[len(d)/2]), Max: ms(d[len(d)-1])}
+}
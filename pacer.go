@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pacerSpin is the part of every wait that is busy-waited instead of
+// slept, as sleeps are only accurate to the scheduler granularity.
+var pacerSpin = 200 * time.Microsecond
+
+var timerOnce sync.Once
+
+// pacer releases sends at fixed points in time. A send that comes
+// later than a whole interval is counted as overrun and the schedule
+// is restarted from it, unless catchUp is set, in which case the
+// missed sends are released back to back.
+type pacer struct {
+	interval time.Duration
+	catchUp  bool
+	next     time.Time
+	first    time.Time
+	last     time.Time
+	n        int64
+	lateSum  time.Duration
+	lateMax  time.Duration
+	// sched, if set, gives when the n-th send is due after start
+	// instead of the interval
+	sched func(n int64) time.Duration
+	start time.Time
+}
+
+func newPacer(first time.Time, interval time.Duration) *pacer {
+	timerOnce.Do(setupTimer)
+	return &pacer{interval: interval, catchUp: catchUp, next: first}
+}
+
+func (p *pacer) wait() {
+	if d := waitResume(); d > 0 {
+		// the schedule continues where it was paused
+		p.next = p.next.Add(d)
+		p.start = p.start.Add(d)
+	}
+	if p.sched != nil {
+		p.next = p.start.Add(p.sched(p.n))
+	}
+	if d := time.Until(p.next); d > pacerSpin {
+		time.Sleep(d - pacerSpin)
+	}
+	for time.Now().Before(p.next) {
+		runtime.Gosched()
+	}
+	now := time.Now()
+	late := now.Sub(p.next)
+	interval := scaled(p.interval)
+	if p.n == 0 {
+		p.first = now
+	}
+	p.last = now
+	p.n++
+	p.lateSum += late
+	if late > p.lateMax {
+		p.lateMax = late
+	}
+	if late > interval {
+		atomic.AddInt64(&overruns, 1)
+		if !p.catchUp {
+			p.next = now
+		}
+	}
+	p.next = p.next.Add(interval)
+}
+
+var (
+	pauseMu sync.Mutex
+	// resumed is closed when a pause ends, nil while sending
+	resumed  chan struct{}
+	pausedAt time.Time
+	// pausedFor adds up the pauses of the test
+	pausedFor time.Duration
+)
+
+// pause stops all pacers until resume and returns the channel closed
+// then, or nil if they are paused already.
+func pause() chan struct{} {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+	if resumed != nil {
+		return nil
+	}
+	resumed = make(chan struct{})
+	pausedAt = time.Now()
+	return resumed
+}
+
+// resume lets paused pacers continue and returns how long they waited.
+func resume() time.Duration {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+	if resumed == nil {
+		return 0
+	}
+	close(resumed)
+	resumed = nil
+	d := time.Since(pausedAt)
+	pausedFor += d
+	return d
+}
+
+// waitResume blocks while sending is paused and returns for how long.
+func waitResume() time.Duration {
+	pauseMu.Lock()
+	c := resumed
+	pauseMu.Unlock()
+	if c == nil {
+		return 0
+	}
+	t := time.Now()
+	for c != nil && !isAborted() {
+		select {
+		case <-c:
+			c = nil
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return time.Since(t)
+}
+
+func (p *pacer) merge(o *pacer) {
+	p.n += o.n
+	p.lateSum += o.lateSum
+	if o.lateMax > p.lateMax {
+		p.lateMax = o.lateMax
+	}
+	if o.n == 0 {
+		return
+	}
+	if p.first.IsZero() || o.first.Before(p.first) {
+		p.first = o.first
+	}
+	if o.last.After(p.last) {
+		p.last = o.last
+	}
+}
+
+// achieved returns the average time between sends.
+func (p *pacer) achieved() time.Duration {
+	if p.n < 2 {
+		return 0
+	}
+	return p.last.Sub(p.first) / time.Duration(p.n-1)
+}
+
+func (p *pacer) String() string {
+	if p.n == 0 {
+		return "pacing: no sends"
+	}
+	return fmt.Sprintf("pacing: %d sends, mean delay %v, max delay %v",
+		p.n, p.lateSum/time.Duration(p.n), p.lateMax)
+}
+
+func rateString(configured, achieved time.Duration) string {
+	pps := func(d time.Duration) float64 {
+		if d == 0 {
+			return 0
+		}
+		return float64(time.Second) / float64(d)
+	}
+	return fmt.Sprintf("interval: configured %v (%.1f pps), achieved %v (%.1f pps)",
+		configured, pps(configured), achieved, pps(achieved))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pathTable counts the packets the server received per path.
+type pathTable map[string]*serverPath
+
+type serverPath struct {
+	first    uint16
+	received int
+}
+
+func (t pathTable) add(key string, no uint16) {
+	p := t[key]
+	if p == nil {
+		p = &serverPath{first: no}
+		t[key] = p
+	}
+	p.received++
+}
+
+// stats returns the figures per path. Clients sending from n sockets
+// interleave the packet numbers, so if every path is one socket each of
+// n paths is expected to carry the numbers congruent to its first one
+// modulo n. Otherwise what was sent per path is unknown.
+func (t pathTable) stats(count int, bySocket bool) []*pathStats {
+	n := len(t)
+	var s []*pathStats
+	for key, p := range t {
+		ps := &pathStats{Name: key, Received: p.received}
+		if bySocket {
+			r := (int(p.first) - 1) % n
+			ps.SetReceived((count-r+n-1)/n, p.received)
+		}
+		s = append(s, ps)
+	}
+	sort.Slice(s, func(i, j int) bool { return s[i].Name < s[j].Name })
+	return s
+}
+
+// pathKeyList is the -path-key flag: the packet attributes the server
+// tells paths apart by.
+type pathKeyList []string
+
+var pathKeys = pathKeyList{"addr"}
+
+func (l *pathKeyList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *pathKeyList) Set(s string) error {
+	*l = nil
+	for _, f := range strings.Split(s, ",") {
+		switch f {
+		case "addr", "port", "dscp", "flowlabel":
+			*l = append(*l, f)
+		default:
+			return errors.New("unknown path key: " + f)
+		}
+	}
+	return nil
+}
+
+func (l pathKeyList) has(key string) bool {
+	for _, k := range l {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// needCmsg reports whether keys are taken from control messages.
+func (l pathKeyList) needCmsg() bool {
+	return l.has("dscp") || l.has("flowlabel")
+}
+
+// bySocket reports whether every path is one client socket.
+func (l pathKeyList) bySocket() bool {
+	return l.has("addr") || l.has("port")
+}
+
+func (l pathKeyList) key(p *paket) string {
+	parts := make([]string, len(l))
+	for i, k := range l {
+		switch k {
+		case "addr":
+			parts[i] = p.from.String()
+		case "port":
+			if a, ok := p.from.(*net.UDPAddr); ok {
+				parts[i] = "port " + strconv.Itoa(a.Port)
+			}
+		case "dscp":
+			parts[i] = "dscp " + dscpName(p.tos>>2)
+		case "flowlabel":
+			parts[i] = fmt.Sprintf("flowlabel %#x", p.flow)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func (l *pathKeyList) drop(key string) {
+	keys := (*l)[:0]
+	for _, k := range *l {
+		if k != key {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		keys = append(keys, "addr")
+	}
+	*l = keys
+}
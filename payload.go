@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dinalt/udptest/payload"
+)
+
+// payloadMode is the -payload flag. Links with compressing middleboxes
+// carry more zero or text payload than random payload per second.
+var payloadMode = "random"
+
+func checkPayloadMode() error {
+	switch payloadMode {
+	case "random", "zero", "text", "dict":
+		return nil
+	}
+	return fmt.Errorf("unknown payload %q, expected random, zero, text or dict", payloadMode)
+}
+
+// newPayloadSource returns the source selected by -file or -payload for
+// a goroutine sending the packets numbered first, first+step, ...
+func newPayloadSource(first, step int) payload.PayloadSource {
+	if fileData != nil {
+		return payload.File(fileData, pktSize-pktInfSize, first, step)
+	}
+	if payloadMode == "random" {
+		return payload.Random()
+	}
+	return payload.Pattern(payloadMode)
+}
@@ -0,0 +1,92 @@
+// Package payload generates the payload of udptest packets. A source
+// fills one packet after the other and is not safe for concurrent use,
+// every sending goroutine makes its own.
+package payload
+
+import (
+	"crypto/rand"
+	"fmt"
+	mrand "math/rand"
+)
+
+// PayloadSource fills buf with the payload of the next packet. Embedders
+// implement it to send application-like payload, e.g. RTP headers.
+type PayloadSource interface {
+	Next(buf []byte) error
+}
+
+// Random returns a source of incompressible payload.
+func Random() PayloadSource {
+	return randomSource{}
+}
+
+type randomSource struct{}
+
+func (randomSource) Next(b []byte) error {
+	_, err := rand.Read(b)
+	return err
+}
+
+// Pattern returns a source of zero, text or dict payload, which
+// compressing middleboxes shrink. Other kinds fail on Next.
+func Pattern(kind string) PayloadSource {
+	return patternSource(kind)
+}
+
+type patternSource string
+
+var words = []string{
+	"the", "of", "and", "to", "in", "is", "that", "for", "it", "as",
+	"with", "was", "on", "be", "at", "by", "this", "had", "not", "are",
+	"but", "from", "or", "have", "an", "they", "which", "one", "you", "were",
+	"packet", "network", "latency", "server", "client", "request", "response",
+}
+
+func (p patternSource) Next(b []byte) error {
+	switch p {
+	case "zero":
+		for i := range b {
+			b[i] = 0
+		}
+	case "text":
+		const letters = "abcdefghijklmnopqrstuvwxyz     "
+		for i := range b {
+			b[i] = letters[mrand.Intn(len(letters))]
+		}
+	case "dict":
+		for i := 0; i < len(b); {
+			i += copy(b[i:], words[mrand.Intn(len(words))])
+			if i < len(b) {
+				b[i] = ' '
+				i++
+			}
+		}
+	default:
+		return fmt.Errorf("unknown payload pattern %q", string(p))
+	}
+	return nil
+}
+
+// File returns a source sending data in chunks of chunk bytes, zero
+// padded, for the packets numbered first, first+step, and so on from 1.
+func File(data []byte, chunk, first, step int) PayloadSource {
+	return &fileSource{data: data, chunk: chunk, no: first, step: step}
+}
+
+type fileSource struct {
+	data            []byte
+	chunk, no, step int
+}
+
+func (f *fileSource) Next(b []byte) error {
+	off := (f.no - 1) * f.chunk
+	if off > len(f.data) {
+		off = len(f.data)
+	}
+	n := copy(b, f.data[off:])
+	for i := n; i < len(b); i++ {
+		b[i] = 0
+	}
+	f.no += f.step
+	return nil
+}
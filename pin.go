@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// pinCPUs are the cpus of -cpu-pin.
+var pinCPUs []int
+
+// pinLoop locks the calling goroutine to its thread and pins that to
+// the n-th of the -cpu-pin cpus, round robin, so a hot loop keeps a cpu
+// to itself.
+func pinLoop(n int) {
+	if len(pinCPUs) == 0 {
+		return
+	}
+	runtime.LockOSThread()
+	if err := pinThread(pinCPUs[n%len(pinCPUs) : n%len(pinCPUs)+1]); err != nil {
+		fmt.Fprintln(os.Stderr, "pinning failed:", err)
+	}
+}
+
+// parseCPUList parses a cpu list like "0-3,6".
+func parseCPUList(s string) ([]int, error) {
+	var cpus []int
+	for _, r := range strings.Split(s, ",") {
+		first, last := r, r
+		if i := strings.IndexByte(r, '-'); i >= 0 {
+			first, last = r[:i], r[i+1:]
+		}
+		a, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("bad cpu list %q", s)
+		}
+		b, err := strconv.Atoi(last)
+		if err != nil || b < a {
+			return nil, fmt.Errorf("bad cpu list %q", s)
+		}
+		for c := a; c <= b; c++ {
+			cpus = append(cpus, c)
+		}
+	}
+	return cpus, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// portRange splits a server address host:lo-hi, ok is false for a
+// single port.
+func portRange(a string) (host string, lo, hi int, ok bool) {
+	host, port, err := net.SplitHostPort(a)
+	i := strings.IndexByte(port, '-')
+	if err != nil || i < 0 {
+		return "", 0, 0, false
+	}
+	lo, err = strconv.Atoi(port[:i])
+	hi, err2 := strconv.Atoi(port[i+1:])
+	if err != nil || err2 != nil || lo < 1 || hi > 65535 || lo > hi {
+		fmt.Fprintln(os.Stderr, "port range should be lo-hi within 1-65535")
+		os.Exit(1)
+	}
+	return host, lo, hi, true
+}
+
+// perPort are the flags naming files each port's server needs its
+// own of.
+var perPort = []string{"json", "lost-out", "trace", "capture", "file", "influx", "write-openmetrics"}
+
+// listenRange runs a server process per port, each with its own
+// sessions and files, and prefixes their output with the port.
+func listenRange(host string, lo, hi int) {
+	for _, name := range []string{"metrics", "health", "pprof"} {
+		if lookupFlag(name) != "" {
+			fmt.Fprintf(os.Stderr, "-%s can not be shared by a port range\n", name)
+			os.Exit(1)
+		}
+	}
+	self, err := os.Executable()
+	ep(err)
+	// the flags as given, later ones override them
+	given := os.Args[1 : len(os.Args)-flag.NArg()]
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for port := lo; port <= hi; port++ {
+		p := strconv.Itoa(port)
+		args := append([]string{}, given...)
+		for _, name := range perPort {
+			if v := lookupFlag(name); v != "" && v != "-" {
+				args = append(args, "-"+name, portPath(v, p))
+			}
+		}
+		if sessionDir != "" {
+			dir := filepath.Join(sessionDir, p)
+			ep(os.MkdirAll(dir, 0o755))
+			args = append(args, "-session-dir", dir)
+		}
+		cmd := exec.Command(self, append(args, net.JoinHostPort(host, p))...)
+		out, err := cmd.StdoutPipe()
+		ep(err)
+		errs, err := cmd.StderrPipe()
+		ep(err)
+		ep(cmd.Start())
+		wg.Add(3)
+		// machine readable output on stdout is passed on as is
+		if os.Stdout != stdout {
+			go copyLines(stdout, out, "", &mu, &wg)
+		} else {
+			go copyLines(stdout, out, p+": ", &mu, &wg)
+		}
+		go copyLines(os.Stderr, errs, p+": ", &mu, &wg)
+		go func() {
+			defer wg.Done()
+			if err := cmd.Wait(); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", p, err)
+			}
+		}()
+	}
+	fmt.Printf("listening on ports %d-%d\n", lo, hi)
+	wg.Wait()
+}
+
+func lookupFlag(name string) string {
+	return flag.Lookup(name).Value.String()
+}
+
+// portPath puts the port before the extension of path.
+func portPath(path, port string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + port + ext
+}
+
+func copyLines(w io.Writer, r io.Reader, prefix string, mu *sync.Mutex, wg *sync.WaitGroup) {
+	defer wg.Done()
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		mu.Lock()
+		fmt.Fprintln(w, prefix+sc.Text())
+		mu.Unlock()
+	}
+}
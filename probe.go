@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// probe sends a burst of -cnt echo packets every probeEvery, forever,
+// and prints an rrdtool update line per burst:
+//
+//	<unix time>:<loss percent>:<median rtt seconds>
+//
+// The server has to run with -service -echo and the same -cnt.
+// -reresolve watches the target host for address changes.
+func probe() {
+	d := net.Dialer{Control: dialControl}
+	con, err := d.Dial("udp", addr)
+	ep(err)
+	defer func() { con.Close() }()
+	dns := newDNSWatch()
+	for next := time.Now(); ; next = next.Add(probeEvery) {
+		time.Sleep(time.Until(next))
+		if a := dns.check(con.RemoteAddr()); a != "" {
+			con.Close()
+			con, err = d.Dial("udp", a)
+			ep(err)
+			fmt.Fprintln(os.Stderr, "reconnected to", a)
+		}
+		loss, med := burst(con)
+		fmt.Printf("%d:%.2f:%.6f\n", next.Unix(), loss, med.Seconds())
+		emit("probe", map[string]interface{}{"loss_pct": loss, "median_rtt_ms": ms(med)})
+	}
+}
+
+func burst(con net.Conn) (loss float64, median time.Duration) {
+	echoes = newEchoStats()
+	_, err := con.Write(start)
+	ep(err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		readBack(con.(net.PacketConn), pktCount)
+	}()
+	sent := send(con, md5.New(), newPacer(time.Now().Add(sendInterval), sendInterval), 1, 1)
+	<-done
+	echoes.mu.Lock()
+	defer echoes.mu.Unlock()
+	_, _, median, _ = echoes.rtt()
+	if sent > 0 {
+		loss = float64(sent-len(echoes.rtts)) / float64(sent) * 100
+	}
+	return loss, median
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	resultpkg "github.com/dinalt/udptest/result"
+)
+
+// The -profile flag sets up a test resembling an application's traffic
+// and reports what matters for it.
+
+const (
+	// game packets are mostly gameMin to gameMid bytes with one in
+	// gameBig a state update of up to gameMax
+	gameMin   = 48
+	gameMid   = 96
+	gameMax   = 180
+	gameBig   = 8
+	gameSpike = resultpkg.GameSpike
+	// a video I-frame comes every second and is videoIRatio times
+	// the size of a P-frame, which vary by videoPVary either way
+	videoIRatio = 6
+	videoPVary  = 0.25
+)
+
+var (
+	profileSpec string
+	gameHz      int
+	video       videoProfile
+)
+
+// setupProfile applies -profile, game[:<hz>] sends small packets of
+// varying size at 20 to 60 Hz, 30 by default, with echo.
+// video[:<rate>,<fps>] sends frames as bursts at the frame rate,
+// 4mbps and 30fps by default; the server needs the same -profile to
+// tell the frames apart.
+func setupProfile() error {
+	if profileSpec == "" {
+		return nil
+	}
+	name, arg := profileSpec, ""
+	if i := strings.IndexByte(profileSpec, ':'); i >= 0 {
+		name, arg = profileSpec[:i], profileSpec[i+1:]
+	}
+	switch name {
+	case "game":
+		gameHz = 30
+		if arg != "" {
+			hz, err := strconv.Atoi(arg)
+			if err != nil || hz < 20 || hz > 60 {
+				return fmt.Errorf("game profile rate should be 20 to 60 Hz")
+			}
+			gameHz = hz
+		}
+		echo = true
+		sendInterval = time.Second / time.Duration(gameHz)
+		pktSize = gameMax
+		fmt.Printf("game profile: %d Hz, %d-%d byte packets, run the server with -echo -p %d -cnt %d\n", gameHz, gameMin, gameMax, gameMax, pktCount)
+	case "video":
+		if ramp.segs != nil || replayPath != "" {
+			return fmt.Errorf("video profile can not be combined with ramp or replay")
+		}
+		if arg == "" {
+			arg = "4mbps,30fps"
+		}
+		if err := video.set(arg); err != nil {
+			return err
+		}
+		video.frames()
+		sendInterval = time.Duration(float64(pktSize*8) / video.rate * float64(time.Second))
+		fmt.Printf("video profile: %s at %d fps, %d frames", formatBitRate(video.rate), video.fps, len(video.first)-1)
+		if !isServer {
+			fmt.Printf(", run the server with -profile %s -p %d -cnt %d", profileSpec, pktSize, pktCount)
+		}
+		fmt.Println()
+	default:
+		return fmt.Errorf("unknown profile %q, expected game[:<hz>] or video[:<rate>,<fps>]", name)
+	}
+	return nil
+}
+
+// gameSize returns the datagram size of packet no, spread
+// deterministically so every sender picks the same.
+func gameSize(no uint16) int {
+	h := uint32(no) * 2654435761
+	if h>>28%gameBig == 0 {
+		return gameMid + int(h%(gameMax-gameMid+1))
+	}
+	return gameMin + int(h%(gameMid-gameMin+1))
+}
+
+// game evaluates the echo replies of sent packets.
+func (e *echoStats) game(sent int) *gameStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	g := &gameStats{Hz: gameHz}
+	if sent > 0 {
+		g.LossPct = float64(sent-len(e.rtts)) / float64(sent) * 100
+	}
+	for _, d := range e.rtts {
+		if d > gameSpike {
+			g.Spikes++
+		}
+	}
+	run := 0
+	for no := 1; no <= sent && no < len(e.replied); no++ {
+		if e.replied[no] {
+			run = 0
+			continue
+		}
+		run++
+		if run > g.OutagePackets {
+			g.OutagePackets = run
+		}
+	}
+	g.LongestOutageMs = ms(time.Duration(g.OutagePackets) * sendInterval)
+	return g
+}
+
+// videoProfile is the frame schedule of -profile video.
+type videoProfile struct {
+	rate float64
+	fps  int
+	// first holds the index, from 0, of the first packet of every
+	// frame and of the packet after the last
+	first []int
+}
+
+func (v *videoProfile) set(arg string) error {
+	i := strings.IndexByte(arg, ',')
+	if i < 0 || !strings.HasSuffix(arg, "fps") {
+		return fmt.Errorf("video profile should be video:<rate>,<fps>fps")
+	}
+	rate, err := parseBitRate(arg[:i])
+	if err != nil {
+		return err
+	}
+	fps, err := strconv.Atoi(strings.TrimSuffix(arg[i+1:], "fps"))
+	if err != nil || fps < 1 || fps > 240 {
+		return fmt.Errorf("video frame rate should be 1 to 240 fps")
+	}
+	v.rate, v.fps = rate, fps
+	return nil
+}
+
+// frames splits the -cnt packets of -p bytes into frames, a group of
+// an I-frame and fps-1 P-frames averaging the rate.
+func (v *videoProfile) frames() {
+	p := v.rate / 8 / float64(videoIRatio+v.fps-1)
+	v.first = []int{0}
+	for f, k := 0, 0; k < pktCount; f++ {
+		size := p * videoIRatio
+		if f%v.fps != 0 {
+			// deterministic like gameSize
+			h := uint32(f) * 2654435761
+			size = p * (1 + videoPVary*(float64(h%2001)/1000-1))
+		}
+		n := (int(size) + pktSize - 1) / pktSize
+		if n < 1 {
+			n = 1
+		}
+		if k += n; k > pktCount {
+			k = pktCount
+		}
+		v.first = append(v.first, k)
+	}
+}
+
+// timeOf returns when into the test packet k, counting from 0, is
+// sent, all packets of a frame at once.
+func (v *videoProfile) timeOf(k int) time.Duration {
+	f := sort.SearchInts(v.first, k+1) - 1
+	return time.Duration(f) * time.Second / time.Duration(v.fps)
+}
+
+// stats counts the frames with all, some or none of their packets
+// seen.
+func (v *videoProfile) stats(seen []bool) *videoStats {
+	s := &videoStats{Frames: len(v.first) - 1}
+	for f := 0; f < s.Frames; f++ {
+		got := 0
+		for no := v.first[f] + 1; no <= v.first[f+1]; no++ {
+			if seen[no] {
+				got++
+			}
+		}
+		switch n := v.first[f+1] - v.first[f]; {
+		case got == n:
+			s.Complete++
+			continue
+		case got == 0:
+			s.Missing++
+		default:
+			s.Partial++
+		}
+		if f%v.fps == 0 {
+			s.IFramesBroken++
+		}
+	}
+	if s.Frames > 0 {
+		s.CompletePct = float64(s.Complete) / float64(s.Frames) * 100
+	}
+	return s
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"unicode"
+)
+
+// protoVector is a datagram with what a conforming implementation
+// decodes from it.
+type protoVector struct {
+	Name    string  `json:"name"`
+	Kind    string  `json:"kind"` // data, control, raw or invalid
+	Hex     string  `json:"hex"`
+	No      *uint16 `json:"no,omitempty"`
+	Payload string  `json:"payload_hex,omitempty"`
+	Text    string  `json:"text,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
+func proto(args []string) {
+	fs := flag.NewFlagSet("proto", flag.ExitOnError)
+	vectors := fs.String("vectors", "", "write conformance vectors as json to this file, - for stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: proto [-vectors path]")
+		fs.PrintDefaults()
+	}
+	ep(fs.Parse(args))
+	if *vectors == "" {
+		printProto()
+		return
+	}
+	b, err := json.MarshalIndent(protoVectors(), "", "  ")
+	ep(err)
+	b = append(b, '\n')
+	if *vectors == "-" {
+		_, err = os.Stdout.Write(b)
+		ep(err)
+		return
+	}
+	ep(os.WriteFile(*vectors, b, 0o644))
+}
+
+func printProto() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "protocol version %d\n\n", protoVersion)
+	fmt.Fprintln(w, "data and control packets, integers little endian:")
+	fmt.Fprintln(w, "offset\tsize\tfield")
+	fmt.Fprintf(w, "0\t%d\tpacket no, 1 to %d for data, 0 for control\n", pktNoSize, pktMaxCount)
+	fmt.Fprintf(w, "%d\t%d\tpayload size n\n", pktNoSize, pktSzSize)
+	fmt.Fprintf(w, "%d\tn\tpayload\n", pktHdrSize)
+	fmt.Fprintf(w, "%d+n\t%d\tend marker %q\n", pktHdrSize, pktEndSize, pktEnd)
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "a datagram is %d+n bytes, at most %d; -p counts the whole datagram\n", pktInfSize, pktMaxSize)
+	fmt.Fprintln(w, "with -gso several packets are concatenated into one write and split by the kernel")
+	fmt.Fprintln(w, "with -frag every even packet is padded past the path mtu")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "control payloads, space separated text:")
+	fmt.Fprintln(w, "seen <addr:port> [version <n>]\tserver to client, answers start with the source it saw and the version both speak")
+	fmt.Fprintln(w, "busy <retry after ms>\tserver to client, answers start during another client's session")
+	fmt.Fprintln(w, "queued <position> <wait ms>\tserver to client with -queue, answers start, seen follows on the client's turn")
+	fmt.Fprintln(w, "retry <cookie>\tserver to client with -cookie, answers start, which is resent with the cookie")
+	fmt.Fprintln(w, "error <reason>\tserver to client, refuses start beyond -max-rate or -max-clients, or ends a test going over -max-rate")
+	fmt.Fprintln(w, "info <key=value>...\tserver to client, answers query with version, modes, count, size, limits and load")
+	fmt.Fprintf(w, "beat <n>\tserver to client from version 3, every %v of a test without -echo\n", heartbeatInterval)
+	fmt.Fprintln(w, "stat <received> <highest no>\tserver to client, progress")
+	fmt.Fprintln(w, "stop\tclient to server, test aborted")
+	fmt.Fprintln(w, "keepalive\tclient to server, keeps nat bindings open")
+	fmt.Fprintln(w, "mark <no> <label>\tclient to server, a labeled section starts with packet no, sent twice")
+	fmt.Fprintf(w, "pause\tclient to server, sending paused, repeated every %v until resume\n", pauseRepeat)
+	fmt.Fprintln(w, "resume\tclient to server, sending continues, a data packet after pause also resumes")
+	fmt.Fprintln(w, "end <step> <sent>\tserver to client, -downstream step sent")
+	fmt.Fprintln(w, "rep <step> <received>\tclient to server, -downstream step report")
+	fmt.Fprintln(w, "done <best rate>\tserver to client, -downstream finished")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "raw datagrams, not framed:")
+	fmt.Fprintln(w, "start [<test id> [file <size> <sha256 hex>] [rate <bit/s>] [version <n>] [cookie <hex>]]\tclient to server, begins a test")
+	fmt.Fprintf(w, "query\tclient to server, zero padded to %d bytes, info is only sent if not larger\n", querySize)
+	fmt.Fprintf(w, "sync <t1 i64>\tclient to server, %d bytes, send time in unix ns, zero padded to %d with -cookie\n", syncReqSize, syncRepSize)
+	fmt.Fprintf(w, "sync <t1 i64> <t2 i64> <t3 i64>\tserver reply, %d bytes, adds receive and send times\n", syncRepSize)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "echo replies (-echo) repeat the packet no, with the payload cut or zero padded to -reply-size")
+	fmt.Fprintln(w, "with -owd the reply payload starts with the server receive and send times, i64 unix ns each")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "a minimal responder (-responder) needs just:")
+	fmt.Fprintln(w, "start\treset the received count and highest no")
+	fmt.Fprintln(w, "sync\treply as above")
+	fmt.Fprintln(w, "data packet\tsend it back unchanged, count it")
+	fmt.Fprintf(w, "\tsend stat at most every %v\n", statInterval)
+	fmt.Fprintln(w, "anything else\tignore")
+}
+
+func protoVectors() []protoVector {
+	counting := make([]byte, 16)
+	for i := range counting {
+		counting[i] = byte(i)
+	}
+	var vs []protoVector
+	add := func(name, kind string, b []byte) {
+		v := protoVector{Name: name, Kind: kind, Hex: hex.EncodeToString(b)}
+		if kind == "raw" {
+			if bytes.IndexFunc(b, func(r rune) bool { return !unicode.IsPrint(r) }) < 0 {
+				v.Text = string(b)
+			}
+			vs = append(vs, v)
+			return
+		}
+		no, payload, err := Decode(b)
+		if err != nil {
+			v.Error = err.Error()
+		} else {
+			v.No = &no
+			v.Payload = hex.EncodeToString(payload)
+			if no == 0 {
+				v.Text = string(payload)
+			}
+		}
+		vs = append(vs, v)
+	}
+	data := func(no uint16, payload []byte) []byte {
+		b := make([]byte, pktInfSize+len(payload))
+		_, err := Encode(b, no, payload)
+		ep(err)
+		return b
+	}
+	add("data packet 1", "data", data(1, counting))
+	add("data packet 65535", "data", data(pktMaxCount, counting[:4]))
+	add("empty payload", "data", data(2, nil))
+	add("seen", "control", ctlPacket("seen", "198.51.100.7:40000", "version", protoVersion))
+	add("busy", "control", ctlPacket("busy", 12500))
+	add("queued", "control", ctlPacket("queued", 2, 30000))
+	add("retry", "control", ctlPacket("retry", "5f0c2a9e81d4b377"))
+	add("error", "control", ctlPacket("error", "max-clients 4 reached"))
+	add("info", "control", ctlPacket("info", "version=3", "modes=upload,file,echo", "count=1000", "size=1000",
+		"max-rate=0", "max-clients=0", "queue=0", "cookie=0", "sessions=12", "busy=0", "queued=0"))
+	add("beat", "control", ctlPacket("beat", 7))
+	add("stat", "control", ctlPacket("stat", 100, 120))
+	add("stop", "control", ctlPacket("stop"))
+	add("keepalive", "control", ctlPacket("keepalive"))
+	add("mark", "control", ctlPacket("mark", 1501, "ramp 2 hold"))
+	add("pause", "control", ctlPacket("pause"))
+	add("resume", "control", ctlPacket("resume"))
+	add("downstream end", "control", ctlPacket("end", 2, 1000))
+	add("downstream report", "control", ctlPacket("rep", 2, 998))
+	add("downstream done", "control", ctlPacket("done", 5000))
+	add("start", "raw", startPacket("0123456789abcdef", nil, 0))
+	add("start with file", "raw", startPacket("0123456789abcdef", &fileInfo{size: 4096, sum: bytes.Repeat([]byte{0xab}, 32)}, 0))
+	add("start with rate", "raw", startPacket("0123456789abcdef", nil, 6000000))
+	add("start with cookie", "raw", append(startPacket("0123456789abcdef", nil, 6000000), " cookie 5f0c2a9e81d4b377"...))
+	q := make([]byte, querySize)
+	copy(q, queryMsg)
+	add("query", "raw", q)
+	sync := make([]byte, syncRepSize)
+	copy(sync, syncMsg)
+	binary.LittleEndian.PutUint64(sync[4:], 1_000_000_000)
+	add("sync request", "raw", sync[:syncReqSize])
+	add("padded sync request", "raw", append(sync[:syncReqSize:syncReqSize], make([]byte, syncRepSize-syncReqSize)...))
+	binary.LittleEndian.PutUint64(sync[12:], 1_000_500_000)
+	binary.LittleEndian.PutUint64(sync[20:], 1_000_600_000)
+	add("sync reply", "raw", sync)
+	add("too short", "invalid", []byte{1, 0, 0, 0, '\r'})
+	bad := data(3, counting[:8])
+	add("size mismatch", "invalid", bad[:len(bad)-1])
+	bad = data(3, counting[:8])
+	bad[len(bad)-1] = 0
+	add("bad end marker", "invalid", bad)
+	return vs
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// protoVersion is raised on incompatible protocol changes, see
+// peerVersion.
+const protoVersion = 3
+
+// querySize is what a query is zero padded to, the info reply must not
+// be larger.
+const querySize = 256
+
+var queryMsg = []byte("query")
+
+// answerQuery replies to a query datagram with the server's protocol
+// version, modes, limits and load, and reports whether b was one.
+// busy tells if a session is running.
+func answerQuery(con net.PacketConn, b []byte, to net.Addr, local net.IP, busy bool) bool {
+	if !bytes.HasPrefix(b, queryMsg) || len(bytes.Trim(b[len(queryMsg):], "\x00")) > 0 {
+		return false
+	}
+	modes := []string{"upload", "file"}
+	if echo {
+		modes = append(modes, "echo")
+	}
+	if downstream {
+		modes = append(modes, "downstream")
+	}
+	state := 0
+	if busy {
+		state = 1
+	}
+	cookie := 0
+	if requireCookie {
+		cookie = 1
+	}
+	info := ctlPacket("info",
+		fmt.Sprint("version=", protoVersion),
+		"modes="+strings.Join(modes, ","),
+		fmt.Sprint("count=", pktCount),
+		fmt.Sprint("size=", pktSize),
+		fmt.Sprint("max-rate=", maxRate),
+		fmt.Sprint("max-clients=", maxClients),
+		fmt.Sprint("queue=", queueLen),
+		fmt.Sprint("cookie=", cookie),
+		fmt.Sprint("sessions=", atomic.LoadInt64(&sessions)),
+		fmt.Sprint("busy=", state),
+		fmt.Sprint("queued=", waiting.len()))
+	if len(info) <= len(b) {
+		ep(replyTo(con, info, to, local))
+	}
+	return true
+}
+
+// query asks a server what it supports and prints the answer.
+func query(args []string) {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the answer as a json object")
+	timeout := fs.Duration("timeout", 3*time.Second, "give up after this long")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: query [flags] <host:port>")
+		fmt.Fprintln(os.Stderr, "asks a running server for its protocol version, modes, limits and load")
+		fs.PrintDefaults()
+	}
+	ep(fs.Parse(args))
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	con, err := net.Dial("udp", fs.Arg(0))
+	ep(err)
+	defer con.Close()
+	req := make([]byte, querySize)
+	copy(req, queryMsg)
+	buf := make([]byte, querySize)
+	end := time.Now().Add(*timeout)
+	for time.Now().Before(end) {
+		_, err := con.Write(req)
+		ep(err)
+		con.SetReadDeadline(time.Now().Add(acceptWait))
+		for {
+			n, err := con.Read(buf)
+			if err != nil {
+				break
+			}
+			no, payload, err := Decode(buf[:n])
+			f := strings.Fields(string(payload))
+			if err != nil || no != 0 || len(f) == 0 || f[0] != "info" {
+				continue
+			}
+			printInfo(f[1:], *asJSON)
+			return
+		}
+	}
+	fmt.Fprintln(os.Stderr, "no answer from", fs.Arg(0))
+	os.Exit(1)
+}
+
+func printInfo(fields []string, asJSON bool) {
+	info := map[string]string{}
+	for _, f := range fields {
+		if i := strings.IndexByte(f, '='); i > 0 {
+			info[f[:i]] = f[i+1:]
+		}
+	}
+	if asJSON {
+		b, err := json.MarshalIndent(info, "", "  ")
+		ep(err)
+		fmt.Println(string(b))
+		return
+	}
+	for _, f := range fields {
+		fmt.Println(strings.Replace(f, "=", ": ", 1))
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// queueRefresh is how often a queued client repeats its start command
+// to keep its place; entries not refreshed for three times that are
+// dropped.
+const queueRefresh = 5 * time.Second
+
+// startQueue holds the start commands of clients waiting for the
+// session of another with -queue, in arrival order. It is only used by
+// the goroutine running sessions.
+type startQueue struct {
+	max     int
+	entries []queuedStart
+}
+
+type queuedStart struct {
+	cmd  *startCmd
+	seen time.Time
+}
+
+// waiting is nil unless -queue is set.
+var waiting *startQueue
+
+func (q *startQueue) len() int {
+	if q == nil {
+		return 0
+	}
+	return len(q.entries)
+}
+
+func (q *startQueue) has(cmd *startCmd) bool {
+	if q == nil {
+		return false
+	}
+	for _, e := range q.entries {
+		if e.cmd.from.String() == cmd.from.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// add queues cmd, or refreshes it if its client is queued, and returns
+// its position counting from 1. It fails if the queue is full.
+func (q *startQueue) add(cmd *startCmd) (int, bool) {
+	if q == nil {
+		return 0, false
+	}
+	q.expire()
+	for i := range q.entries {
+		if q.entries[i].cmd.from.String() == cmd.from.String() {
+			q.entries[i] = queuedStart{cmd, time.Now()}
+			return i + 1, true
+		}
+	}
+	if len(q.entries) >= q.max {
+		return 0, false
+	}
+	q.entries = append(q.entries, queuedStart{cmd, time.Now()})
+	return len(q.entries), true
+}
+
+// pop returns the first client still waiting, or nil.
+func (q *startQueue) pop() *startCmd {
+	if q == nil {
+		return nil
+	}
+	q.expire()
+	if len(q.entries) == 0 {
+		return nil
+	}
+	cmd := q.entries[0].cmd
+	q.entries = q.entries[1:]
+	return cmd
+}
+
+func (q *startQueue) expire() {
+	live := q.entries[:0]
+	for _, e := range q.entries {
+		if time.Since(e.seen) < 3*queueRefresh {
+			live = append(live, e)
+		}
+	}
+	q.entries = live
+}
+
+// answerStart queues cmd, telling its client the position and the
+// expected wait, left for the running session and per for each one
+// ahead. Without room the client is told to retry when the running
+// session is over, beyond -max-clients it is refused.
+func answerStart(con net.PacketConn, cmd *startCmd, left, per time.Duration) {
+	if maxClients > 0 && !waiting.has(cmd) && 1+waiting.len() >= maxClients {
+		refuseWith(con, cmd, fmt.Sprintf("max-clients %d reached", maxClients))
+		return
+	}
+	msg := ctlPacket("busy", left.Milliseconds())
+	if pos, ok := waiting.add(cmd); ok {
+		msg = ctlPacket("queued", pos, (left + time.Duration(pos-1)*per).Milliseconds())
+	}
+	ep(replyTo(con, msg, cmd.from, cmd.local))
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// quotaSpec limits the bytes served to a client ip per period, given
+// like 10GB/day.
+type quotaSpec struct {
+	bytes int64
+	per   time.Duration
+	text  string
+}
+
+var quota quotaSpec
+
+// quotaStep is how much a session serves between charging it to the
+// client, so a single long test can not run past its -quota.
+const quotaStep = 1 << 20
+
+// usageIdle is how long a client ip is remembered after it was last
+// served if its period does not end it first.
+const usageIdle = 24 * time.Hour
+
+var quotaUnits = []struct {
+	suffix string
+	n      int64
+}{{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3}, {"B", 1}}
+
+var quotaPeriods = map[string]time.Duration{
+	"hour": time.Hour, "day": 24 * time.Hour, "week": 7 * 24 * time.Hour,
+}
+
+func (q *quotaSpec) String() string {
+	return q.text
+}
+
+func (q *quotaSpec) Set(s string) error {
+	i := strings.IndexByte(s, '/')
+	if i < 0 {
+		return fmt.Errorf("quota should be like 10GB/day")
+	}
+	amount, period := strings.ToUpper(s[:i]), s[i+1:]
+	per, ok := quotaPeriods[period]
+	if !ok {
+		d, err := time.ParseDuration(period)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("quota period should be hour, day, week or a duration")
+		}
+		per = d
+	}
+	for _, u := range quotaUnits {
+		if strings.HasSuffix(amount, u.suffix) {
+			v, err := strconv.ParseFloat(strings.TrimSuffix(amount, u.suffix), 64)
+			if err != nil || v <= 0 {
+				return fmt.Errorf("bad quota amount: %s", s[:i])
+			}
+			*q = quotaSpec{bytes: int64(v * float64(u.n)), per: per, text: s}
+			return nil
+		}
+	}
+	return fmt.Errorf("quota amount needs a unit, B, KB, MB, GB or TB")
+}
+
+// clientUsage is what a client ip was served since the start of its
+// period.
+type clientUsage struct {
+	since time.Time
+	last  time.Time
+	bytes int64
+}
+
+// accounting adds up the bytes a long running server received from
+// and sent to each client ip.
+type accounting struct {
+	mu sync.Mutex
+	m  map[string]*clientUsage
+	// pruned is when ended periods and idle clients were last dropped
+	pruned time.Time
+}
+
+var served = accounting{m: map[string]*clientUsage{}}
+
+func clientIP(a net.Addr) string {
+	if ua, ok := a.(*net.UDPAddr); ok {
+		return ua.IP.String()
+	}
+	return a.String()
+}
+
+// get returns the usage of ip in the current period.
+func (a *accounting) get(ip string, now time.Time) *clientUsage {
+	u := a.m[ip]
+	if u == nil || quota.per > 0 && now.Sub(u.since) >= quota.per {
+		u = &clientUsage{since: now}
+		a.m[ip] = u
+	}
+	return u
+}
+
+// charge adds n bytes served to client and returns its total in the
+// period.
+func (a *accounting) charge(client net.Addr, n int64) int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	a.prune(now)
+	u := a.get(clientIP(client), now)
+	u.bytes += n
+	u.last = now
+	return u.bytes
+}
+
+// prune drops the clients whose period ended or that were not served
+// for usageIdle, at most once a minute, so peers can not grow a long
+// running server's memory.
+func (a *accounting) prune(now time.Time) {
+	if now.Sub(a.pruned) < time.Minute {
+		return
+	}
+	a.pruned = now
+	for ip, u := range a.m {
+		if quota.per > 0 && now.Sub(u.since) >= quota.per || now.Sub(u.last) >= usageIdle {
+			delete(a.m, ip)
+		}
+	}
+}
+
+// exceeded reports whether client used up -quota and when its period
+// ends.
+func (a *accounting) exceeded(client net.Addr) (time.Duration, bool) {
+	if quota.bytes == 0 {
+		return 0, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	u := a.get(clientIP(client), now)
+	return u.since.Add(quota.per).Sub(now), u.bytes >= quota.bytes
+}
+
+// report prints and emits the n bytes a session served client, of
+// which uncharged were not charged during the session.
+func (a *accounting) report(client net.Addr, n, uncharged int64) {
+	total := a.charge(client, uncharged)
+	s := fmt.Sprintf("served %s to %s, %s in total", byteString(n), clientIP(client), byteString(total))
+	if quota.bytes > 0 {
+		s = fmt.Sprintf("served %s to %s, %s of %s", byteString(n), clientIP(client), byteString(total), quota.text)
+	}
+	fmt.Println(s)
+	emit("served", map[string]interface{}{"client": clientIP(client), "bytes": n, "period_bytes": total})
+}
+
+func byteString(n int64) string {
+	for _, u := range quotaUnits {
+		if n >= u.n && u.n > 1 {
+			return fmt.Sprintf("%.2f%s", float64(n)/float64(u.n), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
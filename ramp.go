@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// rampTick is the resolution of -ramp profiles.
+const rampTick = 10 * time.Millisecond
+
+// rampSeg is a step of a -ramp or -replay profile, the rate going
+// linearly from from to to bit/s over dur in datagrams of size bytes,
+// -p if zero.
+type rampSeg struct {
+	from, to float64
+	dur      time.Duration
+	size     int
+	label    string
+}
+
+// rampProfile is the -ramp flag: comma separated steps of
+// <from>-><to>:<duration>, <rate>:<duration> or hold:<duration>,
+// holding the rate the step before ended with.
+type rampProfile struct {
+	spec string
+	segs []rampSeg
+	// cum holds the packets sent by every rampTick
+	cum []float64
+	// replay is set for -replay schedules, their steps have their
+	// own packet size
+	replay bool
+}
+
+var ramp rampProfile
+
+func (r *rampProfile) String() string {
+	return r.spec
+}
+
+func (r *rampProfile) Set(s string) error {
+	r.spec, r.segs = s, nil
+	prev := 0.0
+	for _, step := range strings.Split(s, ",") {
+		i := strings.LastIndexByte(step, ':')
+		if i < 0 {
+			return fmt.Errorf("ramp step %q lacks a duration", step)
+		}
+		d, err := time.ParseDuration(step[i+1:])
+		if err != nil || d <= 0 {
+			return fmt.Errorf("bad duration in ramp step %q", step)
+		}
+		seg := rampSeg{from: prev, to: prev, dur: d, label: step[:i]}
+		if rates := step[:i]; rates != "hold" {
+			from, to := rates, rates
+			if j := strings.Index(rates, "->"); j >= 0 {
+				from, to = rates[:j], rates[j+2:]
+			}
+			if seg.from, err = parseBitRate(from); err != nil {
+				return err
+			}
+			if seg.to, err = parseBitRate(to); err != nil {
+				return err
+			}
+		}
+		prev = seg.to
+		r.segs = append(r.segs, seg)
+	}
+	return nil
+}
+
+func (r *rampProfile) peak() float64 {
+	peak := 0.0
+	for _, s := range r.segs {
+		if s.from > peak {
+			peak = s.from
+		}
+		if s.to > peak {
+			peak = s.to
+		}
+	}
+	return peak
+}
+
+// at returns the step and rate at t into the profile, or -1 past its
+// end.
+func (r *rampProfile) at(t time.Duration) (int, float64) {
+	for i, s := range r.segs {
+		if t < s.dur {
+			return i, s.from + (s.to-s.from)*float64(t)/float64(s.dur)
+		}
+		t -= s.dur
+	}
+	return -1, 0
+}
+
+// packets returns how many packets the profile sends.
+func (r *rampProfile) packets() int {
+	r.cum = []float64{0}
+	n := 0.0
+	for t := time.Duration(0); ; t += rampTick {
+		i, rate := r.at(t)
+		if i < 0 {
+			break
+		}
+		n += rate * rampTick.Seconds() / float64(r.size(i)*8)
+		r.cum = append(r.cum, n)
+	}
+	return int(n)
+}
+
+func (r *rampProfile) size(i int) int {
+	if r.segs[i].size > 0 {
+		return r.segs[i].size
+	}
+	return pktSize
+}
+
+// timeOf returns when into the profile packet k, counting from 0, is
+// sent.
+func (r *rampProfile) timeOf(k int) time.Duration {
+	i := sort.SearchFloat64s(r.cum, float64(k))
+	if i == 0 {
+		return 0
+	}
+	if i == len(r.cum) {
+		i--
+	}
+	part := (float64(k) - r.cum[i-1]) / (r.cum[i] - r.cum[i-1])
+	return time.Duration((float64(i-1) + part) * float64(rampTick))
+}
+
+// sizeOf returns the datagram size of packet no.
+func (r *rampProfile) sizeOf(no uint16) int {
+	i, _ := r.at(r.timeOf(int(no) - 1))
+	if i < 0 {
+		i = len(r.segs) - 1
+	}
+	return r.size(i)
+}
+
+func (r *rampProfile) kind() string {
+	if r.replay {
+		return "replay"
+	}
+	return "ramp"
+}
+
+// sentNo is the latest packet number sent, for marks.
+var sentNo int64
+
+// run marks the start of every step from t0 on the server so it
+// breaks its counts down by them, the pacers follow the profile with
+// timeOf.
+func (r *rampProfile) run(t0 time.Time, con net.Conn) {
+	at := t0
+	for i, s := range r.segs {
+		time.Sleep(time.Until(at))
+		if isAborted() {
+			return
+		}
+		fmt.Printf("%s: step %d %s for %v\n", r.kind(), i+1, s.label, s.dur)
+		no := atomic.LoadInt64(&sentNo) + 1
+		if i == 0 {
+			no = 1
+		}
+		sendMark(con, no, fmt.Sprintf("%s %d %s", r.kind(), i+1, s.label))
+		at = at.Add(s.dur)
+	}
+}
+
+// sendMark tells the server a labeled section starts with packet no,
+// twice as it is not acknowledged.
+func sendMark(con net.Conn, no int64, label string) {
+	m := ctlPacket("mark", no, label)
+	_, _ = con.Write(m)
+	_, _ = con.Write(m)
+}
+
+// mark is a labeled section of a test starting with packet no.
+type mark struct {
+	no    int
+	label string
+}
+
+// addMark handles "mark <no> <label>" from the client. Of marks for
+// the same packet the latest holds, the sections before were empty.
+func addMark(marks []mark, args []string) []mark {
+	if len(args) < 2 {
+		return marks
+	}
+	no, err := strconv.Atoi(args[0])
+	if err != nil || no < 1 {
+		return marks
+	}
+	label := strings.Join(args[1:], " ")
+	for i := range marks {
+		if marks[i].no == no {
+			marks[i].label = label
+			return marks
+		}
+	}
+	return append(marks, mark{no, label})
+}
+
+// segments breaks the received packets of a test with count packets
+// down by the marks.
+func segments(marks []mark, seen []bool, count int) []segment {
+	sort.Slice(marks, func(i, j int) bool { return marks[i].no < marks[j].no })
+	var res []segment
+	for i, m := range marks {
+		last := count
+		if i+1 < len(marks) {
+			last = marks[i+1].no - 1
+		}
+		if m.no > last {
+			continue
+		}
+		s := segment{Label: m.label, First: m.no, Last: last}
+		for no := m.no; no <= last; no++ {
+			if seen[no] {
+				s.Received++
+			}
+		}
+		n := last - m.no + 1
+		s.LossPct = float64(n-s.Received) / float64(n) * 100
+		res = append(res, s)
+	}
+	return res
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// paceScale multiplies the interval of every pacer, in 1/65536, so the
+// send rate can be changed during a test from the keyboard or -control.
+var paceScale int64 = 1 << 16
+
+// rateStep is the change of one + or - key.
+const rateStep = 1.25
+
+func scaled(d time.Duration) time.Duration {
+	return time.Duration(int64(d) * atomic.LoadInt64(&paceScale) >> 16)
+}
+
+var (
+	rateMu      sync.Mutex
+	rateT0      time.Time
+	rateChanges []rateChange
+)
+
+// currentRate is the send rate in bit/s the pacers follow now.
+func currentRate() float64 {
+	return float64(sendRate()) * (1 << 16) / float64(atomic.LoadInt64(&paceScale))
+}
+
+// changeRate multiplies the send rate by factor and notes the change
+// in the output, the trace and the events.
+func changeRate(factor float64, via string) error {
+	if sendInterval <= 0 {
+		return fmt.Errorf("rate changes need a paced test, see -i")
+	}
+	rateMu.Lock()
+	defer rateMu.Unlock()
+	scale := int64(float64(atomic.LoadInt64(&paceScale)) / factor)
+	if scale < 1<<10 || scale > 1<<22 {
+		return fmt.Errorf("rate limited to 1/64 to 64 times the one given")
+	}
+	atomic.StoreInt64(&paceScale, scale)
+	c := rateChange{Offset: time.Since(rateT0).Seconds(), Mbps: currentRate() / 1e6, Via: via}
+	rateChanges = append(rateChanges, c)
+	fmt.Printf("rate: %.2f Mbit/s at +%.1fs (%s)\n", c.Mbps, c.Offset, via)
+	trc.comment(fmt.Sprintf("rate %d bit/s via %s", int64(currentRate()), via))
+	emit("rate", map[string]interface{}{"mbps": c.Mbps, "offset_s": c.Offset, "via": via})
+	return nil
+}
+
+// rateKey handles the keys changing the rate in -interactive mode.
+func rateKey(k byte) {
+	var err error
+	switch k {
+	case '+', '=':
+		err = changeRate(rateStep, "key +")
+	case '-':
+		err = changeRate(1/rateStep, "key -")
+	}
+	if err != nil {
+		fmt.Println("rate:", err)
+	}
+}
+
+// startControl serves the client's http control api on controlAddr:
+// POST /rate with mbps=<rate> or factor=<multiplier> changes the send
+// rate, POST /marker with label=<text> adds a marker.
+func startControl() {
+	if controlAddr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		factor, err := strconv.ParseFloat(r.FormValue("factor"), 64)
+		if mbps := r.FormValue("mbps"); mbps != "" {
+			var v float64
+			v, err = strconv.ParseFloat(mbps, 64)
+			factor = v * 1e6 / currentRate()
+		}
+		if err == nil && factor > 0 {
+			err = changeRate(factor, "api")
+		} else if err == nil {
+			err = fmt.Errorf("factor or mbps should be positive")
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "%.2f\n", currentRate()/1e6)
+	})
+	mux.HandleFunc("/marker", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := addMarker(r.FormValue("label"), "api"); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	l, err := net.Listen("tcp", controlAddr)
+	ep(err)
+	go func() {
+		ep(http.Serve(l, mux))
+	}()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// rawSpec is the -unsafe-raw flag: data packets leave through a raw
+// socket with an ip header of our making.
+type rawSpec struct {
+	spec string
+	on   bool
+	src  net.IP
+	ttl  int
+	df   bool
+	// id is the fixed ip id, incremented per packet if idInc
+	id    uint16
+	idInc bool
+}
+
+var (
+	unsafeRaw = rawSpec{ttl: 64, df: true, idInc: true}
+	// zeroCsum sends even packets without udp checksum
+	zeroCsum bool
+)
+
+func (r *rawSpec) String() string {
+	return r.spec
+}
+
+// Set parses comma separated src=<ipv4>, ttl=<n>, df=0|1 and
+// id=<n>|inc, an empty value keeps the defaults.
+func (r *rawSpec) Set(v string) error {
+	r.spec, r.on = v, true
+	if v == "" || v == "true" {
+		return nil
+	}
+	for _, kv := range strings.Split(v, ",") {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			return fmt.Errorf("expected key=value in %q", kv)
+		}
+		k, val := kv[:i], kv[i+1:]
+		switch k {
+		case "src":
+			if r.src = net.ParseIP(val).To4(); r.src == nil {
+				return fmt.Errorf("raw src %q is no ipv4 address", val)
+			}
+		case "ttl":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 || n > 255 {
+				return fmt.Errorf("raw ttl should be 1 to 255")
+			}
+			r.ttl = n
+		case "df":
+			if val != "0" && val != "1" {
+				return fmt.Errorf("raw df should be 0 or 1")
+			}
+			r.df = val == "1"
+		case "id":
+			r.idInc = val == "inc"
+			if !r.idInc {
+				n, err := strconv.ParseUint(val, 10, 16)
+				if err != nil || n == 0 {
+					// the kernel fills in a zero id
+					return fmt.Errorf("raw id should be 1 to 65535 or inc")
+				}
+				r.id = uint16(n)
+			}
+		default:
+			return fmt.Errorf("unknown raw key %q", k)
+		}
+	}
+	return nil
+}
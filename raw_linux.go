@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// rawConn sends through a raw socket with the ip and udp headers made
+// here, reads come from the udp socket it wraps. Control packets are
+// written from other goroutines than the data, mu guards buf and id.
+type rawConn struct {
+	*net.UDPConn
+	mu       sync.Mutex
+	fd       int
+	src, dst *net.UDPAddr
+	id       uint16
+	buf      []byte
+}
+
+func withRaw(con net.Conn) (net.Conn, error) {
+	uc, ok := con.(*net.UDPConn)
+	if !ok {
+		return nil, errors.New("needs a plain udp socket")
+	}
+	src, dst := *uc.LocalAddr().(*net.UDPAddr), *uc.RemoteAddr().(*net.UDPAddr)
+	if dst.IP.To4() == nil {
+		return nil, errors.New("ipv4 only")
+	}
+	if unsafeRaw.src != nil {
+		src.IP = unsafeRaw.src
+	}
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_RAW)
+	if err != nil {
+		return nil, err
+	}
+	c := &rawConn{UDPConn: uc, fd: fd, src: &src, dst: &dst, id: unsafeRaw.id, buf: make([]byte, 28+pktMaxSize)}
+	if unsafeRaw.idInc {
+		c.id = uint16(rand.Intn(1 << 16))
+	}
+	return c, nil
+}
+
+func (c *rawConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.buf[:28+len(b)]
+	ip, udp := p[:20], p[20:28]
+	binary.BigEndian.PutUint16(udp, uint16(c.src.Port))
+	binary.BigEndian.PutUint16(udp[2:], uint16(c.dst.Port))
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(b)))
+	binary.BigEndian.PutUint16(udp[6:], 0)
+	copy(p[28:], b)
+	// the pseudo header takes the place of the ip header's tail
+	copy(ip[8:], c.src.IP.To4())
+	copy(ip[12:], c.dst.IP.To4())
+	ip[16], ip[17] = 0, syscall.IPPROTO_UDP
+	binary.BigEndian.PutUint16(ip[18:], uint16(8+len(b)))
+	cs := ipChecksum(p[8:])
+	if cs == 0 {
+		// zero means none
+		cs = 0xffff
+	}
+	if no := binary.LittleEndian.Uint16(b); zeroCsum && no != 0 && no%2 == 0 {
+		cs = 0
+	}
+	binary.BigEndian.PutUint16(udp[6:], cs)
+	ip[0], ip[1] = 0x45, 0
+	binary.BigEndian.PutUint16(ip[2:], uint16(len(p)))
+	binary.BigEndian.PutUint16(ip[4:], c.id)
+	if unsafeRaw.idInc {
+		if c.id++; c.id == 0 {
+			c.id = 1
+		}
+	}
+	binary.BigEndian.PutUint16(ip[6:], 0)
+	if unsafeRaw.df {
+		ip[6] = 0x40
+	}
+	ip[8], ip[9] = byte(unsafeRaw.ttl), syscall.IPPROTO_UDP
+	binary.BigEndian.PutUint16(ip[10:], 0) // filled in by the kernel
+	copy(ip[12:], c.src.IP.To4())
+	copy(ip[16:], c.dst.IP.To4())
+	to := &syscall.SockaddrInet4{}
+	copy(to.Addr[:], c.dst.IP.To4())
+	if err := syscall.Sendto(c.fd, p, 0, to); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *rawConn) Close() error {
+	syscall.Close(c.fd)
+	return c.UDPConn.Close()
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+func init() {
+	unavailable["raw"] = "linux only"
+}
+
+func withRaw(con net.Conn) (net.Conn, error) {
+	return nil, errors.New("raw sockets are linux only")
+}
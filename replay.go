@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// replayRow is a row of a -replay schedule: from Time seconds into the
+// test on the rate is Rate bit/s in datagrams of Size bytes, until the
+// next row. The last row ends the schedule.
+type replayRow struct {
+	Time float64     `json:"time"`
+	Rate interface{} `json:"rate"`
+	Size int         `json:"size"`
+}
+
+// loadReplay reads a schedule of rows from a json array or a csv file
+// with time,rate,size columns into ramp.
+func loadReplay(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var rows []replayRow
+	if strings.HasSuffix(path, ".json") {
+		err = json.NewDecoder(f).Decode(&rows)
+	} else {
+		rows, err = readReplayCSV(f)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if len(rows) < 2 {
+		return fmt.Errorf("%s: a schedule needs at least two rows, the last one ends it", path)
+	}
+	ramp = rampProfile{spec: path, replay: true}
+	for i, row := range rows[:len(rows)-1] {
+		var rate float64
+		switch v := row.Rate.(type) {
+		case float64:
+			rate = v
+		case string:
+			if rate, err = parseBitRate(v); err != nil {
+				return fmt.Errorf("%s row %d: %v", path, i+1, err)
+			}
+		default:
+			return fmt.Errorf("%s row %d: bad rate %v", path, i+1, row.Rate)
+		}
+		d := time.Duration((rows[i+1].Time - row.Time) * float64(time.Second))
+		if d <= 0 || row.Size != 0 && (row.Size < pktInfSize || row.Size > pktMaxSize) {
+			return fmt.Errorf("%s row %d: times should increase and sizes be between %d and %d", path, i+1, pktInfSize, pktMaxSize)
+		}
+		label := formatBitRate(rate)
+		if row.Size > 0 {
+			label += fmt.Sprintf(" %d bytes", row.Size)
+		}
+		ramp.segs = append(ramp.segs, rampSeg{from: rate, to: rate, dur: d, size: row.Size, label: label})
+	}
+	return nil
+}
+
+// readReplayCSV reads time,rate,size rows, skipping a header and
+// comment lines starting with #.
+func readReplayCSV(r io.Reader) ([]replayRow, error) {
+	c := csv.NewReader(r)
+	c.Comment = '#'
+	c.FieldsPerRecord = -1
+	var rows []replayRow
+	for {
+		rec, err := c.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		t, err := strconv.ParseFloat(strings.TrimSpace(rec[0]), 64)
+		if err != nil {
+			if len(rows) == 0 {
+				// the header
+				continue
+			}
+			return nil, fmt.Errorf("bad time %q", rec[0])
+		}
+		row := replayRow{Time: t}
+		if len(rec) > 1 {
+			row.Rate = strings.TrimSpace(rec[1])
+		}
+		if len(rec) > 2 && strings.TrimSpace(rec[2]) != "" {
+			if row.Size, err = strconv.Atoi(strings.TrimSpace(rec[2])); err != nil {
+				return nil, fmt.Errorf("bad size %q", rec[2])
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+// largest returns the largest datagram of the steps.
+func (r *rampProfile) largest() int {
+	max := 0
+	for _, s := range r.segs {
+		if s.size > max {
+			max = s.size
+		}
+	}
+	return max
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// anyAddress reports whether the server listens on every address, in
+// which case it answers from the address each client sent to: the
+// kernel would pick one by route, which on a multihomed host may not
+// be the one a client's connected socket accepts.
+func anyAddress() bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return host == "" || ip != nil && ip.IsUnspecified()
+}
+
+// replyTo sends b to the peer to, from local if it is known.
+func replyTo(con net.PacketConn, b []byte, to net.Addr, local net.IP) error {
+	if uc := udpConn(con); uc != nil && local != nil {
+		if oob := pktinfo(local); oob != nil {
+			_, _, err := uc.WriteMsgUDP(b, oob, to.(*net.UDPAddr))
+			return err
+		}
+	}
+	_, err := con.WriteTo(b, to)
+	return err
+}
+
+func udpConn(con net.PacketConn) *net.UDPConn {
+	switch c := con.(type) {
+	case *net.UDPConn:
+		return c
+	case *queuedConn:
+		return udpConn(c.PacketConn)
+	}
+	return nil
+}
+
+// seenAs is the client address the server reported with seen.
+var seenAs string
+
+// noteSeen reports a nat between client and server when the address
+// the server sees differs from the local one of con.
+func noteSeen(local net.Addr, args []string) {
+	if len(args) == 0 || seenAs != "" {
+		return
+	}
+	seenAs = args[0]
+	l, ok := local.(*net.UDPAddr)
+	s, err := net.ResolveUDPAddr("udp", seenAs)
+	if !ok || err != nil || l.IP.Equal(s.IP) && l.Port == s.Port {
+		return
+	}
+	note := "address translated"
+	if l.Port != s.Port {
+		note = "port translated: replies pass only while the mapping lives, a symmetric nat gives every destination another port"
+	}
+	fmt.Printf("nat: %s is seen by the server as %s (%s)\n", l, s, note)
+}
+
+// diagnoseNoReplies explains why a two way mode received nothing.
+func diagnoseNoReplies() {
+	if seenAs == "" {
+		fmt.Println("nothing came back from the server: a firewall drops its packets, or it answers from another address or port (a nat in front of it, a multihomed host) which the connected socket drops")
+		return
+	}
+	fmt.Println("the server's control packets arrive but no replies: the path drops them, check -reply-size against the mtu")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	resultpkg "github.com/dinalt/udptest/result"
+)
+
+// The result types are in the result package so other programs can
+// read what -json writes.
+type (
+	result         = resultpkg.Result
+	rttStats       = resultpkg.RTTStats
+	owdStats       = resultpkg.OWDStats
+	bottleneck     = resultpkg.Bottleneck
+	gameStats      = resultpkg.GameStats
+	videoStats     = resultpkg.VideoStats
+	outageStats    = resultpkg.OutageStats
+	driftStats     = resultpkg.DriftStats
+	failovers      = resultpkg.Failovers
+	convergence    = resultpkg.Convergence
+	hopStats       = resultpkg.HopStats
+	kernelDrops    = resultpkg.KernelDrops
+	pathStats      = resultpkg.PathStats
+	envInfo        = resultpkg.EnvInfo
+	dnsInfo        = resultpkg.DNSInfo
+	anomaly        = resultpkg.Anomaly
+	segment        = resultpkg.Segment
+	rateChange     = resultpkg.RateChange
+	marker         = resultpkg.Marker
+	aimdResult     = resultpkg.AIMDResult
+	adaptiveSample = resultpkg.AdaptiveSample
+	labels         = resultpkg.Labels
+)
+
+func newResult(role string, start time.Time) *result {
+	r := &result{
+		Schema:     resultpkg.Schema,
+		Role:       role,
+		TestID:     testID,
+		Start:      start,
+		DurationMs: ms(time.Since(start)),
+		PacketSize: pktSize,
+		Count:      pktCount,
+		Labels:     runLabels,
+		Env:        runEnv,
+		DNS:        resolved,
+	}
+	if tunnel.Name != "" {
+		r.Overhead = (*resultpkg.Overhead)(&tunnel)
+	}
+	if payloadMode != "random" {
+		r.Payload = payloadMode
+	}
+	return r
+}
+
+func setLoss(r *result, expected, received int) {
+	loss := 0.0
+	if expected > 0 {
+		loss = float64(expected-received) / float64(expected) * 100
+	}
+	r.LossPct = &loss
+}
+
+func setThroughput(r *result, packets int) {
+	if r.DurationMs > 0 {
+		r.ThroughputMbps = float64(packets*(r.PacketSize-pktInfSize)*8) / (r.DurationMs * 1000)
+	}
+}
+
+func setJitter(r *result, d time.Duration) {
+	j := ms(d)
+	r.JitterMs = &j
+}
+
+// publish writes r to every output requested by flags.
+func publish(r *result) {
+	writeJSON(jsonPath, r)
+	slog.keep(r)
+	writeInflux(influxPath, r)
+	writeOpenMetrics(openMetricsPath, r)
+	postResult(r, collectorURL)
+	emit("complete", map[string]interface{}{"result": r})
+}
+
+func writeJSON(path string, v interface{}) {
+	if path == "" {
+		return
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	ep(err)
+	ep(os.WriteFile(path, append(b, '\n'), 0o644))
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// jitter accumulates the mean absolute difference between consecutive
+// samples (ipdv).
+type jitter struct {
+	prev time.Duration
+	n    int
+	sum  time.Duration
+}
+
+func (j *jitter) add(d time.Duration) {
+	if j.n > 0 {
+		diff := d - j.prev
+		if diff < 0 {
+			diff = -diff
+		}
+		j.sum += diff
+	}
+	j.prev = d
+	j.n++
+}
+
+func (j *jitter) mean() time.Duration {
+	if j.n < 2 {
+		return 0
+	}
+	return j.sum / time.Duration(j.n-1)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	reresolveEvery time.Duration
+	followDNS      bool
+	// target is addr as given, before pickAddr replaced the host with
+	// one of its addresses.
+	target string
+)
+
+// dnsWatch re-resolves the target host of a long running client and
+// tells when its addresses change.
+type dnsWatch struct {
+	host, port string
+	ips        []string
+	next       time.Time
+}
+
+// newDNSWatch returns nil without -reresolve or if the target is an
+// address already.
+func newDNSWatch() *dnsWatch {
+	if reresolveEvery == 0 {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(target)
+	ep(err)
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	ua, err := net.ResolveUDPAddr("udp", addr)
+	ep(err)
+	w := &dnsWatch{host: host, port: port, next: time.Now().Add(reresolveEvery)}
+	w.ips, _ = w.lookup()
+	if len(w.ips) == 0 {
+		w.ips = []string{ua.IP.String()}
+	}
+	return w
+}
+
+func (w *dnsWatch) lookup() ([]string, error) {
+	ips, err := net.LookupIP(w.host)
+	if err != nil {
+		return nil, err
+	}
+	s := make([]string, len(ips))
+	for i, ip := range interleave(ips) {
+		s[i] = ip.String()
+	}
+	return s, nil
+}
+
+// check re-resolves the host if it is time to. On a change it notes
+// it in the trace and events and, with -follow-dns, returns the
+// address to reconnect to if the one in use, cur, is gone.
+func (w *dnsWatch) check(cur net.Addr) string {
+	if w == nil || time.Now().Before(w.next) {
+		return ""
+	}
+	w.next = time.Now().Add(reresolveEvery)
+	ips, err := w.lookup()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "re-resolving:", err)
+		return ""
+	}
+	if sameSet(ips, w.ips) {
+		return ""
+	}
+	fmt.Fprintf(os.Stderr, "%s now resolves to %s, was %s\n", w.host, strings.Join(ips, ", "), strings.Join(w.ips, ", "))
+	trc.comment(fmt.Sprintf("dns %s at %d", strings.Join(ips, ","), time.Now().UnixNano()))
+	emit("dns_change", map[string]interface{}{"host": w.host, "addresses": ips, "previous": w.ips})
+	w.ips = ips
+	if !followDNS {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(cur.String())
+	ep(err)
+	for _, ip := range ips {
+		if net.ParseIP(ip).Equal(net.ParseIP(host)) {
+			return ""
+		}
+	}
+	return net.JoinHostPort(ips[0], w.port)
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// respond runs -responder: the subset of the server a microcontroller
+// can mirror, with one buffer and two counters of state and no
+// summary.
+//
+//   - start resets the counters, nothing is sent back
+//   - sync requests are answered like the full server does
+//   - data packets are reflected unchanged, so -echo measures rtt
+//   - stat <received> <highest no> goes back at most every statInterval
+//   - other control packets and malformed datagrams are ignored
+func respond() {
+	lc := net.ListenConfig{Control: listenControl}
+	con, err := lc.ListenPacket(context.Background(), "udp", addr)
+	ep(err)
+	defer con.Close()
+	fmt.Printf("responding on %s\n", con.LocalAddr())
+	announce(con.LocalAddr(), "responder")
+	startAdvertise(con.LocalAddr())
+	var (
+		pkt      = paket{buf: make([]byte, pktMaxSize)}
+		received int
+		highest  uint16
+		nextStat time.Time
+	)
+	if anyAddress() {
+		// replies leave from the address each client sent to
+		pkt.oob = make([]byte, 128)
+	}
+	for {
+		pkt.reset()
+		n, from, err := pkt.readMsg(con)
+		ep(err)
+		b := pkt.buf[:n]
+		if bytes.HasPrefix(b, start) {
+			received, highest = 0, 0
+			continue
+		}
+		if replySync(con, b, from, pkt.dst, time.Now()) {
+			continue
+		}
+		no, _, err := Decode(b)
+		if err != nil || no == 0 {
+			continue
+		}
+		received++
+		if no > highest {
+			highest = no
+		}
+		ep(replyTo(con, b, from, pkt.dst))
+		if now := time.Now(); now.After(nextStat) {
+			ep(replyTo(con, ctlPacket("stat", received, highest), from, pkt.dst))
+			nextStat = now.Add(statInterval)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package result
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Schema is the version of the result layout. Adding a field keeps
+// it, renaming, removing or changing the meaning of one raises it with
+// an entry in upgrades so older results still parse.
+//
+//	1  unversioned results
+//	2  schema field
+const Schema = 2
+
+// upgrades lifts a decoded result of the version it is keyed by to the
+// next one.
+var upgrades = map[int]func(m map[string]interface{}){
+	1: func(m map[string]interface{}) {},
+}
+
+// Parse decodes a result of this or an older schema. Newer ones are
+// refused, they changed something this version would misread.
+func Parse(b []byte) (*Result, error) {
+	var v struct {
+		Schema int `json:"schema"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	if v.Schema == 0 {
+		v.Schema = 1
+	}
+	if v.Schema > Schema {
+		return nil, fmt.Errorf("result schema %d is newer than %d of this udptest", v.Schema, Schema)
+	}
+	if v.Schema < Schema {
+		var m map[string]interface{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, err
+		}
+		for s := v.Schema; s < Schema; s++ {
+			upgrades[s](m)
+		}
+		m["schema"] = Schema
+		var err error
+		if b, err = json.Marshal(m); err != nil {
+			return nil, err
+		}
+	}
+	var r Result
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
@@ -0,0 +1,45 @@
+package result
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		err  string
+	}{
+		{"schema 1", `{"role":"server","count":100,"received":98,"loss_pct":2,"rtt_ms":{"min":1,"avg":2,"median":2,"max":3}}`, ""},
+		{"schema 2", `{"schema":2,"role":"server","count":100,"received":98,"loss_pct":2,"rtt_ms":{"min":1,"avg":2,"median":2,"max":3}}`, ""},
+		{"newer schema", `{"schema":3,"role":"server"}`, "newer"},
+		{"not json", `{"schema":`, "unexpected end"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Parse([]byte(tt.doc))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Schema != Schema {
+				t.Errorf("schema %d, want %d", r.Schema, Schema)
+			}
+			if r.Role != "server" || r.Count != 100 || r.Received != 98 {
+				t.Errorf("fields lost: %+v", r)
+			}
+			if r.LossPct == nil || *r.LossPct != 2 {
+				t.Errorf("loss_pct %v, want 2", r.LossPct)
+			}
+			if r.RTT == nil || r.RTT.Median != 2 {
+				t.Errorf("rtt_ms %+v, want median 2", r.RTT)
+			}
+		})
+	}
+}
@@ -0,0 +1,432 @@
+// Package result holds the summary udptest writes with -json and its
+// collector stores, for programs reading them.
+package result
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// GameSpike is the rtt players notice.
+	GameSpike = 100 * time.Millisecond
+	// outageTime is how outage timestamps are printed
+	outageTime = "15:04:05.000000"
+)
+
+// Result is the machine readable summary of a run written with -json.
+// Loss and jitter are only set by the side that can measure them.
+type Result struct {
+	Schema     int       `json:"schema"`
+	Role       string    `json:"role"`
+	TestID     string    `json:"test_id,omitempty"`
+	Start      time.Time `json:"start"`
+	DurationMs float64   `json:"duration_ms"`
+	PacketSize int       `json:"packet_size"`
+	Count      int       `json:"count"`
+	Sent       int       `json:"sent,omitempty"`
+	Received   int       `json:"received"`
+	Duplicates int       `json:"duplicates,omitempty"`
+	Late       int       `json:"late,omitempty"`
+	// DuplicatePayloads counts payloads stored under two packet
+	// numbers (-m only).
+	DuplicatePayloads int `json:"duplicate_payloads,omitempty"`
+	// Lost lists packet numbers and first-last ranges never received.
+	Lost []string `json:"lost,omitempty"`
+	// ThroughputMbps counts payload of received packets, or of sent
+	// ones if the side can not tell what was received.
+	ThroughputMbps float64      `json:"throughput_mbps"`
+	LossPct        *float64     `json:"loss_pct,omitempty"`
+	JitterMs       *float64     `json:"jitter_ms,omitempty"`
+	RTT            *RTTStats    `json:"rtt_ms,omitempty"`
+	OneWay         *OWDStats    `json:"one_way_ms,omitempty"`
+	Bottleneck     *Bottleneck  `json:"bottleneck,omitempty"`
+	Game           *GameStats   `json:"game,omitempty"`
+	Video          *VideoStats  `json:"video,omitempty"`
+	Outage         *OutageStats `json:"outage,omitempty"`
+	ClockDrift     *DriftStats  `json:"clock_drift,omitempty"`
+	Failover       *Failovers   `json:"failover,omitempty"`
+	Hops           *HopStats    `json:"hops,omitempty"`
+	KernelDrops    *KernelDrops `json:"kernel_drops,omitempty"`
+	Paths          []*PathStats `json:"paths,omitempty"`
+	Overhead       *Overhead    `json:"overhead,omitempty"`
+	Payload        string       `json:"payload,omitempty"`
+	Env            *EnvInfo     `json:"env,omitempty"`
+	DNS            *DNSInfo     `json:"dns,omitempty"`
+	Anomalies      []Anomaly    `json:"anomalies,omitempty"`
+	Segments       []Segment    `json:"segments,omitempty"`
+	RateChanges    []RateChange `json:"rate_changes,omitempty"`
+	PausedMs       float64      `json:"paused_ms,omitempty"`
+	Markers        []Marker     `json:"markers,omitempty"`
+	Adaptive       *AIMDResult  `json:"adaptive,omitempty"`
+	Labels         Labels       `json:"labels,omitempty"`
+}
+
+// RTTStats are round trip or one-way delays in milliseconds.
+type RTTStats struct {
+	Min    float64 `json:"min"`
+	Avg    float64 `json:"avg"`
+	Median float64 `json:"median"`
+	Max    float64 `json:"max"`
+}
+
+// OWDStats are the delays of each direction, with -owd.
+type OWDStats struct {
+	Forward *RTTStats `json:"forward"`
+	Reverse *RTTStats `json:"reverse"`
+}
+
+func (s *OWDStats) String() string {
+	return fmt.Sprintf("one-way delay: forward min %.3fms, median %.3fms, max %.3fms; reverse min %.3fms, median %.3fms, max %.3fms",
+		s.Forward.Min, s.Forward.Median, s.Forward.Max, s.Reverse.Min, s.Reverse.Median, s.Reverse.Max)
+}
+
+// Bottleneck is the delivery rate estimate of the path.
+type Bottleneck struct {
+	Mbps     float64 `json:"mbps"`
+	MinRTTMs float64 `json:"min_rtt_ms"`
+	BDPBytes int     `json:"bdp_bytes"`
+	// AppLimited is set if the send rate limited the samples: the
+	// path may carry more than estimated
+	AppLimited bool `json:"app_limited"`
+}
+
+func (b *Bottleneck) String() string {
+	s := fmt.Sprintf("delivery rate: bottleneck %.2f Mbit/s, min rtt %.3fms, bdp %d bytes", b.Mbps, b.MinRTTMs, b.BDPBytes)
+	if b.AppLimited {
+		s += " (app limited, the path may carry more)"
+	}
+	return s
+}
+
+// GameStats is what players feel of a path.
+type GameStats struct {
+	Hz              int     `json:"hz"`
+	LossPct         float64 `json:"loss_pct"`
+	Spikes          int     `json:"rtt_spikes"`
+	LongestOutageMs float64 `json:"longest_outage_ms"`
+	OutagePackets   int     `json:"longest_outage_packets"`
+}
+
+func (g *GameStats) String() string {
+	return fmt.Sprintf("game: %d Hz, loss %.2f%%, rtt spikes over %v: %d, longest outage %.0fms (%d packets)",
+		g.Hz, g.LossPct, GameSpike, g.Spikes, g.LongestOutageMs, g.OutagePackets)
+}
+
+// VideoStats is the per-frame completeness seen by the server.
+type VideoStats struct {
+	Frames        int     `json:"frames"`
+	Complete      int     `json:"complete"`
+	Partial       int     `json:"partial"`
+	Missing       int     `json:"missing"`
+	CompletePct   float64 `json:"complete_pct"`
+	IFramesBroken int     `json:"i_frames_broken"`
+}
+
+func (s *VideoStats) String() string {
+	return fmt.Sprintf("video frames: %d, complete %d (%.2f%%), partial %d, missing %d, broken I-frames %d",
+		s.Frames, s.Complete, s.CompletePct, s.Partial, s.Missing, s.IFramesBroken)
+}
+
+// OutageStats is the availability seen by the server, seconds after
+// the last packet are not counted.
+type OutageStats struct {
+	LongestMs          float64 `json:"longest_ms"`
+	AtMs               float64 `json:"at_ms"`
+	UnavailableSeconds int     `json:"unavailable_seconds"`
+	Seconds            int     `json:"seconds"`
+}
+
+func (s *OutageStats) String() string {
+	return fmt.Sprintf("longest outage: %.1fms at +%.3fs, unavailable seconds: %d of %d",
+		s.LongestMs, s.AtMs/1000, s.UnavailableSeconds, s.Seconds)
+}
+
+// DriftStats is how the clocks of client and server drift apart.
+type DriftStats struct {
+	Samples       int     `json:"samples"`
+	PPM           float64 `json:"ppm"`
+	OffsetStartMs float64 `json:"offset_start_ms"`
+	OffsetEndMs   float64 `json:"offset_end_ms"`
+}
+
+func (s *DriftStats) String() string {
+	return fmt.Sprintf("clock drift: %+.2fppm over %d samples, offset %.3fms to %.3fms",
+		s.PPM, s.Samples, s.OffsetStartMs, s.OffsetEndMs)
+}
+
+// Failovers are the outages of the failover mode.
+type Failovers struct {
+	ConvergenceMs float64       `json:"convergence_ms"`
+	Recovered     bool          `json:"recovered"`
+	Outages       []Convergence `json:"outages"`
+}
+
+func (r *Failovers) String() string {
+	if !r.Recovered {
+		return fmt.Sprintf("failover: %d outages, not recovered", len(r.Outages))
+	}
+	return fmt.Sprintf("failover: %d outages, convergence time %.1fms", len(r.Outages), r.ConvergenceMs)
+}
+
+// Convergence is an outage of the failover mode. FirstLostAt is
+// estimated from the spacing of the packets before.
+type Convergence struct {
+	LastBefore    uint16     `json:"last_before"`
+	FirstAfter    uint16     `json:"first_after,omitempty"`
+	LastBeforeAt  time.Time  `json:"last_before_at"`
+	FirstLostAt   time.Time  `json:"first_lost_at"`
+	FirstAfterAt  *time.Time `json:"first_after_at,omitempty"`
+	Lost          int        `json:"lost"`
+	ConvergenceMs float64    `json:"convergence_ms,omitempty"`
+}
+
+func (c Convergence) String() string {
+	if c.FirstAfterAt == nil {
+		return fmt.Sprintf("outage: last packet %d at %s, first lost at %s, not recovered",
+			c.LastBefore, c.LastBeforeAt.Format(outageTime), c.FirstLostAt.Format(outageTime))
+	}
+	return fmt.Sprintf("outage: last packet %d at %s, first lost at %s, first after %d at %s, %d lost, convergence %.1fms",
+		c.LastBefore, c.LastBeforeAt.Format(outageTime), c.FirstLostAt.Format(outageTime),
+		c.FirstAfter, c.FirstAfterAt.Format(outageTime), c.Lost, c.ConvergenceMs)
+}
+
+// HopStats are the ttl or hop limits received, with -hops.
+type HopStats struct {
+	Min     int `json:"min"`
+	Max     int `json:"max"`
+	Last    int `json:"last"`
+	Changes int `json:"changes"`
+}
+
+// KernelDrops attributes the packets the receiving kernel dropped
+// during a test (-drops) by their skb drop reason.
+type KernelDrops struct {
+	QueueOverflow int            `json:"queue_overflow"`
+	Checksum      int            `json:"checksum"`
+	Memory        int            `json:"memory"`
+	Other         int            `json:"other"`
+	Reasons       map[string]int `json:"reasons,omitempty"`
+}
+
+// Add counts n drops of a kernel drop reason.
+func (d *KernelDrops) Add(reason string, n int) {
+	switch reason {
+	case "SOCKET_RCVBUFF", "SOCKET_BACKLOG", "CPU_BACKLOG", "FULL_RING",
+		"QDISC_DROP", "QDISC_OVERLIMIT", "QDISC_CONGESTED", "NEIGH_QUEUEFULL":
+		d.QueueOverflow += n
+	case "UDP_CSUM", "IP_CSUM", "SKB_CSUM", "ICMP_CSUM":
+		d.Checksum += n
+	case "PROTO_MEM", "NOMEM", "PFMEMALLOC":
+		d.Memory += n
+	default:
+		d.Other += n
+	}
+	if d.Reasons == nil {
+		d.Reasons = map[string]int{}
+	}
+	d.Reasons[reason] += n
+}
+
+func (d *KernelDrops) String() string {
+	s := fmt.Sprintf("kernel drops: queue overflow %d, checksum %d, memory %d, other %d",
+		d.QueueOverflow, d.Checksum, d.Memory, d.Other)
+	var r []string
+	for name, n := range d.Reasons {
+		r = append(r, name+" "+strconv.Itoa(n))
+	}
+	if len(r) > 0 {
+		sort.Strings(r)
+		s += " (" + strings.Join(r, ", ") + ")"
+	}
+	return s
+}
+
+// PathStats holds the figures of the packets taking one path, a path
+// being one sending socket on the client and one source on the server.
+type PathStats struct {
+	Name     string   `json:"name"`
+	Sent     int      `json:"sent,omitempty"`
+	Received int      `json:"received"`
+	LossPct  *float64 `json:"loss_pct,omitempty"`
+	MedianMs float64  `json:"median_rtt_ms,omitempty"`
+}
+
+// SetReceived sets the counts and the loss if sent is known.
+func (p *PathStats) SetReceived(sent, received int) {
+	p.Sent = sent
+	p.Received = received
+	if sent > 0 {
+		loss := float64(sent-received) / float64(sent) * 100
+		p.LossPct = &loss
+	}
+}
+
+func (p *PathStats) String() string {
+	s := fmt.Sprintf("%s: received %d", p.Name, p.Received)
+	if p.LossPct != nil {
+		s = fmt.Sprintf("%s: sent %d, received %d, loss %.2f%%", p.Name, p.Sent, p.Received, *p.LossPct)
+	}
+	if p.MedianMs > 0 {
+		s += fmt.Sprintf(", median rtt %.3fms", p.MedianMs)
+	}
+	return s
+}
+
+// Overhead is what a tunnel adds to every packet, with -overhead.
+type Overhead struct {
+	Name  string `json:"name"`
+	Bytes int    `json:"bytes"`
+	MTU   int    `json:"mtu"`
+}
+
+// EnvInfo is the -env report: what is needed to interpret results
+// measured on another host.
+type EnvInfo struct {
+	OS        string            `json:"os"`
+	Arch      string            `json:"arch"`
+	Kernel    string            `json:"kernel,omitempty"`
+	Go        string            `json:"go"`
+	CPUs      int               `json:"cpus"`
+	Interface string            `json:"interface,omitempty"`
+	MTU       int               `json:"mtu,omitempty"`
+	Sysctls   map[string]string `json:"sysctls,omitempty"`
+	Offloads  map[string]bool   `json:"offloads,omitempty"`
+}
+
+func (e *EnvInfo) String() string {
+	s := fmt.Sprintf("env: %s %s %s, %s, %d cpus", e.OS, e.Kernel, e.Arch, e.Go, e.CPUs)
+	if e.Interface != "" {
+		s += fmt.Sprintf("\ninterface: %s, mtu %d", e.Interface, e.MTU)
+		if len(e.Offloads) > 0 {
+			var o []string
+			for name, on := range e.Offloads {
+				state := "off"
+				if on {
+					state = "on"
+				}
+				o = append(o, name+" "+state)
+			}
+			sort.Strings(o)
+			s += ", offloads: " + strings.Join(o, ", ")
+		}
+	}
+	if len(e.Sysctls) > 0 {
+		var kv []string
+		for k, v := range e.Sysctls {
+			kv = append(kv, k+"="+v)
+		}
+		sort.Strings(kv)
+		s += "\nsysctl: " + strings.Join(kv, ", ")
+	}
+	return s
+}
+
+// DNSInfo goes into the client's result, a slow or odd resolution is
+// often what went wrong rather than the udp path.
+type DNSInfo struct {
+	Host      string   `json:"host"`
+	Server    string   `json:"server,omitempty"`
+	Ms        float64  `json:"ms"`
+	Addresses []string `json:"addresses"`
+}
+
+// Anomaly is a notable event of a session, listed in the summary so
+// long runs can be triaged without reading traces. Events of a kind
+// following each other within half a second are merged, Detail
+// describes the first.
+type Anomaly struct {
+	At     time.Time `json:"at"`
+	Offset float64   `json:"offset_s"`
+	Kind   string    `json:"kind"`
+	Detail string    `json:"detail"`
+	Count  int       `json:"count"`
+	Until  time.Time `json:"until"`
+}
+
+func (a Anomaly) String() string {
+	s := fmt.Sprintf("+%.3fs %s: %s", a.Offset, a.Kind, a.Detail)
+	if a.Count > 1 {
+		s += fmt.Sprintf(" (%d times in %v)", a.Count, a.Until.Sub(a.At).Round(time.Millisecond))
+	}
+	return s
+}
+
+// Segment is the count of a section between marks.
+type Segment struct {
+	Label    string  `json:"label"`
+	First    int     `json:"first"`
+	Last     int     `json:"last"`
+	Received int     `json:"received"`
+	LossPct  float64 `json:"loss_pct"`
+}
+
+func (s Segment) String() string {
+	return fmt.Sprintf("%s: packets %d-%d, received %d, loss %.2f%%", s.Label, s.First, s.Last, s.Received, s.LossPct)
+}
+
+// RateChange is a send rate change during a test.
+type RateChange struct {
+	Offset float64 `json:"offset_s"`
+	Mbps   float64 `json:"mbps"`
+	Via    string  `json:"via"`
+}
+
+// Marker is a labeled event of the timeline, like a switch to another
+// network, put in by hand during the test.
+type Marker struct {
+	Label  string  `json:"label"`
+	First  int     `json:"first"`
+	Offset float64 `json:"offset_s"`
+}
+
+func (m Marker) String() string {
+	return fmt.Sprintf("marker at +%.1fs, packet %d: %s", m.Offset, m.First, m.Label)
+}
+
+// AIMDResult is the rate -adaptive sustained.
+type AIMDResult struct {
+	TargetLossPct float64          `json:"target_loss_pct"`
+	SustainedMbps float64          `json:"sustained_mbps"`
+	Samples       []AdaptiveSample `json:"samples"`
+}
+
+func (r *AIMDResult) String() string {
+	if len(r.Samples) == 0 {
+		return "adaptive: no server reports, rate not adapted"
+	}
+	return fmt.Sprintf("adaptive: sustained %.2f Mbit/s under %g%% loss", r.SustainedMbps, r.TargetLossPct)
+}
+
+// AdaptiveSample is the rate during a server report interval and the
+// loss reported for it.
+type AdaptiveSample struct {
+	Offset  float64 `json:"offset_s"`
+	Mbps    float64 `json:"mbps"`
+	LossPct float64 `json:"loss_pct"`
+}
+
+// Labels is a repeatable key=value flag.
+type Labels map[string]string
+
+func (l Labels) String() string {
+	var s []string
+	for k, v := range l {
+		s = append(s, k+"="+v)
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func (l Labels) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 {
+		return errors.New("expected key=value")
+	}
+	l[s[:i]] = s[i+1:]
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// queuedConn decouples socket reads from packet processing: a goroutine
+// drains the socket into pooled buffers queued on a channel, so a
+// processing hiccup is absorbed by the queue rather than turning into
+// kernel drops that look like network loss.
+type queuedConn struct {
+	net.PacketConn
+	queue    chan *rxItem
+	pool     sync.Pool
+	mu       sync.Mutex
+	deadline time.Time
+	// at is the receive time of the last packet read
+	at time.Time
+}
+
+type rxItem struct {
+	buf  []byte
+	n    int
+	oob  []byte
+	oobn int
+	addr *net.UDPAddr
+	at   time.Time
+	err  error
+}
+
+// rxQueuePeak is the highest queue depth seen.
+var rxQueuePeak int64
+
+func newQueuedConn(con net.PacketConn, size int) *queuedConn {
+	q := &queuedConn{PacketConn: con, queue: make(chan *rxItem, size)}
+	q.pool.New = func() interface{} {
+		return &rxItem{buf: make([]byte, datagramSize()), oob: make([]byte, 128)}
+	}
+	if encap.kind != "" {
+		if err := startDecap(q); err != nil {
+			fmt.Fprintln(os.Stderr, "encap:", err)
+		}
+	}
+	if xdpIface != "" {
+		if err := startXDP(q, xdpIface); err != nil {
+			fmt.Fprintln(os.Stderr, "AF_XDP unavailable:", err)
+		}
+	}
+	if busyPoll > 0 {
+		err := startBusyDrain(q)
+		if err == nil {
+			return q
+		}
+		fmt.Fprintln(os.Stderr, "busy polling unavailable, using standard reads:", err)
+	}
+	if ioBackend == "uring" {
+		err := startUringDrain(q)
+		if err == nil {
+			return q
+		}
+		fmt.Fprintln(os.Stderr, "io_uring unavailable, using standard reads:", err)
+	}
+	go q.drain()
+	return q
+}
+
+func (q *queuedConn) drain() {
+	uc := q.PacketConn.(*net.UDPConn)
+	for {
+		it := q.pool.Get().(*rxItem)
+		it.n, it.oobn, _, it.addr, it.err = uc.ReadMsgUDP(it.buf, it.oob)
+		q.push(it)
+		if it.err != nil {
+			return
+		}
+	}
+}
+
+func (q *queuedConn) push(it *rxItem) {
+	it.at = time.Now()
+	q.queue <- it
+	if d := int64(len(q.queue)); d > atomic.LoadInt64(&rxQueuePeak) {
+		atomic.StoreInt64(&rxQueuePeak, d)
+	}
+}
+
+func (q *queuedConn) SetReadDeadline(t time.Time) error {
+	q.mu.Lock()
+	q.deadline = t
+	q.mu.Unlock()
+	return nil
+}
+
+func (q *queuedConn) SetDeadline(t time.Time) error {
+	q.SetReadDeadline(t)
+	return q.PacketConn.SetWriteDeadline(t)
+}
+
+func (q *queuedConn) next() (*rxItem, error) {
+	q.mu.Lock()
+	deadline := q.deadline
+	q.mu.Unlock()
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		expired = t.C
+	}
+	select {
+	case it := <-q.queue:
+		if it.err != nil {
+			// keep failing like the socket does
+			q.queue <- it
+			return nil, it.err
+		}
+		return it, nil
+	case <-expired:
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (q *queuedConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	it, err := q.next()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer q.pool.Put(it)
+	q.at = it.at
+	return copy(b, it.buf[:it.n]), it.addr, nil
+}
+
+func (q *queuedConn) ReadMsgUDP(b, oob []byte) (n, oobn, flags int, addr *net.UDPAddr, err error) {
+	it, err := q.next()
+	if err != nil {
+		return 0, 0, 0, nil, err
+	}
+	defer q.pool.Put(it)
+	q.at = it.at
+	return copy(b, it.buf[:it.n]), copy(oob, it.oob[:it.oobn]), 0, it.addr, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// serveForever runs one test after another on the same socket,
+// surviving failed ones.
+func serveForever() {
+	pinLoop(0)
+	con := listen()
+	defer con.Close()
+	startWatchdog()
+	var next *startCmd
+	for n := 1; ; n++ {
+		func() {
+			started := atomic.LoadInt64(&sessions)
+			slog.begin()
+			defer func() {
+				r := recover()
+				slog.end(n, atomic.LoadInt64(&sessions) != started, r)
+				if r != nil {
+					fmt.Fprintf(os.Stderr, "session %d failed: %v\n", n, r)
+					next = nil
+				}
+			}()
+			sdNotify(fmt.Sprintf("STATUS=waiting for session %d", n))
+			if next == nil {
+				next = waiting.pop()
+			}
+			next = session(con, next)
+		}()
+	}
+}
+
+// sdNotify sends a state update to systemd when running as
+// a Type=notify unit and does nothing otherwise.
+func sdNotify(state string) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return
+	}
+	con, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "sd_notify:", err)
+		return
+	}
+	defer con.Close()
+	if _, err := con.Write([]byte(state)); err != nil {
+		fmt.Fprintln(os.Stderr, "sd_notify:", err)
+	}
+}
+
+// busySince is when the receive loop last got a datagram, 0 while it
+// waits for the next one.
+var busySince int64
+
+// startWatchdog pings systemd at half the WatchdogSec= interval as
+// long as the receive loop makes progress: it waits for datagrams or
+// got its last one less than the interval ago. A loop stuck between
+// reads lets systemd restart the server.
+func startWatchdog() {
+	usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC"))
+	if err != nil || usec <= 0 {
+		return
+	}
+	interval := time.Duration(usec) * time.Microsecond
+	go func() {
+		for now := range time.Tick(interval / 2) {
+			since := atomic.LoadInt64(&busySince)
+			if since == 0 || now.Sub(time.Unix(0, since)) < interval {
+				sdNotify("WATCHDOG=1")
+			}
+		}
+	}()
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// runService reports false, systemd runs the server in the foreground.
+func runService(serve func()) bool {
+	return false
+}
+
+func installService(args []string) {
+	fmt.Fprintln(os.Stderr, "install-service is windows only, run -service from a systemd unit instead")
+	os.Exit(1)
+}
+
+func uninstallService(args []string) {
+	installService(args)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// A service for the windows service control manager, through advapi32
+// directly as the module has no dependencies.
+
+var (
+	advapi32                 = syscall.NewLazyDLL("advapi32.dll")
+	startServiceCtrlDispatch = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	registerServiceCtrl      = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	setServiceStatus         = advapi32.NewProc("SetServiceStatus")
+)
+
+const (
+	svcOwnProcess   = 0x10
+	svcStopped      = 1
+	svcStartPending = 2
+	svcRunning      = 4
+	svcCtlStop      = 1
+	svcCtlShutdown  = 5
+	svcAcceptStop   = 1 | 4 // stop and shutdown
+	// errNotService is what the dispatcher fails with in a console
+	errNotService = 1063
+)
+
+type svcStatus struct {
+	serviceType, currentState, controlsAccepted uint32
+	win32ExitCode, serviceExitCode              uint32
+	checkPoint, waitHint                        uint32
+}
+
+type svcTableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+var svcHandle uintptr
+
+func reportStatus(state, accepts uint32) {
+	s := svcStatus{serviceType: svcOwnProcess, currentState: state, controlsAccepted: accepts}
+	setServiceStatus.Call(svcHandle, uintptr(unsafe.Pointer(&s)))
+}
+
+// runService runs serve under the service control manager and does not
+// return, unless the process was not started as a service.
+func runService(serve func()) bool {
+	name, _ := syscall.UTF16PtrFromString("udptest")
+	handler := syscall.NewCallback(func(ctl, _, _, _ uintptr) uintptr {
+		if ctl == svcCtlStop || ctl == svcCtlShutdown {
+			reportStatus(svcStopped, 0)
+			os.Exit(0)
+		}
+		return 0
+	})
+	svcMain := syscall.NewCallback(func(_, _ uintptr) uintptr {
+		svcHandle, _, _ = registerServiceCtrl.Call(uintptr(unsafe.Pointer(name)), handler, 0)
+		reportStatus(svcStartPending, 0)
+		reportStatus(svcRunning, svcAcceptStop)
+		serve()
+		return 0
+	})
+	table := []svcTableEntry{{name, svcMain}, {}}
+	r, _, err := startServiceCtrlDispatch.Call(uintptr(unsafe.Pointer(&table[0])))
+	if r == 0 && err == syscall.Errno(errNotService) {
+		return false
+	}
+	if r == 0 {
+		fmt.Fprintln(os.Stderr, "service:", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+	return true
+}
+
+// installService registers this executable as the service name,
+// started at boot with -l -service and the given server flags.
+func installService(args []string) {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: install-service <name> [server flags] <address>")
+		os.Exit(2)
+	}
+	exe, err := os.Executable()
+	ep(err)
+	bin := fmt.Sprintf("%q -l -service %s", exe, strings.Join(args[1:], " "))
+	runSC("create", args[0], "binPath=", bin, "start=", "auto")
+}
+
+func uninstallService(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: uninstall-service <name>")
+		os.Exit(2)
+	}
+	runSC("delete", args[0])
+}
+
+func runSC(args ...string) {
+	cmd := exec.Command("sc.exe", args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, "sc.exe:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sessionLog keeps the output and result of every -service session in
+// files under -session-dir, so a bad run can be looked into later
+// without having captured stdout. Files are named by start time and
+// test id, the oldest are removed beyond -session-keep sessions or
+// -session-age.
+type sessionLog struct {
+	dir    string
+	out    *os.File
+	r, w   *os.File
+	buf    bytes.Buffer
+	done   chan struct{}
+	result *result
+}
+
+// slog is nil unless -session-dir is given.
+var slog *sessionLog
+
+const sessionStamp = "20060102-150405.000"
+
+// begin tees stdout into the log until end.
+func (l *sessionLog) begin() {
+	if l == nil {
+		return
+	}
+	var err error
+	l.r, l.w, err = os.Pipe()
+	ep(err)
+	l.out, os.Stdout = os.Stdout, l.w
+	l.buf.Reset()
+	l.result = nil
+	l.done = make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.MultiWriter(l.out, &l.buf), l.r)
+		close(l.done)
+	}()
+}
+
+func (l *sessionLog) keep(r *result) {
+	if l != nil {
+		l.result = r
+	}
+}
+
+// end restores stdout and writes the files of session n if it started
+// or failed.
+func (l *sessionLog) end(n int, started bool, failure interface{}) {
+	if l == nil {
+		return
+	}
+	os.Stdout = l.out
+	l.w.Close()
+	<-l.done
+	l.r.Close()
+	if !started && failure == nil {
+		return
+	}
+	if failure != nil {
+		fmt.Fprintf(&l.buf, "session %d failed: %v\n", n, failure)
+	}
+	at, id := time.Now(), fmt.Sprint("session", n)
+	if l.result != nil {
+		at = l.result.Start
+		if l.result.TestID != "" {
+			id = l.result.TestID
+		}
+	}
+	base := filepath.Join(l.dir, at.Format(sessionStamp)+"-"+id)
+	if err := os.WriteFile(base+".log", l.buf.Bytes(), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, "session log:", err)
+		return
+	}
+	if l.result != nil {
+		writeJSON(base+".json", l.result)
+	}
+	l.prune()
+}
+
+// prune removes the files of sessions beyond -session-keep or older
+// than -session-age.
+func (l *sessionLog) prune() {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "session log:", err)
+		return
+	}
+	var bases []string
+	files := map[string][]string{}
+	for _, e := range entries {
+		name := e.Name()
+		ext := filepath.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		if e.IsDir() || ext != ".log" && ext != ".json" || len(base) < len(sessionStamp) {
+			continue
+		}
+		if files[base] == nil {
+			bases = append(bases, base)
+		}
+		files[base] = append(files[base], name)
+	}
+	sort.Strings(bases)
+	cut := time.Now().Add(-sessionAge)
+	for i, base := range bases {
+		at, err := time.ParseInLocation(sessionStamp, base[:len(sessionStamp)], time.Local)
+		if err != nil || i >= len(bases)-sessionKeep && (sessionAge == 0 || at.After(cut)) {
+			continue
+		}
+		for _, name := range files[base] {
+			if err := os.Remove(filepath.Join(l.dir, name)); err != nil {
+				fmt.Fprintln(os.Stderr, "session log:", err)
+			}
+		}
+	}
+}
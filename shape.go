@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shaper is the -shape token bucket the sends pass after the pacer, so
+// the traffic leaves like that of a shaped application: bursts of up to
+// burst bytes while tokens last, rate bit/s on average.
+type shaper struct {
+	rate  float64 // bit/s
+	burst int     // bytes
+	pkts  bool    // burst was given in packets
+
+	mu      sync.Mutex
+	tokens  float64
+	last    time.Time
+	held    int
+	maxHeld time.Duration
+}
+
+// shape is off while its rate is zero.
+var shape shaper
+
+func (s *shaper) String() string {
+	if s.rate == 0 {
+		return ""
+	}
+	burst := strconv.Itoa(s.burst)
+	if s.pkts {
+		burst = strconv.Itoa(s.burst/pktSize) + "pkt"
+	}
+	return fmt.Sprintf("rate=%s,burst=%s", formatBitRate(s.rate), burst)
+}
+
+// Set parses rate=<bit/s>[,burst=<bytes>|<n>pkt], the burst defaults
+// to one packet.
+func (s *shaper) Set(v string) error {
+	s.rate, s.burst, s.pkts = 0, 0, false
+	for _, kv := range strings.Split(v, ",") {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			return fmt.Errorf("expected key=value in %q", kv)
+		}
+		k, val := kv[:i], kv[i+1:]
+		switch k {
+		case "rate":
+			r, err := parseBitRate(val)
+			if err != nil {
+				return err
+			}
+			s.rate = r
+		case "burst":
+			n := strings.TrimSuffix(val, "pkt")
+			s.pkts = n != val
+			b, err := strconv.Atoi(n)
+			if err != nil || b < 1 {
+				return fmt.Errorf("bad burst %q", val)
+			}
+			s.burst = b
+		default:
+			return fmt.Errorf("unknown shape key %q", k)
+		}
+	}
+	if s.rate <= 0 {
+		return errors.New("shape needs a positive rate")
+	}
+	return nil
+}
+
+// start fills the bucket, burst in packets is taken of -p.
+func (s *shaper) start() {
+	switch {
+	case s.pkts:
+		s.burst *= pktSize
+	case s.burst == 0:
+		s.burst = pktSize
+	}
+	s.tokens = float64(s.burst)
+	s.last = time.Now()
+}
+
+// take waits until n bytes may be sent.
+func (s *shaper) take(n int) {
+	if s.rate == 0 {
+		return
+	}
+	s.mu.Lock()
+	now := time.Now()
+	s.tokens += now.Sub(s.last).Seconds() * s.rate / 8
+	if s.tokens > float64(s.burst) {
+		s.tokens = float64(s.burst)
+	}
+	s.last = now
+	s.tokens -= float64(n)
+	var wait time.Duration
+	if s.tokens < 0 {
+		// taken ahead, later sends wait for it too
+		wait = time.Duration(-s.tokens * 8 / s.rate * float64(time.Second))
+		s.held++
+		if wait > s.maxHeld {
+			s.maxHeld = wait
+		}
+	}
+	s.mu.Unlock()
+	if wait > 0 {
+		waitUntil(now.Add(wait))
+	}
+}
+
+func (s *shaper) summary() string {
+	return fmt.Sprintf("shape: %s, %d sends held back, at most %v", s, s.held, s.maxHeld.Round(time.Microsecond))
+}
+
+// waitUntil sleeps and then spins up to t, see pacerSpin.
+func waitUntil(t time.Time) {
+	if d := time.Until(t); d > pacerSpin {
+		time.Sleep(d - pacerSpin)
+	}
+	for time.Now().Before(t) {
+		runtime.Gosched()
+	}
+}
+
+// parseBitRate parses a rate in bit/s with an optional k, M or G
+// prefix and bps or bit unit, as in 500k, 10M or 4mbps.
+func parseBitRate(s string) (float64, error) {
+	v := strings.ToLower(s)
+	for _, unit := range []string{"bps", "bit", "b"} {
+		if strings.HasSuffix(v, unit) {
+			v = strings.TrimSuffix(v, unit)
+			break
+		}
+	}
+	mult := 1.0
+	if n := len(v); n > 0 {
+		switch v[n-1] {
+		case 'k':
+			mult = 1e3
+		case 'm':
+			mult = 1e6
+		case 'g':
+			mult = 1e9
+		}
+		if mult > 1 {
+			v = v[:n-1]
+		}
+	}
+	r, err := strconv.ParseFloat(v, 64)
+	if err != nil || r < 0 {
+		return 0, fmt.Errorf("bad rate %q", s)
+	}
+	return r * mult, nil
+}
+
+func formatBitRate(r float64) string {
+	switch {
+	case r >= 1e9:
+		return strconv.FormatFloat(r/1e9, 'f', -1, 64) + "G"
+	case r >= 1e6:
+		return strconv.FormatFloat(r/1e6, 'f', -1, 64) + "M"
+	case r >= 1e3:
+		return strconv.FormatFloat(r/1e3, 'f', -1, 64) + "k"
+	}
+	return strconv.FormatFloat(r, 'f', -1, 64)
+}
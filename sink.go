@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	"github.com/dinalt/udptest/sink"
+)
+
+// sinkList passes what the server's receive loop observes to the
+// outputs selected by flags, see setupSinks.
+type sinkList struct {
+	sink.List
+	// p is reused for every packet
+	p sink.Packet
+}
+
+var sinks sinkList
+
+func (l *sinkList) packet(p *paket) {
+	if len(l.List) == 0 {
+		return
+	}
+	l.p = sink.Packet{No: p.no, At: p.at, From: p.from, Raw: p.raw, TTL: p.ttl, TOS: p.tos, Flow: p.flow}
+	l.List.Packet(&l.p)
+}
+
+func (l *sinkList) interval(received int, highest uint16) {
+	l.List.Interval(received, highest)
+}
+
+func setupSinks() {
+	var l sink.List
+	if trc != nil {
+		l = append(l, traceSink{trc})
+	}
+	l = append(l, eventSink{})
+	if progress {
+		l = append(l, sink.Console(os.Stdout))
+	}
+	if statsJSONPath != "" {
+		l = append(l, sink.JSON(openStatsJSON(statsJSONPath)))
+	}
+	if metricsAddr != "" {
+		l = append(l, startMetrics(metricsAddr))
+	}
+	if capturePath != "" {
+		l = append(l, newCaptureSink(capturePath))
+	}
+	sinks.List = l
+}
+
+// openStatsJSON opens the -stats-json file for appending, or takes
+// stdout for "-".
+func openStatsJSON(path string) io.Writer {
+	if path == "-" {
+		return takeStdout()
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	ep(err)
+	return f
+}
+
+// traceSink writes packets to the -trace file.
+type traceSink struct{ t *tracer }
+
+func (s traceSink) Packet(p *sink.Packet) { s.t.record(p.No, p.At) }
+func (s traceSink) Interval(int, uint16)  {}
+
+// eventSink emits intervals to the -events stream and mqtt.
+type eventSink struct{}
+
+func (eventSink) Packet(*sink.Packet) {}
+func (eventSink) Interval(received int, highest uint16) {
+	emit("interval", map[string]interface{}{"received": received, "highest": highest})
+}
+
+// startMetrics serves the counters of a metrics sink and the session
+// count in the Prometheus text format.
+func startMetrics(addr string) *sink.Metrics {
+	m := &sink.Metrics{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = m.WriteTo(w)
+		fmt.Fprintf(w, "# TYPE udptest_sessions_total counter\nudptest_sessions_total %d\n",
+			atomic.LoadInt64(&sessions))
+	})
+	l, err := net.Listen("tcp", addr)
+	ep(err)
+	go func() {
+		ep(http.Serve(l, mux))
+	}()
+	return m
+}
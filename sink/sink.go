@@ -0,0 +1,100 @@
+// Package sink defines the outputs of what the udptest server's receive
+// loop observes, so new ones can be added without touching the loop.
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Packet is a data packet taken off the socket. It is only valid during
+// the call it is passed to.
+type Packet struct {
+	No uint16
+	// At is when the packet was taken off the socket.
+	At   time.Time
+	From net.Addr
+	// Raw is the datagram as received.
+	Raw []byte
+	// TTL, TOS and Flow are set if the socket reports them.
+	TTL  int
+	TOS  int
+	Flow uint32
+}
+
+// StatsSink receives every data packet and, every interval, the count
+// received and the highest packet number of the session so far. Both
+// are called from the receive loop and should not block.
+type StatsSink interface {
+	Packet(p *Packet)
+	Interval(received int, highest uint16)
+}
+
+// List passes everything to each of its sinks.
+type List []StatsSink
+
+func (l List) Packet(p *Packet) {
+	for _, s := range l {
+		s.Packet(p)
+	}
+}
+
+func (l List) Interval(received int, highest uint16) {
+	for _, s := range l {
+		s.Interval(received, highest)
+	}
+}
+
+// Console prints intervals to w.
+func Console(w io.Writer) StatsSink {
+	return console{w}
+}
+
+type console struct{ w io.Writer }
+
+func (console) Packet(*Packet) {}
+func (c console) Interval(received int, highest uint16) {
+	fmt.Fprintf(c.w, "received %d, highest %d\n", received, highest)
+}
+
+// JSON writes intervals to w as json lines.
+func JSON(w io.Writer) StatsSink {
+	return jsonSink{json.NewEncoder(w)}
+}
+
+type jsonSink struct{ enc *json.Encoder }
+
+func (jsonSink) Packet(*Packet) {}
+func (s jsonSink) Interval(received int, highest uint16) {
+	_ = s.enc.Encode(struct {
+		At       time.Time `json:"at"`
+		Received int       `json:"received"`
+		Highest  uint16    `json:"highest"`
+	}{time.Now(), received, highest})
+}
+
+// Metrics counts packets for the Prometheus text format.
+type Metrics struct {
+	received int64
+	highest  int64
+}
+
+func (m *Metrics) Packet(*Packet) {
+	atomic.AddInt64(&m.received, 1)
+}
+
+func (m *Metrics) Interval(received int, highest uint16) {
+	atomic.StoreInt64(&m.highest, int64(highest))
+}
+
+// WriteTo writes the counters in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	n, err := fmt.Fprintf(w, "# TYPE udptest_received_packets_total counter\nudptest_received_packets_total %d\n"+
+		"# TYPE udptest_highest_packet gauge\nudptest_highest_packet %d\n",
+		atomic.LoadInt64(&m.received), atomic.LoadInt64(&m.highest))
+	return int64(n), err
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	udpSegment = 103 // UDP_SEGMENT from linux/udp.h
+	soBusyPoll = 46  // SO_BUSY_POLL from asm-generic/socket.h
+)
+
+func dialControl(network, address string, c syscall.RawConn) error {
+	var serr error
+	err := c.Control(func(fd uintptr) {
+		if fwMark != 0 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, fwMark)
+		}
+		if serr == nil && gsoSegs > 1 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_UDP, udpSegment, pktSize)
+		}
+		if serr == nil && fragSize > 0 {
+			if network == "udp6" {
+				serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DONT)
+			} else {
+				serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DONT)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+func listenControl(network, address string, c syscall.RawConn) error {
+	type opt struct{ level, name int }
+	var v4, v6 []opt
+	if hopReport {
+		v4 = append(v4, opt{syscall.IPPROTO_IP, syscall.IP_RECVTTL})
+		v6 = append(v6, opt{syscall.IPPROTO_IPV6, syscall.IPV6_RECVHOPLIMIT})
+	}
+	if pathKeys.has("dscp") {
+		v4 = append(v4, opt{syscall.IPPROTO_IP, syscall.IP_RECVTOS})
+		v6 = append(v6, opt{syscall.IPPROTO_IPV6, syscall.IPV6_RECVTCLASS})
+	}
+	if pathKeys.has("flowlabel") {
+		v6 = append(v6, opt{syscall.IPPROTO_IPV6, ipv6Flowinfo})
+	}
+	if anyAddress() {
+		v4 = append(v4, opt{syscall.IPPROTO_IP, syscall.IP_PKTINFO})
+		v6 = append(v6, opt{syscall.IPPROTO_IPV6, syscall.IPV6_RECVPKTINFO})
+	}
+	var serr error
+	err := c.Control(func(fd uintptr) {
+		if busyPoll > 0 {
+			// raising it above net.core.busy_read needs CAP_NET_ADMIN
+			if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soBusyPoll, int(busyPoll/time.Microsecond)); err != nil {
+				fmt.Fprintf(os.Stderr, "-busy-poll: SO_BUSY_POLL refused (%v), continuing without\n", err)
+				busyPoll = 0
+			}
+		}
+		for _, o := range v4 {
+			err := syscall.SetsockoptInt(int(fd), o.level, o.name, 1)
+			// only wanted for v4 mapped packets on a dual stack socket
+			if network != "udp6" && serr == nil {
+				serr = err
+			}
+		}
+		if network != "udp6" {
+			return
+		}
+		for _, o := range v6 {
+			if serr == nil {
+				serr = syscall.SetsockoptInt(int(fd), o.level, o.name, 1)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// parseCmsg takes the TTL, traffic class and flow label of p from
+// received control messages.
+func (p *paket) parseCmsg(oob []byte) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+	for _, m := range msgs {
+		ip := m.Header.Level == syscall.IPPROTO_IP
+		ip6 := m.Header.Level == syscall.IPPROTO_IPV6
+		switch {
+		case len(m.Data) < 1:
+		case ip && m.Header.Type == syscall.IP_TOS:
+			p.tos = int(m.Data[0])
+		case len(m.Data) < 4:
+		case ip && m.Header.Type == syscall.IP_TTL, ip6 && m.Header.Type == syscall.IPV6_HOPLIMIT:
+			p.ttl = int(*(*int32)(unsafe.Pointer(&m.Data[0])))
+		case ip6 && m.Header.Type == syscall.IPV6_TCLASS:
+			p.tos = int(*(*int32)(unsafe.Pointer(&m.Data[0])))
+		case ip6 && m.Header.Type == ipv6Flowinfo:
+			p.flow = binary.BigEndian.Uint32(m.Data) & 0xfffff
+		case ip && m.Header.Type == syscall.IP_PKTINFO && len(m.Data) >= 12:
+			// in_pktinfo: ifindex, spec_dst, addr
+			p.dst = net.IP(append([]byte(nil), m.Data[8:12]...))
+		case ip6 && m.Header.Type == syscall.IPV6_PKTINFO && len(m.Data) >= 16:
+			p.dst = net.IP(append([]byte(nil), m.Data[:16]...))
+		}
+	}
+}
+
+const (
+	ipv6Flowinfo     = 11 // IPV6_FLOWINFO from linux/in6.h
+	ipv6FlowlabelMgr = 32 // IPV6_FLOWLABEL_MGR
+	ipv6FlShareAny   = 255
+	ipv6FlCreate     = 1
+)
+
+// in6FlowlabelReq is struct in6_flowlabel_req from linux/in6.h.
+type in6FlowlabelReq struct {
+	dst     [16]byte
+	label   [4]byte // big endian
+	action  uint8
+	share   uint8
+	flags   uint16
+	expires uint16
+	linger  uint16
+	_       uint32
+}
+
+// readBuffer returns the receive buffer size of fd, which linux doubles
+// for its bookkeeping.
+func readBuffer(fd uintptr) (int, error) {
+	n, err := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+	return n / 2, err
+}
+
+func forceReadBuffer(fd uintptr, n int) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, n)
+}
+
+// pktinfo returns a control message sending from local.
+func pktinfo(local net.IP) []byte {
+	if ip4 := local.To4(); ip4 != nil {
+		oob := make([]byte, syscall.CmsgSpace(syscall.SizeofInet4Pktinfo))
+		h := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+		h.Level = syscall.IPPROTO_IP
+		h.Type = syscall.IP_PKTINFO
+		h.SetLen(syscall.CmsgLen(syscall.SizeofInet4Pktinfo))
+		pi := (*syscall.Inet4Pktinfo)(unsafe.Pointer(&oob[syscall.CmsgLen(0)]))
+		copy(pi.Spec_dst[:], ip4)
+		return oob
+	}
+	oob := make([]byte, syscall.CmsgSpace(syscall.SizeofInet6Pktinfo))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = syscall.IPPROTO_IPV6
+	h.Type = syscall.IPV6_PKTINFO
+	h.SetLen(syscall.CmsgLen(syscall.SizeofInet6Pktinfo))
+	pi := (*syscall.Inet6Pktinfo)(unsafe.Pointer(&oob[syscall.CmsgLen(0)]))
+	copy(pi.Addr[:], local.To16())
+	return oob
+}
+
+// setTOS sets the tos or traffic class of packets sent on con.
+func setTOS(con net.Conn, tos int) error {
+	rc, err := con.(*net.UDPConn).SyscallConn()
+	if err != nil {
+		return err
+	}
+	v6 := con.RemoteAddr().(*net.UDPAddr).IP.To4() == nil
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		if v6 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+		} else {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// withFlowLabel leases label for con and returns con sending with it.
+func withFlowLabel(con net.Conn, label uint32) (net.Conn, error) {
+	uc := con.(*net.UDPConn)
+	raddr := uc.RemoteAddr().(*net.UDPAddr)
+	if raddr.IP.To4() != nil {
+		return nil, errors.New("-flowlabel needs an ipv6 server address")
+	}
+	req := in6FlowlabelReq{share: ipv6FlShareAny, flags: ipv6FlCreate}
+	copy(req.dst[:], raddr.IP.To16())
+	binary.BigEndian.PutUint32(req.label[:], label)
+	rc, err := uc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		b := (*[unsafe.Sizeof(req)]byte)(unsafe.Pointer(&req))[:]
+		serr = syscall.SetsockoptString(int(fd), syscall.IPPROTO_IPV6, ipv6FlowlabelMgr, string(b))
+	})
+	if err == nil {
+		err = serr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("flow label %#x: %w", label, err)
+	}
+	oob := make([]byte, syscall.CmsgSpace(4))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = syscall.IPPROTO_IPV6
+	h.Type = ipv6Flowinfo
+	h.SetLen(syscall.CmsgLen(4))
+	binary.BigEndian.PutUint32(oob[syscall.CmsgLen(0):], label)
+	return &flowConn{UDPConn: uc, oob: oob}, nil
+}
+
+// pathMTU returns the kernel's idea of the path mtu of connected con.
+func pathMTU(con net.Conn) (int, error) {
+	uc, ok := con.(*net.UDPConn)
+	if !ok {
+		return 0, errors.New("not a udp socket")
+	}
+	rc, err := uc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var (
+		mtu  int
+		serr error
+	)
+	err = rc.Control(func(fd uintptr) {
+		if isIPv6(con.RemoteAddr().String()) {
+			mtu, serr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU)
+		} else {
+			mtu, serr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	return mtu, serr
+}
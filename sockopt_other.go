@@ -0,0 +1,50 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+func init() {
+	for _, id := range []string{"ttl", "tos", "flowlabel", "fwmark", "gso", "pmtu"} {
+		unavailable[id] = "linux only"
+	}
+}
+
+func dialControl(network, address string, c syscall.RawConn) error {
+	return nil
+}
+
+func listenControl(network, address string, c syscall.RawConn) error {
+	return nil
+}
+
+func (p *paket) parseCmsg(oob []byte) {}
+
+func readBuffer(fd uintptr) (int, error) {
+	return 0, errors.New("buffer size is known on linux only")
+}
+
+func forceReadBuffer(fd uintptr, n int) error {
+	return errors.New("linux only")
+}
+
+func pktinfo(local net.IP) []byte {
+	return nil
+}
+
+func setTOS(con net.Conn, tos int) error {
+	return errors.New("-dscp is supported on linux only")
+}
+
+func withFlowLabel(con net.Conn, label uint32) (net.Conn, error) {
+	return nil, errors.New("-flowlabel is supported on linux only")
+}
+
+func pathMTU(con net.Conn) (int, error) {
+	return 0, errors.New("path mtu is known on linux only")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// sweepWait is how long answers to a burst are awaited.
+const sweepWait = time.Second
+
+// sweepHop holds what answered the packets sent with one ttl.
+type sweepHop struct {
+	sent    int
+	from    map[string]*hopAnswers
+	order   []string
+	reached bool
+}
+
+type hopAnswers struct {
+	kind string
+	n    int
+	rtts []time.Duration
+}
+
+// ttlSweep runs -ttl-sweep: bursts of -ttl-burst packets to the test
+// port with ttl 1, 2 and so on, like mtr but for the test flow.
+// Routers answer with icmp time exceeded, the share of a burst answered
+// estimates the loss up to that hop, though routers also rate limit
+// icmp. It stops at the ttl reaching the server, which answers when it
+// runs -responder or -service -echo, or else its host with port
+// unreachable.
+func ttlSweep() {
+	d := net.Dialer{Control: dialControl}
+	c, err := d.Dial("udp", addr)
+	ep(err)
+	defer c.Close()
+	con := c.(*net.UDPConn)
+	ep(enableRecvErr(con))
+	server := con.RemoteAddr().(*net.UDPAddr).IP
+	var (
+		mu     sync.Mutex
+		hops   = make([]sweepHop, ttlSweepMax+1)
+		sentAt = make([]time.Time, ttlSweepMax*ttlBurst+1)
+		ttlOf  = make([]int, len(sentAt))
+		cur    int
+		done   = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		buf := make([]byte, pktMaxSize)
+		oob := make([]byte, 512)
+		for {
+			n, from, kind, err := readHop(con, buf, oob)
+			if err != nil {
+				return
+			}
+			now := time.Now()
+			var no int
+			if n >= pktNoSize {
+				no = int(binary.LittleEndian.Uint16(buf))
+			}
+			if kind == "reply" && no == 0 {
+				continue
+			}
+			mu.Lock()
+			ttl := cur
+			var rtt time.Duration
+			if no > 0 && no < len(ttlOf) && ttlOf[no] > 0 {
+				ttl, rtt = ttlOf[no], now.Sub(sentAt[no])
+			}
+			h := &hops[ttl]
+			key := "?"
+			if from != nil {
+				key = from.String()
+			}
+			a := h.from[key]
+			if a == nil {
+				a = &hopAnswers{kind: kind}
+				h.from[key] = a
+				h.order = append(h.order, key)
+			}
+			a.n++
+			if rtt > 0 {
+				a.rtts = append(a.rtts, rtt)
+			}
+			if kind == "reply" || kind == "unreachable" && from.Equal(server) {
+				h.reached = true
+			}
+			mu.Unlock()
+		}
+	}()
+	var pkt paket
+	payload := make([]byte, pktSize-pktInfSize)
+	no := 0
+	last := ttlSweepMax
+	for ttl := 1; ttl <= ttlSweepMax; ttl++ {
+		ep(setHopLimit(con, ttl))
+		mu.Lock()
+		cur = ttl
+		hops[ttl].from = map[string]*hopAnswers{}
+		mu.Unlock()
+		for k := 0; k < ttlBurst; k++ {
+			no++
+			pkt.apply(uint16(no), payload)
+			mu.Lock()
+			sentAt[no], ttlOf[no] = time.Now(), ttl
+			mu.Unlock()
+			// errors left by earlier icmp messages are read
+			// from the queue
+			if _, err := con.Write(pkt.raw); err == nil {
+				hops[ttl].sent++
+			}
+			time.Sleep(sendInterval)
+		}
+		time.Sleep(sweepWait)
+		mu.Lock()
+		reached := hops[ttl].reached
+		mu.Unlock()
+		if reached {
+			last = ttl
+			break
+		}
+	}
+	con.SetReadDeadline(time.Now())
+	<-done
+	printSweep(hops[1 : last+1])
+}
+
+func printSweep(hops []sweepHop) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ttl\taddress\tanswer\tanswered\tloss\tmedian rtt")
+	for i, h := range hops {
+		if len(h.order) == 0 {
+			fmt.Fprintf(w, "%d\t*\t\t0/%d\t100.0%%\t\n", i+1, h.sent)
+			continue
+		}
+		total := 0
+		for _, a := range h.from {
+			total += a.n
+		}
+		for j, key := range h.order {
+			a := h.from[key]
+			loss := ""
+			if j == 0 && h.sent > 0 {
+				loss = fmt.Sprintf("%.1f%%", float64(h.sent-total)/float64(h.sent)*100)
+			}
+			med := ""
+			if len(a.rtts) > 0 {
+				sort.Slice(a.rtts, func(i, j int) bool { return a.rtts[i] < a.rtts[j] })
+				med = a.rtts[len(a.rtts)/2].Round(time.Microsecond).String()
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%d/%d\t%s\t%s\n", i+1, key, a.kind, a.n, h.sent, loss, med)
+		}
+	}
+	w.Flush()
+	if n := len(hops); n > 0 && !hops[n-1].reached {
+		fmt.Printf("server not reached within %d hops\n", n)
+	}
+}
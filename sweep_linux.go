@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// enableRecvErr queues icmp errors for con to be read with readHop.
+func enableRecvErr(con *net.UDPConn) error {
+	if isV6(con) {
+		return setsockopt(con, syscall.IPPROTO_IPV6, syscall.IPV6_RECVERR, 1)
+	}
+	return setsockopt(con, syscall.IPPROTO_IP, syscall.IP_RECVERR, 1)
+}
+
+// setHopLimit sets the ttl or hop limit of packets sent on con.
+func setHopLimit(con *net.UDPConn, ttl int) error {
+	if isV6(con) {
+		return setsockopt(con, syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+	}
+	return setsockopt(con, syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+}
+
+func isV6(con *net.UDPConn) bool {
+	return con.RemoteAddr().(*net.UDPAddr).IP.To4() == nil
+}
+
+func setsockopt(con *net.UDPConn, level, name, v int) error {
+	rc, err := con.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), level, name, v)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// readHop returns the next reply on con or icmp error queued for it,
+// with the packet it refers to in buf, who sent it and what it is:
+// "reply", "time exceeded", "unreachable" or "error".
+func readHop(con *net.UDPConn, buf, oob []byte) (n int, from net.IP, kind string, err error) {
+	rc, err := con.SyscallConn()
+	if err != nil {
+		return 0, nil, "", err
+	}
+	err = rc.Read(func(fd uintptr) bool {
+		for {
+			var oobn int
+			var rerr error
+			n, oobn, _, _, rerr = syscall.Recvmsg(int(fd), buf, oob, syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+			if rerr == nil {
+				from, kind = parseRecvErr(oob[:oobn])
+				return true
+			}
+			n, _, rerr = syscall.Recvfrom(int(fd), buf, syscall.MSG_DONTWAIT)
+			if rerr == nil {
+				from, kind = con.RemoteAddr().(*net.UDPAddr).IP, "reply"
+				return true
+			}
+			// the error an icmp message left on the socket is
+			// read from the queue instead
+			if rerr == syscall.EAGAIN {
+				return false
+			}
+		}
+	})
+	return n, from, kind, err
+}
+
+// parseRecvErr reads struct sock_extended_err and the offender address
+// following it.
+func parseRecvErr(oob []byte) (net.IP, string) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, "error"
+	}
+	for _, m := range msgs {
+		if !(m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_RECVERR) &&
+			!(m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == syscall.IPV6_RECVERR) {
+			continue
+		}
+		if len(m.Data) < 16 {
+			break
+		}
+		origin, typ := m.Data[4], m.Data[5]
+		kind := "error"
+		switch {
+		case origin == 2 && typ == 11, origin == 3 && typ == 3:
+			kind = "time exceeded"
+		case origin == 2 && typ == 3, origin == 3 && typ == 1:
+			kind = "unreachable"
+		}
+		sa := m.Data[16:]
+		if len(sa) < 8 {
+			return nil, kind
+		}
+		switch *(*uint16)(unsafe.Pointer(&sa[0])) {
+		case syscall.AF_INET:
+			return net.IP(append([]byte(nil), sa[4:8]...)), kind
+		case syscall.AF_INET6:
+			if len(sa) >= 24 {
+				return net.IP(append([]byte(nil), sa[8:24]...)), kind
+			}
+		}
+		return nil, kind
+	}
+	return nil, "error"
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+func enableRecvErr(con *net.UDPConn) error {
+	return errors.New("-ttl-sweep is supported on linux only")
+}
+
+func setHopLimit(con *net.UDPConn, ttl int) error {
+	return errors.New("linux only")
+}
+
+func readHop(con *net.UDPConn, buf, oob []byte) (int, net.IP, string, error) {
+	return 0, nil, "", errors.New("linux only")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+var syncMsg = []byte("sync")
+
+const (
+	syncReqSize = 4 + 8            // syncMsg, client send time
+	syncRepSize = syncReqSize + 16 // server receive and send times
+)
+
+// estimateOffset exchanges n timestamped probes with the server and
+// returns the server clock offset taken from the probe with the
+// smallest round trip.
+func estimateOffset(con net.Conn, n int) (offset, rtt time.Duration, err error) {
+	req := make([]byte, syncReqSize)
+	if requireCookie {
+		// not to be answered with more than was sent
+		req = make([]byte, syncRepSize)
+	}
+	rep := make([]byte, syncRepSize+1)
+	copy(req, syncMsg)
+	timeout := setupTimeout
+	if timeout == 0 {
+		timeout = idleTimeout
+	}
+	rtt = -1
+	for i := 0; i < n; i++ {
+		t1 := time.Now()
+		binary.LittleEndian.PutUint64(req[4:], uint64(t1.UnixNano()))
+		if _, err = con.Write(req); err != nil {
+			return
+		}
+		con.SetReadDeadline(time.Now().Add(timeout))
+		var m int
+		m, err = con.Read(rep)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		t4 := time.Now()
+		if m != syncRepSize || !bytes.Equal(rep[:4], syncMsg) ||
+			!bytes.Equal(rep[4:syncReqSize], req[4:syncReqSize]) {
+			continue
+		}
+		t2 := int64(binary.LittleEndian.Uint64(rep[12:]))
+		t3 := int64(binary.LittleEndian.Uint64(rep[20:]))
+		d := t4.Sub(t1) - time.Duration(t3-t2)
+		if rtt < 0 || d < rtt {
+			rtt = d
+			offset = time.Duration((t2-t1.UnixNano())+(t3-t4.UnixNano())) / 2
+		}
+	}
+	con.SetReadDeadline(time.Time{})
+	if rtt < 0 {
+		return 0, 0, fmt.Errorf("no sync replies in %d probes", n)
+	}
+	return offset, rtt, nil
+}
+
+// replySync answers a clock probe received at recv on local and reports
+// whether b was one. Probes may be padded to the reply size, with -cookie only
+// those are answered.
+func replySync(con net.PacketConn, b []byte, to net.Addr, local net.IP, recv time.Time) bool {
+	if len(b) != syncReqSize && len(b) != syncRepSize || !bytes.Equal(b[:4], syncMsg) {
+		return false
+	}
+	if requireCookie && len(b) < syncRepSize {
+		return true
+	}
+	rep := make([]byte, syncRepSize)
+	copy(rep, b)
+	binary.LittleEndian.PutUint64(rep[12:], uint64(recv.UnixNano()))
+	binary.LittleEndian.PutUint64(rep[20:], uint64(time.Now().UnixNano()))
+	ep(replyTo(con, rep, to, local))
+	return true
+}
+
+// checkOffset warns if the clocks are too far apart for uncorrected
+// one-way delays to mean anything, and stops with -require-sync.
+func checkOffset(offset time.Duration) {
+	if offset > -maxOffset && offset < maxOffset {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "clock offset %v exceeds %v: one-way delays are only valid after offset correction\n", offset, maxOffset)
+	if requireSync {
+		fmt.Fprintln(os.Stderr, "clocks not in sync, refusing to run (-require-sync)")
+		os.Exit(1)
+	}
+}
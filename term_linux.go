@@ -0,0 +1,30 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// rawTerminal turns off line buffering and echo on the terminal fd and
+// returns how to undo it.
+func rawTerminal(fd int) (func(), error) {
+	var t syscall.Termios
+	if err := termios(fd, syscall.TCGETS, &t); err != nil {
+		return nil, err
+	}
+	old := t
+	t.Lflag &^= syscall.ICANON | syscall.ECHO
+	t.Cc[syscall.VMIN], t.Cc[syscall.VTIME] = 1, 0
+	if err := termios(fd, syscall.TCSETS, &t); err != nil {
+		return nil, err
+	}
+	return func() { _ = termios(fd, syscall.TCSETS, &old) }, nil
+}
+
+func termios(fd int, req uintptr, t *syscall.Termios) error {
+	_, _, e := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(unsafe.Pointer(t)))
+	if e != 0 {
+		return e
+	}
+	return nil
+}
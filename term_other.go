@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func rawTerminal(fd int) (func(), error) {
+	return nil, errors.New("raw terminal mode not supported on this platform")
+}
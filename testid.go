@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// startCmd is a received start command. The client sends a random test
+// id along with it so both sides' outputs of the same run can be
+// joined, describes the file it sends with -file and declares its send
+// rate in bit/s for -max-rate and its protocol version; older clients
+// send none of them. A client retrying with the cookie a -cookie server
+// gave it adds that.
+type startCmd struct {
+	from net.Addr
+	// local is the address the client sent to
+	local   net.IP
+	id      string
+	file    *fileInfo
+	rate    int64
+	cookie  string
+	version int
+}
+
+// testID identifies the running test in results and events.
+var testID string
+
+func newTestID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	ep(err)
+	return hex.EncodeToString(b)
+}
+
+func startPacket(id string, file *fileInfo, rate int64) []byte {
+	b := append(append([]byte{}, start...), " "+id...)
+	if file != nil {
+		b = append(b, " file "+file.String()...)
+	}
+	if rate > 0 {
+		b = append(b, " rate "+strconv.FormatInt(rate, 10)...)
+	}
+	return append(b, " version "+strconv.Itoa(protoVersion)...)
+}
+
+// parseStart returns the start command in b, or nil if b is none.
+func parseStart(b []byte, from net.Addr) *startCmd {
+	if !bytes.HasPrefix(b, start) {
+		return nil
+	}
+	rest := b[len(start):]
+	if len(rest) == 0 {
+		return &startCmd{from: from}
+	}
+	if rest[0] != ' ' {
+		return nil
+	}
+	f := strings.Fields(string(rest))
+	cmd := &startCmd{from: from}
+	if len(f) > 0 {
+		cmd.id = f[0]
+	}
+	for i := 1; i < len(f); i++ {
+		switch {
+		case f[i] == "file" && i+2 < len(f):
+			cmd.file = parseFileInfo(f[i+1], f[i+2])
+			i += 2
+		case f[i] == "rate" && i+1 < len(f):
+			cmd.rate, _ = strconv.ParseInt(f[i+1], 10, 64)
+			i++
+		case f[i] == "cookie" && i+1 < len(f):
+			cmd.cookie = f[i+1]
+			i++
+		case f[i] == "version" && i+1 < len(f):
+			cmd.version, _ = strconv.Atoi(f[i+1])
+			i++
+		}
+	}
+	return cmd
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+var timeBeginPeriod = syscall.NewLazyDLL("winmm.dll").NewProc("timeBeginPeriod")
+
+// setupTimer raises the system timer resolution to 1ms, without which
+// sleeps are rounded up to ~15.6ms. Sleeps are still coarser than on
+// other systems, so more of every wait is spun.
+func setupTimer() {
+	if timeBeginPeriod.Find() == nil {
+		if r, _, _ := timeBeginPeriod.Call(1); r == 0 {
+			pacerSpin = 2 * time.Millisecond
+			return
+		}
+	}
+	pacerSpin = 16 * time.Millisecond
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tracer writes "<packet no> <unix nanoseconds>" lines, one per sent or
+// received packet, for later use by the analyze command.
+type tracer struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+	b  []byte
+}
+
+func openTrace(path, kind string) *tracer {
+	if path == "" {
+		return nil
+	}
+	f, err := os.Create(path)
+	ep(err)
+	t := &tracer{f: f, w: bufio.NewWriter(f)}
+	_, err = t.w.WriteString("# udptest " + kind + " trace\n")
+	ep(err)
+	return t
+}
+
+func (t *tracer) record(no uint16, ts time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.b = strconv.AppendUint(t.b[:0], uint64(no), 10)
+	t.b = append(t.b, ' ')
+	t.b = strconv.AppendInt(t.b, ts.UnixNano(), 10)
+	t.b = append(t.b, '\n')
+	_, err := t.w.Write(t.b)
+	ep(err)
+}
+
+func (t *tracer) comment(c string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, err := t.w.WriteString("# " + c + "\n")
+	ep(err)
+}
+
+func (t *tracer) close() {
+	if t == nil {
+		return
+	}
+	ep(t.w.Flush())
+	ep(t.f.Close())
+}
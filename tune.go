@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// skbOverhead is roughly what the kernel charges a socket buffer per
+// datagram on top of its bytes.
+const skbOverhead = 768
+
+// tuneBuffer sizes the receive buffer of con for autoTune worth of
+// packets at the configured rate, so a reader stalling that long does
+// not turn into loss, and reports what the kernel granted.
+func tuneBuffer(con interface {
+	syscall.Conn
+	SetReadBuffer(int) error
+}) {
+	// unpaced tests are sized by their -shape rate, see main
+	interval := sendInterval
+	if interval <= 0 {
+		interval = time.Duration(float64(datagramSize()*8) / float64(sendRate()) * float64(time.Second))
+	}
+	packets := int(autoTune/interval) + 1
+	want := packets * (datagramSize() + skbOverhead)
+	if err := con.SetReadBuffer(want); err != nil {
+		fmt.Fprintln(os.Stderr, "auto-tune:", err)
+		return
+	}
+	rc, err := con.SyscallConn()
+	if err != nil {
+		return
+	}
+	var got int
+	err = rc.Control(func(fd uintptr) {
+		got, err = readBuffer(fd)
+		if err == nil && got < want {
+			// root may go past the limit
+			if forceReadBuffer(fd, want) == nil {
+				got, err = readBuffer(fd)
+			}
+		}
+	})
+	if err != nil {
+		fmt.Printf("receive buffer: asked for %d bytes for %d packets\n", want, packets)
+		return
+	}
+	fmt.Printf("receive buffer: %d bytes for %d packets (%v at %v)\n", got, packets, autoTune, interval)
+	if got < want {
+		fmt.Fprintf(os.Stderr, "receive buffer clamped to %d of %d bytes, a stall over %v means loss: raise it with sysctl -w net.core.rmem_max=%d\n",
+			got, want, time.Duration(got/(datagramSize()+skbOverhead))*sendInterval, want)
+	}
+}
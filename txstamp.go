@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+var (
+	hwTxStamps bool
+	// txts is set while the nic stamps sent packets
+	txts *txStamps
+)
+
+func (t *txStamps) String() string {
+	if t.n == 0 {
+		return "hardware tx timestamps: none received"
+	}
+	return fmt.Sprintf("hardware tx timestamps: %d, mean %v after the send call", t.n, time.Duration(t.delay/t.n))
+}
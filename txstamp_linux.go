@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	siocGHwTstamp = 0x89b1
+	siocSHwTstamp = 0x89b0
+	hwtstampTxOn  = 1 // HWTSTAMP_TX_ON
+
+	soTimestamping        = 37 // SO_TIMESTAMPING, also its cmsg type
+	tsTxHardware          = 1 << 0
+	tsRawHardware         = 1 << 6
+	tsOptID               = 1 << 7
+	tsOptTsonly           = 1 << 11
+	eeOriginTimestamping  = 4 // SO_EE_ORIGIN_TIMESTAMPING
+	txStampPoll           = time.Millisecond
+	ipv6RecvErr           = 25 // IPV6_RECVERR
+	sockExtendedErrDataAt = 12 // ee_data in struct sock_extended_err
+)
+
+type hwtstampConfig struct {
+	flags, txType, rxFilter int32
+}
+
+// txStamps has the nic stamp the data packets a socket sends and
+// moves the stamps to the echo send times. Only sends carrying the
+// request are counted by the kernel, so the n-th stamp belongs to
+// packet n.
+type txStamps struct {
+	con   *net.UDPConn
+	fd    int
+	oob   []byte
+	iface string
+	prev  hwtstampConfig
+	stop  chan struct{}
+	done  chan struct{}
+	n     int64
+	delay int64 // sum of stamp minus send call time
+}
+
+// startTxStamps turns on hardware tx timestamps on the interface con
+// sends through and on con.
+func startTxStamps(con net.Conn) (*txStamps, error) {
+	uc, ok := con.(*net.UDPConn)
+	if !ok {
+		return nil, errors.New("needs a plain udp socket")
+	}
+	ifi := ifaceTo(con.RemoteAddr())
+	if ifi == nil {
+		return nil, errors.New("no interface found for the destination")
+	}
+	fd, err := sysFd(uc)
+	if err != nil {
+		return nil, err
+	}
+	t := &txStamps{con: uc, fd: fd, iface: ifi.Name, stop: make(chan struct{}), done: make(chan struct{})}
+	if err := t.hwtstamp(siocGHwTstamp, &t.prev); err != nil {
+		return nil, fmt.Errorf("%s: %v", ifi.Name, err)
+	}
+	cfg := t.prev
+	cfg.txType = hwtstampTxOn
+	if err := t.hwtstamp(siocSHwTstamp, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", ifi.Name, err)
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, soTimestamping, tsRawHardware|tsOptID|tsOptTsonly); err != nil {
+		t.restore()
+		return nil, err
+	}
+	// the request rides on every data send
+	t.oob = make([]byte, syscall.CmsgSpace(4))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&t.oob[0]))
+	h.Level, h.Type = syscall.SOL_SOCKET, soTimestamping
+	h.SetLen(syscall.CmsgLen(4))
+	binary.LittleEndian.PutUint32(t.oob[syscall.CmsgLen(0):], tsTxHardware)
+	go t.read()
+	return t, nil
+}
+
+func (t *txStamps) hwtstamp(req uintptr, cfg *hwtstampConfig) error {
+	var ifr struct {
+		name [syscall.IFNAMSIZ]byte
+		data uintptr
+		_    [16]byte
+	}
+	copy(ifr.name[:], t.iface)
+	ifr.data = uintptr(unsafe.Pointer(cfg))
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(t.fd), req, uintptr(unsafe.Pointer(&ifr)))
+	runtime.KeepAlive(cfg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (t *txStamps) restore() {
+	_ = t.hwtstamp(siocSHwTstamp, &t.prev)
+}
+
+func (t *txStamps) write(b []byte) (int, error) {
+	n, _, err := t.con.WriteMsgUDP(b, t.oob, nil)
+	return n, err
+}
+
+// read polls the socket error queue for stamps, the regular reader
+// would otherwise be locked out of the socket.
+func (t *txStamps) read() {
+	defer close(t.done)
+	buf := make([]byte, 64)
+	oob := make([]byte, 512)
+	tick := time.NewTicker(txStampPoll)
+	defer tick.Stop()
+	for stopping := false; ; {
+		_, oobn, _, _, err := syscall.Recvmsg(t.fd, buf, oob, syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+		if err == nil {
+			t.parse(oob[:oobn])
+			continue
+		}
+		if err != syscall.EAGAIN && err != syscall.EINTR || stopping {
+			return
+		}
+		select {
+		case <-t.stop:
+			// a last look for stamps of the final sends
+			time.Sleep(10 * txStampPoll)
+			stopping = true
+		case <-tick.C:
+		}
+	}
+}
+
+func (t *txStamps) parse(oob []byte) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+	var (
+		ts time.Time
+		id uint32
+		ok bool
+	)
+	for _, m := range msgs {
+		switch {
+		case m.Header.Level == syscall.SOL_SOCKET && m.Header.Type == soTimestamping:
+			// three timespecs, the raw hardware one last
+			sz := int(unsafe.Sizeof(syscall.Timespec{}))
+			if len(m.Data) >= 3*sz {
+				spec := (*syscall.Timespec)(unsafe.Pointer(&m.Data[2*sz]))
+				ts = time.Unix(spec.Unix())
+			}
+		case m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_RECVERR,
+			m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == ipv6RecvErr:
+			if len(m.Data) >= sockExtendedErrDataAt+4 && m.Data[4] == eeOriginTimestamping {
+				id, ok = binary.LittleEndian.Uint32(m.Data[sockExtendedErrDataAt:]), true
+			}
+		}
+	}
+	if !ok || ts.IsZero() || id >= uint32(pktCount) {
+		return
+	}
+	no := uint16(id + 1)
+	if sent := echoes.restamp(no, ts); sent != 0 {
+		atomic.AddInt64(&t.delay, ts.UnixNano()-sent)
+	}
+	atomic.AddInt64(&t.n, 1)
+}
+
+// close stops reading stamps and restores the interface setting.
+func (t *txStamps) close() {
+	close(t.stop)
+	<-t.done
+	t.restore()
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+func init() {
+	unavailable["hwts"] = "linux only"
+}
+
+type txStamps struct {
+	n, delay int64
+}
+
+func startTxStamps(con net.Conn) (*txStamps, error) {
+	return nil, errors.New("hardware timestamps are linux only")
+}
+
+func (t *txStamps) write(b []byte) (int, error) {
+	return 0, errors.New("linux only")
+}
+
+func (t *txStamps) close() {}
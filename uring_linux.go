@@ -0,0 +1,433 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// A minimal io_uring for -io uring: just the rings, SEND for the client
+// and RECVMSG behind POLL_ADD for the server.
+
+const (
+	sysIoUringSetup = 425
+	sysIoUringEnter = 426
+
+	uringOpPollAdd      = 6
+	uringOpRecvmsg      = 10
+	uringOpSend         = 26
+	uringEnterGetevents = 1
+	uringSqeIoLink      = 1 << 2
+	// uringPollTag marks the user data of a slot's poll
+	uringPollTag = 1 << 32
+
+	uringOffSqRing = 0
+	uringOffCqRing = 0x8000000
+	uringOffSqes   = 0x10000000
+)
+
+// uringNrBase offsets the syscall numbers, which are the same on every
+// architecture but mips with its o32 and n64 abis.
+var uringNrBase = map[string]uintptr{"mips": 4000, "mipsle": 4000, "mips64": 5000, "mips64le": 5000}[runtime.GOARCH]
+
+// uringPollIn is POLLIN as poll32_events, which the kernel reads word
+// swapped on big endian.
+var uringPollIn = func() uint32 {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 0 {
+		return 1 << 16
+	}
+	return 1
+}()
+
+type uringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        struct {
+		head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+		userAddr                                                        uint64
+	}
+	cqOff struct {
+		head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+		userAddr                                                        uint64
+	}
+}
+
+type uringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	_           uint64
+}
+
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+type uring struct {
+	fd      int
+	mem     [][]byte
+	entries uint32
+	pending uint32
+	sqHead  *uint32
+	sqTail  *uint32
+	sqMask  *uint32
+	sqArray *[1 << 16]uint32
+	sqes    *[1 << 16]uringSQE
+	cqHead  *uint32
+	cqTail  *uint32
+	cqMask  *uint32
+	cqes    *[1 << 17]uringCQE
+}
+
+func newUring(entries uint32) (*uring, error) {
+	var p uringParams
+	fd, _, errno := syscall.Syscall(uringNrBase+sysIoUringSetup, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	r := &uring{fd: int(fd), entries: p.sqEntries}
+	mmap := func(off int64, size uint32) []byte {
+		b, err := syscall.Mmap(r.fd, off, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+		if err != nil {
+			return nil
+		}
+		r.mem = append(r.mem, b)
+		return b
+	}
+	sq := mmap(uringOffSqRing, p.sqOff.array+p.sqEntries*4)
+	cq := mmap(uringOffCqRing, p.cqOff.cqes+p.cqEntries*uint32(unsafe.Sizeof(uringCQE{})))
+	sqes := mmap(uringOffSqes, p.sqEntries*uint32(unsafe.Sizeof(uringSQE{})))
+	if sq == nil || cq == nil || sqes == nil {
+		r.close()
+		return nil, errors.New("io_uring: mmap failed")
+	}
+	u32 := func(b []byte, off uint32) *uint32 {
+		return (*uint32)(unsafe.Pointer(&b[off]))
+	}
+	r.sqHead = u32(sq, p.sqOff.head)
+	r.sqTail = u32(sq, p.sqOff.tail)
+	r.sqMask = u32(sq, p.sqOff.ringMask)
+	r.sqArray = (*[1 << 16]uint32)(unsafe.Pointer(&sq[p.sqOff.array]))
+	r.sqes = (*[1 << 16]uringSQE)(unsafe.Pointer(&sqes[0]))
+	r.cqHead = u32(cq, p.cqOff.head)
+	r.cqTail = u32(cq, p.cqOff.tail)
+	r.cqMask = u32(cq, p.cqOff.ringMask)
+	r.cqes = (*[1 << 17]uringCQE)(unsafe.Pointer(&cq[p.cqOff.cqes]))
+	return r, nil
+}
+
+// push queues e for the next enter and reports false if the
+// submission queue is full.
+func (r *uring) push(e uringSQE) bool {
+	tail := *r.sqTail
+	if tail-atomic.LoadUint32(r.sqHead) >= r.entries {
+		return false
+	}
+	i := tail & *r.sqMask
+	r.sqes[i] = e
+	r.sqArray[i] = i
+	atomic.StoreUint32(r.sqTail, tail+1)
+	r.pending++
+	return true
+}
+
+// enter submits the queued entries and waits for min completions.
+func (r *uring) enter(min uint32) error {
+	var flags uintptr
+	if min > 0 {
+		flags = uringEnterGetevents
+	}
+	for {
+		n, _, errno := syscall.Syscall6(uringNrBase+sysIoUringEnter, uintptr(r.fd), uintptr(r.pending), uintptr(min), flags, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return errno
+		}
+		r.pending -= uint32(n)
+		return nil
+	}
+}
+
+// reap consumes the available completions.
+func (r *uring) reap(f func(e uringCQE)) {
+	head := *r.cqHead
+	for tail := atomic.LoadUint32(r.cqTail); head != tail; head++ {
+		f(r.cqes[head&*r.cqMask])
+	}
+	atomic.StoreUint32(r.cqHead, head)
+}
+
+func (r *uring) close() {
+	for _, b := range r.mem {
+		_ = syscall.Munmap(b)
+	}
+	_ = syscall.Close(r.fd)
+}
+
+func sysFd(con syscall.Conn) (int, error) {
+	rc, err := con.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var fd int
+	err = rc.Control(func(f uintptr) {
+		fd = int(f)
+	})
+	return fd, err
+}
+
+// uringConn sends through io_uring. Writes return once the send is
+// submitted, their errors are reported by a later Write. Sends the
+// socket buffer has no room for are repeated with a standard write.
+// Control packets are written from other goroutines than the data.
+type uringConn struct {
+	*net.UDPConn
+	mu   sync.Mutex
+	r    *uring
+	fd   int
+	bufs [][]byte
+	lens []int
+	free []int
+	err  error
+}
+
+func withUring(con net.Conn) (net.Conn, error) {
+	uc, ok := con.(*net.UDPConn)
+	if !ok {
+		return nil, errors.New("io_uring needs a plain udp socket")
+	}
+	fd, err := sysFd(uc)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newUring(256)
+	if err != nil {
+		return nil, err
+	}
+	c := &uringConn{UDPConn: uc, r: r, fd: fd}
+	size := datagramSize()
+	if pktSize*gsoSegs > size {
+		size = pktSize * gsoSegs
+	}
+	for i := 0; i < int(r.entries); i++ {
+		c.bufs = append(c.bufs, make([]byte, size))
+		c.lens = append(c.lens, 0)
+		c.free = append(c.free, i)
+	}
+	return c, nil
+}
+
+func (c *uringConn) Write(b []byte) (int, error) {
+	if len(b) == 0 || len(b) > len(c.bufs[0]) {
+		return c.UDPConn.Write(b)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.complete(0)
+	if len(c.free) == 0 {
+		c.complete(1)
+	}
+	if c.err != nil {
+		err := c.err
+		c.err = nil
+		return 0, err
+	}
+	slot := c.free[len(c.free)-1]
+	c.free = c.free[:len(c.free)-1]
+	n := copy(c.bufs[slot], b)
+	c.lens[slot] = n
+	c.r.push(uringSQE{
+		opcode:   uringOpSend,
+		fd:       int32(c.fd),
+		addr:     uint64(uintptr(unsafe.Pointer(&c.bufs[slot][0]))),
+		len:      uint32(n),
+		userData: uint64(slot),
+	})
+	if err := c.r.enter(0); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// complete collects finished sends, waiting for at least min. c.mu
+// is held.
+func (c *uringConn) complete(min uint32) {
+	if min > 0 {
+		if err := c.r.enter(min); err != nil && c.err == nil {
+			c.err = err
+		}
+	}
+	c.r.reap(func(e uringCQE) {
+		slot := int(e.userData)
+		err := error(nil)
+		if e.res < 0 {
+			err = syscall.Errno(-e.res)
+		}
+		if err == syscall.EAGAIN {
+			// the socket is non-blocking for Go, which waits
+			_, err = c.UDPConn.Write(c.bufs[slot][:c.lens[slot]])
+		}
+		if err != nil && c.err == nil {
+			c.err = err
+		}
+		c.free = append(c.free, slot)
+	})
+}
+
+// Close waits for the sends in flight, the kernel still reads their
+// buffers.
+func (c *uringConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.free) < len(c.bufs) && c.err == nil {
+		c.complete(1)
+	}
+	c.r.close()
+	return c.UDPConn.Close()
+}
+
+// uringRecv is one receive kept posted to the ring.
+type uringRecv struct {
+	it   *rxItem
+	name [syscall.SizeofSockaddrInet6]byte
+	iov  syscall.Iovec
+	msg  syscall.Msghdr
+}
+
+// startUringDrain fills the queue of q from receives posted to an
+// io_uring instead of one read call per packet.
+func startUringDrain(q *queuedConn) error {
+	uc, ok := q.PacketConn.(*net.UDPConn)
+	if !ok {
+		return errors.New("io_uring needs a plain udp socket")
+	}
+	own, err := sysFd(uc)
+	if err != nil {
+		return err
+	}
+	// the ring keeps its own descriptor, the runtime may close and
+	// reuse the number of the one it owns
+	fd, err := syscall.Dup(own)
+	if err != nil {
+		return err
+	}
+	r, err := newUring(64)
+	if err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	// io_uring fails receives on a non-blocking socket when there is
+	// nothing to read instead of waiting, so every receive is linked
+	// behind a poll; the socket stays non-blocking for Go's writes and
+	// deadlines. A slot takes two entries.
+	slots := make([]uringRecv, r.entries/2)
+	post := func(i int) {
+		s := &slots[i]
+		s.it = q.pool.Get().(*rxItem)
+		s.iov.Base = &s.it.buf[0]
+		s.iov.SetLen(len(s.it.buf))
+		s.msg.Name = &s.name[0]
+		s.msg.Namelen = uint32(len(s.name))
+		s.msg.Iov = &s.iov
+		s.msg.Iovlen = 1
+		s.msg.Control = &s.it.oob[0]
+		s.msg.SetControllen(len(s.it.oob))
+		r.push(uringSQE{
+			opcode:   uringOpPollAdd,
+			flags:    uringSqeIoLink,
+			fd:       int32(fd),
+			opFlags:  uringPollIn,
+			userData: uint64(i) | uringPollTag,
+		})
+		r.push(uringSQE{
+			opcode:   uringOpRecvmsg,
+			fd:       int32(fd),
+			addr:     uint64(uintptr(unsafe.Pointer(&s.msg))),
+			len:      1,
+			userData: uint64(i),
+		})
+	}
+	for i := range slots {
+		post(i)
+	}
+	go func() {
+		defer syscall.Close(fd)
+		defer r.close()
+		for {
+			if err := r.enter(1); err != nil {
+				q.queue <- &rxItem{err: err}
+				return
+			}
+			var failed error
+			r.reap(func(e uringCQE) {
+				if e.userData&uringPollTag != 0 && e.res >= 0 {
+					return
+				}
+				i := int(e.userData &^ uringPollTag)
+				if e.res == -int32(syscall.EAGAIN) {
+					// another slot's receive took the datagram
+					q.pool.Put(slots[i].it)
+					post(i)
+					return
+				}
+				if e.res < 0 {
+					failed = syscall.Errno(-e.res)
+					return
+				}
+				s := &slots[i]
+				it := s.it
+				it.n, it.oobn, it.addr, it.err = int(e.res), int(s.msg.Controllen), sockaddrUDP(s.name[:]), nil
+				q.push(it)
+				post(i)
+			})
+			if failed != nil {
+				q.queue <- &rxItem{err: failed}
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// sockaddrUDP decodes a raw sockaddr_in or sockaddr_in6.
+func sockaddrUDP(b []byte) *net.UDPAddr {
+	port := int(b[2])<<8 | int(b[3])
+	switch *(*uint16)(unsafe.Pointer(&b[0])) {
+	case syscall.AF_INET:
+		return &net.UDPAddr{IP: net.IP(append([]byte{}, b[4:8]...)), Port: port}
+	case syscall.AF_INET6:
+		a := &net.UDPAddr{IP: net.IP(append([]byte{}, b[8:24]...)), Port: port}
+		if id := *(*uint32)(unsafe.Pointer(&b[24])); id != 0 {
+			if ifi, err := net.InterfaceByIndex(int(id)); err == nil {
+				a.Zone = ifi.Name
+			}
+		}
+		return a
+	}
+	return nil
+}
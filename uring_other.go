@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+func init() {
+	unavailable["uring"] = "linux only"
+}
+
+func withUring(con net.Conn) (net.Conn, error) {
+	return nil, errors.New("io_uring is linux only")
+}
+
+func startUringDrain(q *queuedConn) error {
+	return errors.New("io_uring is linux only")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// The start command carries the client's protocol version and seen
+// answers with the version both sides then speak, the lower one.
+// Servers and clients that tell none speak version 1. A client turns
+// off what its server does not understand and runs a basic loss test
+// rather than one the server counts wrong.
+
+// peerVersion is the protocol version agreed for the current test.
+var peerVersion = protoVersion
+
+// versioned is an option needing the other side to speak version.
+type versioned struct {
+	version int
+	flag    string
+	used    func() bool
+	off     func()
+}
+
+var versionedFeatures = []versioned{
+	{2, "-file", func() bool { return fileData != nil }, func() { fileData, pktCount = nil, cntGiven }},
+}
+
+// agreeVersion returns the version to speak with a peer telling v.
+func agreeVersion(v int) int {
+	if v < 1 {
+		return 1
+	}
+	if v > protoVersion {
+		return protoVersion
+	}
+	return v
+}
+
+// parseVersion returns the version in control arguments.
+func parseVersion(args []string) int {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == "version" {
+			v, _ := strconv.Atoi(args[i+1])
+			return agreeVersion(v)
+		}
+	}
+	return 1
+}
+
+// downgrade turns off the requested options peerVersion lacks.
+func downgrade() {
+	for _, f := range versionedFeatures {
+		if peerVersion < f.version && f.used() {
+			fmt.Fprintf(os.Stderr, "%s: the server speaks protocol version %d, %d needed, continuing without\n", f.flag, peerVersion, f.version)
+			f.off()
+		}
+	}
+}
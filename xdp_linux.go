@@ -0,0 +1,275 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// Experimental AF_XDP receive for -xdp: an XDP program on the interface
+// redirects udp packets for the test port arriving on queue 0 to an XDP
+// socket, bypassing the kernel udp stack. Everything else, packets on
+// other queues included, still reaches the socket.
+
+const (
+	afXDP  = 44
+	solXDP = 283
+
+	xdpMmapOffsets        = 1
+	xdpRxRing             = 2
+	xdpUmemReg            = 4
+	xdpUmemFillRing       = 5
+	xdpUmemCompletionRing = 6
+	xdpCopy               = 1 << 1
+	xdpFlagsSkbMode       = 1 << 1
+	xdpPgoffRxRing        = 0
+	xdpUmemPgoffFillRing  = 0x100000000
+	xdpFrameSize          = 2048
+	xdpFrames             = 2048 // also the ring sizes
+	xdpPass               = 2
+	xdpQueue              = 0
+)
+
+// xdpPackets counts packets received through the XDP socket.
+var xdpPackets int64
+
+// xdpProgram redirects udp to port over IPv4 without options or IPv6
+// without extension headers to the socket in the map for the receive
+// queue and passes everything else.
+func xdpProgram(mapFd, port int) []bpfInsn {
+	p := []bpfInsn{
+		insn(bpfMovx, 6, 1, 0, 0),                    // 0: r6 = ctx
+		insn(bpfLdxw, 2, 6, 0, 0),                    // 1: r2 = data
+		insn(bpfLdxw, 3, 6, 4, 0),                    // 2: r3 = data_end
+		insn(bpfMovx, 4, 2, 0, 0),                    // 3
+		insn(bpfAddk, 4, 0, 0, 14+20+8),              // 4
+		insn(bpfJgtx, 4, 3, 25, 0),                   // 5: short, pass
+		insn(bpfLdxh, 5, 2, 12, 0),                   // 6: ethertype
+		insn(bpfJeqk, 5, 0, 8, be16(0x86dd)),         // 7: to 16
+		insn(bpfJnek, 5, 0, 22, be16(0x0800)),        // 8: pass
+		insn(bpfLdxb, 5, 2, 14, 0),                   // 9: version and ihl
+		insn(bpfJnek, 5, 0, 20, 0x45),                // 10: pass
+		insn(bpfLdxb, 5, 2, 14+9, 0),                 // 11: protocol
+		insn(bpfJnek, 5, 0, 18, syscall.IPPROTO_UDP), // 12: pass
+		insn(bpfMovx, 7, 2, 0, 0),                    // 13: r7 = udp header
+		insn(bpfAddk, 7, 0, 0, 14+20),                // 14
+		insn(bpfJa, 0, 0, 7, 0),                      // 15: to 23
+		insn(bpfMovx, 4, 2, 0, 0),                    // 16: ipv6
+		insn(bpfAddk, 4, 0, 0, 14+40+8),              // 17
+		insn(bpfJgtx, 4, 3, 12, 0),                   // 18: pass
+		insn(bpfLdxb, 5, 2, 14+6, 0),                 // 19: next header
+		insn(bpfJnek, 5, 0, 10, syscall.IPPROTO_UDP), // 20: pass
+		insn(bpfMovx, 7, 2, 0, 0),                    // 21
+		insn(bpfAddk, 7, 0, 0, 14+40),                // 22
+		insn(bpfLdxh, 5, 7, 2, 0),                    // 23: destination port
+		insn(bpfJnek, 5, 0, 6, be16(uint16(port))),   // 24: pass
+	}
+	p = append(p, ldMap(1, mapFd)...) // 25
+	return append(p,
+		insn(bpfLdxw, 2, 6, 16, 0),      // 27: rx_queue_index
+		insn(bpfMovk, 3, 0, 0, xdpPass), // 28: if it has no socket
+		insn(bpfCall, 0, 0, 0, bpfHelperRedirectMap),
+		insn(bpfExit, 0, 0, 0, 0),
+		insn(bpfMovk, 0, 0, 0, xdpPass), // 31: pass
+		insn(bpfExit, 0, 0, 0, 0),
+	)
+}
+
+type xdpRingOffset struct {
+	producer, consumer, desc, flags uint64
+}
+
+type xdpDesc struct {
+	addr    uint64
+	len     uint32
+	options uint32
+}
+
+// xdpSocket is an AF_XDP socket with just the rings receiving needs.
+type xdpSocket struct {
+	fd       int
+	umem     []byte
+	rxProd   *uint32
+	rxCons   *uint32
+	rx       *[xdpFrames]xdpDesc
+	fillProd *uint32
+	fill     *[xdpFrames]uint64
+}
+
+func sockopt(fd, opt int, p unsafe.Pointer, size uintptr) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(fd), solXDP, uintptr(opt), uintptr(p), size, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func newXDPSocket(ifindex int) (*xdpSocket, error) {
+	fd, err := syscall.Socket(afXDP, syscall.SOCK_RAW, 0)
+	if err != nil {
+		return nil, err
+	}
+	x := &xdpSocket{fd: fd}
+	if err := x.setup(ifindex); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return x, nil
+}
+
+func (x *xdpSocket) setup(ifindex int) error {
+	var err error
+	x.umem, err = syscall.Mmap(-1, 0, xdpFrames*xdpFrameSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS)
+	if err != nil {
+		return err
+	}
+	reg := struct {
+		addr, len           uint64
+		chunkSize, headroom uint32
+	}{uint64(uintptr(unsafe.Pointer(&x.umem[0]))), uint64(len(x.umem)), xdpFrameSize, 0}
+	if err := sockopt(x.fd, xdpUmemReg, unsafe.Pointer(&reg), unsafe.Sizeof(reg)); err != nil {
+		return err
+	}
+	for _, opt := range []int{xdpUmemFillRing, xdpUmemCompletionRing, xdpRxRing} {
+		if err := syscall.SetsockoptInt(x.fd, solXDP, opt, xdpFrames); err != nil {
+			return err
+		}
+	}
+	var off struct{ rx, tx, fill, completion xdpRingOffset }
+	size := unsafe.Sizeof(off)
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, uintptr(x.fd), solXDP, xdpMmapOffsets,
+		uintptr(unsafe.Pointer(&off)), uintptr(unsafe.Pointer(&size)), 0)
+	if errno != 0 {
+		return errno
+	}
+	rx, err := syscall.Mmap(x.fd, xdpPgoffRxRing, int(off.rx.desc)+xdpFrames*int(unsafe.Sizeof(xdpDesc{})),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		return err
+	}
+	fill, err := syscall.Mmap(x.fd, xdpUmemPgoffFillRing, int(off.fill.desc)+xdpFrames*8,
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		return err
+	}
+	x.rxProd = (*uint32)(unsafe.Pointer(&rx[off.rx.producer]))
+	x.rxCons = (*uint32)(unsafe.Pointer(&rx[off.rx.consumer]))
+	x.rx = (*[xdpFrames]xdpDesc)(unsafe.Pointer(&rx[off.rx.desc]))
+	x.fillProd = (*uint32)(unsafe.Pointer(&fill[off.fill.producer]))
+	x.fill = (*[xdpFrames]uint64)(unsafe.Pointer(&fill[off.fill.desc]))
+	for i := range x.fill {
+		x.fill[i] = uint64(i * xdpFrameSize)
+	}
+	atomic.StoreUint32(x.fillProd, xdpFrames)
+
+	sa := struct {
+		family, flags    uint16
+		ifindex, queueID uint32
+		sharedUmemFd     uint32
+	}{afXDP, xdpCopy, uint32(ifindex), xdpQueue, 0}
+	_, _, errno = syscall.Syscall(syscall.SYS_BIND, uintptr(x.fd), uintptr(unsafe.Pointer(&sa)), unsafe.Sizeof(sa))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// attachXDP loads the redirect program for port and attaches it to the
+// interface until the process exits.
+func attachXDP(x *xdpSocket, ifindex, port int) error {
+	mapFd, err := bpfMap(bpfMapTypeXskmap, 4, 4, 64)
+	if err != nil {
+		return err
+	}
+	key, val := uint32(xdpQueue), uint32(x.fd)
+	if err := bpfUpdate(mapFd, unsafe.Pointer(&key), unsafe.Pointer(&val)); err != nil {
+		return err
+	}
+	progFd, err := bpfLoad(bpfProgTypeXDP, xdpProgram(mapFd, port))
+	if err != nil {
+		return err
+	}
+	attr := struct {
+		progFd, ifindex, attachType, flags uint32
+	}{uint32(progFd), uint32(ifindex), bpfAttachXDP, xdpFlagsSkbMode}
+	_, err = bpf(bpfLinkCreate, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	return err
+}
+
+// receive pushes the udp payloads arriving on the socket to q.
+func (x *xdpSocket) receive(q *queuedConn) {
+	pfd := struct {
+		fd             int32
+		events, revent int16
+	}{int32(x.fd), 1, 0} // POLLIN
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&pfd)), 1, 0, 0, 0, 0)
+		if errno != 0 && errno != syscall.EINTR {
+			return
+		}
+		cons, fill := *x.rxCons, *x.fillProd
+		for prod := atomic.LoadUint32(x.rxProd); cons != prod; cons++ {
+			d := x.rx[cons%xdpFrames]
+			if it := xdpItem(q, x.umem[d.addr:d.addr+uint64(d.len)]); it != nil {
+				atomic.AddInt64(&xdpPackets, 1)
+				q.push(it)
+			}
+			x.fill[fill%xdpFrames] = d.addr &^ (xdpFrameSize - 1)
+			fill++
+		}
+		atomic.StoreUint32(x.fillProd, fill)
+		atomic.StoreUint32(x.rxCons, cons)
+	}
+}
+
+// xdpItem copies the udp payload and source of frame to a queue item.
+func xdpItem(q *queuedConn, frame []byte) *rxItem {
+	var ip net.IP
+	var udp []byte
+	switch {
+	case len(frame) >= 42 && binary.BigEndian.Uint16(frame[12:]) == 0x0800:
+		ip, udp = net.IP(append([]byte(nil), frame[26:30]...)), frame[34:]
+	case len(frame) >= 62:
+		ip, udp = net.IP(append([]byte(nil), frame[22:38]...)), frame[54:]
+	default:
+		return nil
+	}
+	n := int(binary.BigEndian.Uint16(udp[4:])) - 8
+	if n < 0 || 8+n > len(udp) {
+		return nil
+	}
+	it := q.pool.Get().(*rxItem)
+	it.n = copy(it.buf, udp[8:8+n])
+	it.oobn = 0
+	it.err = nil
+	it.addr = &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(udp))}
+	return it
+}
+
+func startXDP(q *queuedConn, iface string) error {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return err
+	}
+	la, ok := q.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return errors.New("xdp needs a udp socket")
+	}
+	x, err := newXDPSocket(ifi.Index)
+	if err != nil {
+		return err
+	}
+	if err := attachXDP(x, ifi.Index, la.Port); err != nil {
+		syscall.Close(x.fd)
+		return err
+	}
+	go x.receive(q)
+	return nil
+}
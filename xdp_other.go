@@ -0,0 +1,22 @@
+//go:build !linux || !(amd64 || arm64)
+// +build !linux !amd64,!arm64
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+func init() {
+	unavailable["xdp"] = "linux only"
+	if runtime.GOOS == "linux" {
+		unavailable["xdp"] = "amd64 and arm64 only"
+	}
+}
+
+var xdpPackets int64
+
+func startXDP(q *queuedConn, iface string) error {
+	return errors.New("AF_XDP needs linux on amd64 or arm64")
+}